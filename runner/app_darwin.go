@@ -4,12 +4,17 @@ package runner
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
 	"sort"
 	"strconv"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/itchio/ox/macox"
 )
@@ -57,7 +62,7 @@ func (ar *appRunner) Prepare() error {
 	return nil
 }
 
-func (ar *appRunner) Run() error {
+func (ar *appRunner) Run() (*ExitResult, error) {
 	consumer := ar.params.Consumer
 	if ar.simpleRunner != nil {
 		consumer.Infof("Mac app runner here, delegating run to simple runner")
@@ -70,21 +75,28 @@ func (ar *appRunner) Run() error {
 	)
 }
 
-func RunAppBundle(params RunnerParams, bundlePath string) error {
+// RunAppBundle launches bundlePath via 'open -W' and waits for it to exit.
+// The reported exit status is the one of 'open', not of the app itself.
+func RunAppBundle(params RunnerParams, bundlePath string) (*ExitResult, error) {
 	consumer := params.Consumer
 
-	var args = []string{
-		"-W",
-		bundlePath,
-		"--args",
+	var args = []string{"-W"}
+	if params.Stdin != nil {
+		stdinPath, cleanup, err := appStdinPath(params.Stdin)
+		if err != nil {
+			return nil, fmt.Errorf("while setting up stdin: %w", err)
+		}
+		defer cleanup()
+		args = append(args, "--stdin", stdinPath)
 	}
+	args = append(args, bundlePath, "--args")
 	args = append(args, params.Args...)
 
 	consumer.Infof("App bundle is (%s)", bundlePath)
 
 	binaryPath, err := macox.GetExecutablePath(bundlePath)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	consumer.Infof("Actual binary is (%s)", binaryPath)
@@ -95,6 +107,12 @@ func RunAppBundle(params RunnerParams, bundlePath string) error {
 	cmd.Env = params.Env
 	// 'open' does not relay stdout or stderr, so we don't
 	// even bother setting them
+	if params.LogParams.Enabled {
+		consumer.Infof("Output of app bundles can't be captured, not writing a session log")
+	}
+	if params.OnOutputLine != nil {
+		consumer.Infof("Output of app bundles can't be captured, no output lines will be reported")
+	}
 
 	preLaunchPIDs, err := matchingPIDs(binaryPath)
 	havePreLaunchSnapshot := err == nil
@@ -102,6 +120,10 @@ func RunAppBundle(params RunnerParams, bundlePath string) error {
 		consumer.Warnf("Could not snapshot existing app PIDs before launch: %s", err.Error())
 	}
 
+	res := newExitResult()
+	res.Backend = BackendApp
+	var killed atomic.Bool
+
 	processDone := make(chan struct{})
 	interruptSignals := make(chan os.Signal, 1)
 	signal.Notify(interruptSignals, os.Interrupt)
@@ -120,6 +142,7 @@ func RunAppBundle(params RunnerParams, bundlePath string) error {
 			return
 		}
 
+		killed.Store(true)
 		consumer.Warnf("Killing app...")
 		postLaunchPIDs, err := matchingPIDs(binaryPath)
 		if err != nil {
@@ -154,13 +177,68 @@ func RunAppBundle(params RunnerParams, bundlePath string) error {
 		}
 	}()
 
+	startTime := time.Now()
 	err = cmd.Run()
 	close(processDone)
+	res.Duration = time.Since(startTime)
+	res.Cancelled = killed.Load()
+	res.setProcessState(cmd.ProcessState)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		if cmd.ProcessState == nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		return res, fmt.Errorf("%w", err)
 	}
 
-	return nil
+	return res, nil
+}
+
+// appStdinPath returns a path 'open --stdin' can connect an app's stdin
+// to. Regular files are passed as-is, other readers are copied through a
+// named pipe, which 'open' only passes the path of.
+func appStdinPath(stdin io.Reader) (string, func(), error) {
+	if f, ok := stdin.(*os.File); ok {
+		if stat, err := f.Stat(); err == nil && stat.Mode().IsRegular() {
+			return f.Name(), func() {}, nil
+		}
+	}
+
+	dir, err := os.MkdirTemp("", "smaug-stdin-")
+	if err != nil {
+		return "", nil, fmt.Errorf("%w", err)
+	}
+	fifoPath := filepath.Join(dir, "stdin")
+	err = syscall.Mkfifo(fifoPath, 0600)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", nil, fmt.Errorf("%w", err)
+	}
+
+	copyDone := make(chan struct{})
+	go func() {
+		defer close(copyDone)
+		// Blocks until the app opens the pipe for reading.
+		w, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+		if err != nil {
+			return
+		}
+		defer w.Close()
+		io.Copy(w, stdin)
+	}()
+
+	cleanup := func() {
+		// If the app never opened the pipe, open it ourselves so that the
+		// copy goroutine doesn't stay blocked.
+		if r, err := os.OpenFile(fifoPath, os.O_RDONLY|syscall.O_NONBLOCK, 0); err == nil {
+			r.Close()
+		}
+		select {
+		case <-copyDone:
+		case <-time.After(time.Second):
+		}
+		os.RemoveAll(dir)
+	}
+	return fifoPath, cleanup, nil
 }
 
 func matchingPIDs(binaryPath string) (map[int]struct{}, error) {
@@ -78,7 +78,7 @@ func TestRunAppBundleCancellationKillsOnlyNewPIDs(t *testing.T) {
 		cancel()
 	}()
 
-	err := RunAppBundle(
+	res, err := RunAppBundle(
 		RunnerParams{
 			Consumer: newSandboxExecTestConsumer(t),
 			Ctx:      ctx,
@@ -86,6 +86,8 @@ func TestRunAppBundleCancellationKillsOnlyNewPIDs(t *testing.T) {
 		bundlePath,
 	)
 	require.NoError(t, err)
+	assert.True(t, res.Cancelled)
+	assert.Equal(t, BackendApp, res.Backend)
 
 	killMu.Lock()
 	defer killMu.Unlock()
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"path/filepath"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/itchio/ox/syscallex"
@@ -154,9 +155,17 @@ func (ar *attachRunner) bringWindowsToForeground() {
 	consumer.Infof("Brought %d windows to front, ignored %d invisible windows", visibleWindowCount, invisibleWindowCount)
 }
 
-func (ar *attachRunner) Run() error {
+func (ar *attachRunner) Run() (*ExitResult, error) {
 	consumer := ar.params.Consumer
 
+	if ar.params.Stdin != nil {
+		consumer.Warnf("Attaching to an already-running process, stdin can't be passed")
+	}
+
+	res := newExitResult()
+	res.Backend = BackendAttach
+	startTime := time.Now()
+
 	cancel := make(chan struct{})
 	defer close(cancel)
 
@@ -172,17 +181,24 @@ func (ar *attachRunner) Run() error {
 		}
 	}()
 
-	processHandle, err := syscall.OpenProcess(syscall.SYNCHRONIZE, false, ar.pid)
+	processHandle, err := syscall.OpenProcess(syscall.SYNCHRONIZE|syscall.PROCESS_QUERY_INFORMATION, false, ar.pid)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 	defer winox.SafeRelease(uintptr(processHandle))
 
 	consumer.Infof("Attached to PID (%d)", ar.pid)
 	_, err = syscall.WaitForSingleObject(processHandle, syscall.INFINITE)
+	res.Duration = time.Since(startTime)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return res, fmt.Errorf("%w", err)
 	}
 
-	return nil
+	res.Cancelled = ar.params.Ctx.Err() != nil
+	var exitCode uint32
+	if err := syscall.GetExitCodeProcess(processHandle, &exitCode); err == nil {
+		res.ExitCode = int(exitCode)
+	}
+
+	return res, nil
 }
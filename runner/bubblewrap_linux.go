@@ -15,6 +15,7 @@ type bubblewrapRunner struct {
 }
 
 var _ Runner = (*bubblewrapRunner)(nil)
+var _ Starter = (*bubblewrapRunner)(nil)
 var bubblewrapCommand = exec.Command
 
 func newBubblewrapRunner(params RunnerParams) (Runner, error) {
@@ -33,200 +34,182 @@ func (br *bubblewrapRunner) Prepare() error {
 	return nil
 }
 
-func (br *bubblewrapRunner) Run() error {
+func (br *bubblewrapRunner) Start() (*Handle, error) {
 	params := br.params
 	consumer := params.Consumer
 
 	bwrapPath := params.BubblewrapParams.BinaryPath
 	msg := fmt.Sprintf("Running (%s) through bubblewrap", params.FullTargetPath)
-	if params.SandboxConfig.NoNetwork {
+	if launchSandboxPolicy(params).Network == NetworkNone {
 		msg += " (networking disabled)"
 	}
 	consumer.Opf("%s", msg)
 
-	var args []string
-
-	// Read-only system mounts
-	for _, dir := range []string{"/usr", "/lib", "/lib64", "/bin", "/sbin", "/etc"} {
-		if _, err := os.Stat(dir); err == nil {
-			args = append(args, "--ro-bind", dir, dir)
-		}
-	}
-	if _, err := os.Stat("/sys"); err == nil {
-		args = append(args, "--ro-bind", "/sys", "/sys")
-	}
-
-	// Basic filesystem
-	args = append(args, "--proc", "/proc")
-	args = append(args, "--dev", "/dev")
-	args = append(args, "--tmpfs", "/tmp")
+	args := bubblewrapArgs(params)
 
-	// GPU access
-	if _, err := os.Stat("/dev/dri"); err == nil {
-		args = append(args, "--dev-bind", "/dev/dri", "/dev/dri")
+	seccompFile, err := seccompProgramFile(params)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
 	}
-	if nvidiaPaths, err := filepath.Glob("/dev/nvidia*"); err == nil {
-		for _, nvidiaPath := range nvidiaPaths {
-			if _, err := os.Stat(nvidiaPath); err == nil {
-				args = append(args, "--dev-bind", nvidiaPath, nvidiaPath)
-			}
-		}
+	if seccompFile != nil {
+		// bwrap has read it by the time the game runs.
+		defer seccompFile.Close()
+		args = append([]string{"--seccomp", "3"}, args...)
 	}
 
-	// Controller input devices
-	if _, err := os.Stat("/dev/input"); err == nil {
-		args = append(args, "--dev-bind", "/dev/input", "/dev/input")
+	cmd := bubblewrapCommand(bwrapPath, args...)
+	cmd.Dir = params.Dir
+	cmd.Env = params.Env
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+	if seccompFile != nil {
+		// Becomes fd 3.
+		cmd.ExtraFiles = []*os.File{seccompFile}
 	}
 
-	// ALSA devices
-	if _, err := os.Stat("/dev/snd"); err == nil {
-		args = append(args, "--dev-bind", "/dev/snd", "/dev/snd")
-	}
+	// bwrap doesn't touch rlimits, so limits set on it are inherited by
+	// the sandboxed process.
+	applyResourceLimits(params, cmd)
 
-	createdSandboxDirs := make(map[string]struct{})
+	return startCommand(params, cmd, BackendBubblewrap)
+}
 
-	// Give sandboxed apps a persistent per-game home directory.
-	homeTarget, hasHome := envLookupWithPresence(params.Env, "HOME")
-	if !hasHome {
-		homeTarget = os.Getenv("HOME")
-	}
-	if params.InstallFolder != "" && filepath.IsAbs(homeTarget) {
-		homeSource := filepath.Join(params.InstallFolder, ".itch", "home")
-		if err := os.MkdirAll(homeSource, 0o755); err != nil {
-			consumer.Warnf("Could not make sandbox home directory (%s): %s", homeSource, err.Error())
-		} else {
-			ensureSandboxParentDirs(&args, createdSandboxDirs, homeTarget)
-			args = append(args, "--bind", homeSource, homeTarget)
-		}
+func (br *bubblewrapRunner) Run() (*ExitResult, error) {
+	h, err := br.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
 	}
+	return h.Wait()
+}
 
-	// Game install folder (read-write)
-	if params.InstallFolder != "" {
-		args = append(args, "--bind", params.InstallFolder, params.InstallFolder)
-	}
+// bubblewrapArgs returns the bwrap command line of a launch, without bwrap
+// itself, compiled from its sandbox policy. The native runner implements
+// the same options, so that both sandboxes are set up alike.
+func bubblewrapArgs(params RunnerParams) []string {
+	consumer := params.Consumer
+	policy := launchSandboxPolicy(params)
 
-	// Temp directory (read-write)
-	if params.TempDir != "" {
-		args = append(args, "--bind", params.TempDir, params.TempDir)
-	}
+	var args []string
 
-	// Working directory if different from install folder
-	if params.Dir != "" && params.Dir != params.InstallFolder {
-		args = append(args, "--bind", params.Dir, params.Dir)
+	// Basic filesystem, which devices are mounted over.
+	if policy.Namespaces.PID {
+		args = append(args, "--proc", "/proc")
+	} else {
+		// A new procfs can only be mounted from a new PID namespace.
+		args = append(args, "--ro-bind", "/proc", "/proc")
 	}
+	args = append(args, "--dev", "/dev")
 
-	// Display/audio socket mounts
-	xdgRuntimeDir := envLookup(params.Env, "XDG_RUNTIME_DIR")
-	if xdgRuntimeDir == "" {
-		xdgRuntimeDir = os.Getenv("XDG_RUNTIME_DIR")
-	}
+	createdSandboxDirs := make(map[string]struct{})
 
-	// X11
-	if _, err := os.Stat("/tmp/.X11-unix"); err == nil {
-		args = append(args, "--ro-bind", "/tmp/.X11-unix", "/tmp/.X11-unix")
+	var sandboxHome string
+	homeTarget, hasHome := envLookupWithPresence(params.Env, "HOME")
+	if !hasHome {
+		homeTarget = os.Getenv("HOME")
 	}
 
-	// X11 authentication
-	xauthority := envLookup(params.Env, "XAUTHORITY")
-	if xauthority == "" {
-		xauthority = os.Getenv("XAUTHORITY")
-	}
-	if xauthority == "" {
-		// Default location if XAUTHORITY is not set
-		if home := os.Getenv("HOME"); home != "" {
-			defaultPath := home + "/.Xauthority"
-			if _, err := os.Stat(defaultPath); err == nil {
-				xauthority = defaultPath
+	for i, rule := range policy.Filesystem {
+		switch rule.Access {
+		case FilesystemReadOnly, FilesystemReadWrite:
+			if rule.Source == "" && isExposedBy(policy.Filesystem[:i], rule.Path, rule.Access) {
+				continue
 			}
-		}
-	}
-	if xauthority != "" {
-		if _, err := os.Stat(xauthority); err == nil {
-			ensureSandboxParentDirs(&args, createdSandboxDirs, xauthority)
-			args = append(args, "--ro-bind", xauthority, xauthority)
-		}
-	}
-
-	if xdgRuntimeDir != "" {
-		// Wayland
-		waylandDisplay := envLookup(params.Env, "WAYLAND_DISPLAY")
-		if waylandDisplay == "" {
-			waylandDisplay = os.Getenv("WAYLAND_DISPLAY")
-		}
-		if waylandDisplay != "" {
-			socketPath := xdgRuntimeDir + "/" + waylandDisplay
-			if _, err := os.Stat(socketPath); err == nil {
-				ensureSandboxParentDirs(&args, createdSandboxDirs, socketPath)
-				args = append(args, "--ro-bind", socketPath, socketPath)
+			if rule.Source != "" && rule.Access == FilesystemReadWrite {
+				if err := os.MkdirAll(rule.Source, 0o755); err != nil {
+					consumer.Warnf("Could not make sandbox directory (%s): %s", rule.Source, err.Error())
+					continue
+				}
+			}
+			if _, err := os.Stat(rule.hostPath()); err != nil && rule.Access == FilesystemReadOnly {
+				continue
+			}
+			if rule.Source != "" {
+				ensureSandboxParentDirs(&args, createdSandboxDirs, rule.Path)
+			}
+			option := "--ro-bind"
+			if rule.Access == FilesystemReadWrite {
+				option = "--bind"
+			}
+			args = append(args, option, rule.hostPath(), rule.Path)
+			if rule.Source != "" && rule.Path == homeTarget {
+				sandboxHome = rule.Path
+			}
+		case FilesystemTmpfs:
+			ensureSandboxParentDirs(&args, createdSandboxDirs, rule.Path)
+			args = append(args, "--tmpfs", rule.Path)
+		case FilesystemDeny:
+			// Hidden under an empty tmpfs or file, wherever earlier rules
+			// expose it.
+			target, ok := exposedAt(policy.Filesystem[:i], rule.Path)
+			if !ok {
+				continue
+			}
+			info, err := os.Stat(rule.Path)
+			if err != nil {
+				continue
+			}
+			if info.IsDir() {
+				args = append(args, "--tmpfs", target)
+			} else {
+				args = append(args, "--ro-bind", "/dev/null", target)
 			}
 		}
+	}
 
-		// PulseAudio
-		pulsePath := xdgRuntimeDir + "/pulse"
-		if _, err := os.Stat(pulsePath); err == nil {
-			ensureSandboxParentDirs(&args, createdSandboxDirs, pulsePath)
-			args = append(args, "--ro-bind", pulsePath, pulsePath)
-		}
-
-		// PipeWire
-		pipewirePath := xdgRuntimeDir + "/pipewire-0"
-		if _, err := os.Stat(pipewirePath); err == nil {
-			ensureSandboxParentDirs(&args, createdSandboxDirs, pipewirePath)
-			args = append(args, "--ro-bind", pipewirePath, pipewirePath)
+	for _, class := range policy.Devices {
+		for _, path := range devicePaths(class) {
+			args = append(args, "--dev-bind", path, path)
 		}
 	}
 
-	// D-Bus session socket
-	dbusAddress := envLookup(params.Env, "DBUS_SESSION_BUS_ADDRESS")
-	if dbusAddress == "" {
-		dbusAddress = os.Getenv("DBUS_SESSION_BUS_ADDRESS")
-	}
-	dbusSocketPath := parseDbusSocketPath(dbusAddress)
-	if dbusSocketPath == "" && dbusAddress != "" && strings.Contains(dbusAddress, "unix:abstract=") {
-		// Abstract sockets do not map to filesystem paths and do not need mounts.
-	} else {
-		if dbusSocketPath == "" && xdgRuntimeDir != "" {
-			dbusSocketPath = filepath.Join(xdgRuntimeDir, "bus")
-		}
-		if dbusSocketPath != "" {
-			if _, err := os.Stat(dbusSocketPath); err == nil {
-				ensureSandboxParentDirs(&args, createdSandboxDirs, dbusSocketPath)
-				args = append(args, "--ro-bind", dbusSocketPath, dbusSocketPath)
-			}
+	// Display/audio socket mounts
+	for _, socket := range policy.Sockets {
+		for _, path := range socketPaths(params, socket) {
+			ensureSandboxParentDirs(&args, createdSandboxDirs, path)
+			args = append(args, "--ro-bind", path, path)
 		}
 	}
 
-	// Namespace isolation:
-	// - keep IPC shared for X11 MIT-SHM compatibility
-	// - optionally isolate network when NoNetwork is requested
+	// Namespace isolation
 	args = append(args, "--unshare-user")
-	if params.SandboxConfig.NoNetwork {
+	if policy.Network == NetworkNone {
 		args = append(args, "--unshare-net")
 	}
-	args = append(args, "--unshare-pid")
-	args = append(args, "--unshare-uts")
+	if policy.Namespaces.PID {
+		args = append(args, "--unshare-pid")
+	}
+	if policy.Namespaces.UTS {
+		args = append(args, "--unshare-uts")
+	}
+	if policy.Namespaces.IPC {
+		args = append(args, "--unshare-ipc")
+	}
 
 	// Lifecycle
 	args = append(args, "--die-with-parent")
-	args = append(args, "--new-session")
+	if !params.Console {
+		// --new-session keeps the game from injecting input into the user's
+		// terminal (TIOCSTI). In console mode, the launch already runs in a
+		// new session whose controlling terminal is a pty of its own, which
+		// --new-session would detach it from.
+		args = append(args, "--new-session")
+	}
 
 	// Start from an empty environment, then pass through only required vars.
 	args = append(args, "--clearenv")
 
-	// Environment passthrough
-	for _, key := range SandboxEnvAllowlist() {
-		if val, found := envLookupWithPresence(params.Env, key); found {
-			args = append(args, "--setenv", key, val)
-		} else if val := os.Getenv(key); val != "" {
-			args = append(args, "--setenv", key, val)
+	// Environment passthrough. Variables that are empty on the host are
+	// left out, while params.Env may still set them empty.
+	var hostEnv []string
+	for _, kv := range os.Environ() {
+		if _, val, _ := strings.Cut(kv, "="); val != "" {
+			hostEnv = append(hostEnv, kv)
 		}
 	}
-	for _, key := range params.SandboxConfig.AllowEnv {
-		if val, found := envLookupWithPresence(params.Env, key); found {
-			args = append(args, "--setenv", key, val)
-		} else if val := os.Getenv(key); val != "" {
-			args = append(args, "--setenv", key, val)
-		}
+	for _, kv := range policy.Env.environ(params.Env, hostEnv) {
+		key, val, _ := strings.Cut(kv, "=")
+		args = append(args, "--setenv", key, val)
 	}
 
 	// Working directory inside sandbox
@@ -235,37 +218,14 @@ func (br *bubblewrapRunner) Run() error {
 	}
 
 	// Command to run
-	args = append(args, "--")
-	args = append(args, params.FullTargetPath)
-	args = append(args, params.Args...)
-
-	cmd := bubblewrapCommand(bwrapPath, args...)
-	cmd.Dir = params.Dir
-	cmd.Env = params.Env
-	cmd.Stdout = params.Stdout
-	cmd.Stderr = params.Stderr
-
-	pg, err := NewProcessGroup(consumer, cmd, params.Ctx)
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	err = pg.AfterStart()
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	err = pg.Wait()
-	if err != nil {
-		return fmt.Errorf("%w", err)
+	command := append([]string{params.FullTargetPath}, params.Args...)
+	if params.SandboxConfig.Landlock {
+		command = bubblewrapLandlockArgs(&args, createdSandboxDirs, params, sandboxHome, command)
 	}
+	args = append(args, "--")
+	args = append(args, command...)
 
-	return nil
+	return args
 }
 
 func ensureSandboxParentDirs(args *[]string, seen map[string]struct{}, path string) {
@@ -3,9 +3,11 @@
 package runner
 
 import (
+	"bytes"
 	"context"
 	"os/exec"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/itchio/headway/state"
@@ -136,7 +138,8 @@ func TestBubblewrapNoNetworkAddsUnshareNet(t *testing.T) {
 		},
 	}
 
-	require.NoError(t, br.Run())
+	_, err := br.Run()
+	require.NoError(t, err)
 	assert.Equal(t, "/fake/bwrap", gotName)
 	assert.Contains(t, gotArgs, "--unshare-net")
 }
@@ -164,7 +167,8 @@ func TestBubblewrapNoNetworkDisabledOmitsUnshareNet(t *testing.T) {
 		},
 	}
 
-	require.NoError(t, br.Run())
+	_, err := br.Run()
+	require.NoError(t, err)
 	assert.NotContains(t, gotArgs, "--unshare-net")
 }
 
@@ -194,7 +198,8 @@ func TestBubblewrapAllowlistEnvEmptyValueOverridesHost(t *testing.T) {
 		},
 	}
 
-	require.NoError(t, br.Run())
+	_, err := br.Run()
+	require.NoError(t, err)
 	assert.Equal(t, []string{""}, bubblewrapSetenvValues(gotArgs, "LANG"))
 }
 
@@ -227,7 +232,8 @@ func TestBubblewrapAllowEnvEmptyValueOverridesHost(t *testing.T) {
 		},
 	}
 
-	require.NoError(t, br.Run())
+	_, err := br.Run()
+	require.NoError(t, err)
 	assert.Equal(t, []string{""}, bubblewrapSetenvValues(gotArgs, "SMAUG_ALLOW_ENV_EMPTY"))
 }
 
@@ -260,7 +266,8 @@ func TestBubblewrapMountsPersistentSandboxHome(t *testing.T) {
 		},
 	}
 
-	require.NoError(t, br.Run())
+	_, err := br.Run()
+	require.NoError(t, err)
 	assert.DirExists(t, homeSource)
 	assert.True(t, bubblewrapHasBind(gotArgs, homeSource, homeTarget))
 }
@@ -296,7 +303,8 @@ func TestBubblewrapInstallBindComesAfterSandboxHomeBind(t *testing.T) {
 		},
 	}
 
-	require.NoError(t, br.Run())
+	_, err := br.Run()
+	require.NoError(t, err)
 
 	homeBind := bubblewrapBindIndex(gotArgs, homeSource, homeTarget)
 	installBind := bubblewrapBindIndex(gotArgs, installFolder, installFolder)
@@ -304,3 +312,43 @@ func TestBubblewrapInstallBindComesAfterSandboxHomeBind(t *testing.T) {
 	require.NotEqual(t, -1, installBind)
 	assert.Less(t, homeBind, installBind)
 }
+
+func TestBubblewrapPassesStdin(t *testing.T) {
+	origCommand := bubblewrapCommand
+	t.Cleanup(func() {
+		bubblewrapCommand = origCommand
+	})
+
+	bubblewrapCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("cat")
+	}
+
+	var stdout bytes.Buffer
+	br := &bubblewrapRunner{
+		params: RunnerParams{
+			Consumer: &state.Consumer{OnMessage: func(string, string) {}},
+			Ctx:      context.Background(),
+			BubblewrapParams: BubblewrapParams{
+				BinaryPath: "/fake/bwrap",
+			},
+			FullTargetPath: "/bin/true",
+			Stdin:          strings.NewReader("hello from stdin\n"),
+			Stdout:         &stdout,
+		},
+	}
+
+	_, err := br.Run()
+	require.NoError(t, err)
+	assert.Equal(t, "hello from stdin\n", stdout.String())
+}
+
+func TestBubblewrapSkipsEmptyHostValue(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	params.SandboxConfig.AllowEnv = []string{"SMAUG_HOST_EMPTY", "SMAUG_PARAMS_EMPTY"}
+	params.Env = append(params.Env, "SMAUG_PARAMS_EMPTY=")
+	t.Setenv("SMAUG_HOST_EMPTY", "")
+
+	args := bubblewrapArgs(params)
+	assert.NotContains(t, args, "SMAUG_HOST_EMPTY")
+	assert.NotEqual(t, -1, argsIndex(args, "--setenv", "SMAUG_PARAMS_EMPTY", ""))
+}
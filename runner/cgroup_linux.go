@@ -0,0 +1,443 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"golang.org/x/sys/unix"
+)
+
+var procSelfCgroupPath = "/proc/self/cgroup"
+var procSelfMountinfoPath = "/proc/self/mountinfo"
+
+// cgroupTracker places a launch in its own cgroup v2 leaf. The child is
+// spawned directly into it (CLONE_INTO_CGROUP), so even processes forked
+// immediately after exec can't escape.
+type cgroupTracker struct {
+	consumer *state.Consumer
+	path     string
+	dir      *os.File
+}
+
+var _ processTracker = (*cgroupTracker)(nil)
+
+func newCgroupTracker(consumer *state.Consumer, params CgroupParams) (*cgroupTracker, error) {
+	parent := params.ParentPath
+	if parent == "" {
+		var err error
+		parent, err = defaultCgroupParent()
+		if err != nil {
+			return nil, fmt.Errorf("while finding parent cgroup: %w", err)
+		}
+	}
+
+	_, err := os.Stat(filepath.Join(parent, "cgroup.procs"))
+	if err != nil {
+		return nil, fmt.Errorf("(%s) is not a cgroup v2 directory: %w", parent, err)
+	}
+
+	path, err := os.MkdirTemp(parent, "smaug-launch-")
+	if err != nil {
+		return nil, fmt.Errorf("while creating cgroup: %w", err)
+	}
+
+	dir, err := os.Open(path)
+	if err != nil {
+		os.Remove(path)
+		return nil, fmt.Errorf("while opening cgroup: %w", err)
+	}
+
+	consumer.Infof("Tracking launch in cgroup (%s)", path)
+	ct := &cgroupTracker{
+		consumer: consumer,
+		path:     path,
+		dir:      dir,
+	}
+
+	// The launch is still tracked if limits can't be applied, e.g. when
+	// the controllers aren't delegated to us.
+	err = ct.applyLimits(params)
+	if err != nil {
+		consumer.Warnf("Could not apply cgroup limits: %s", err.Error())
+	}
+	return ct, nil
+}
+
+// cgroupLimitFiles returns the interface files and values that implement
+// the limits in params, keyed by the controller they belong to.
+func cgroupLimitFiles(params CgroupParams) map[string]map[string]string {
+	files := make(map[string]map[string]string)
+	if params.MemoryMax > 0 {
+		files["memory"] = map[string]string{"memory.max": strconv.FormatUint(params.MemoryMax, 10)}
+	}
+	if params.CPUQuota > 0 {
+		quota := max(int64(params.CPUQuota*cgroupCPUPeriod), cgroupMinCPUQuota)
+		files["cpu"] = map[string]string{"cpu.max": fmt.Sprintf("%d %d", quota, cgroupCPUPeriod)}
+	}
+	if params.PidsMax > 0 {
+		files["pids"] = map[string]string{"pids.max": strconv.FormatUint(params.PidsMax, 10)}
+	}
+	return files
+}
+
+const (
+	cgroupFreezeTimeout      = time.Second
+	cgroupFreezePollInterval = 10 * time.Millisecond
+)
+
+const (
+	// cpu.max period, in microseconds (the kernel default).
+	cgroupCPUPeriod = 100000
+	// Smallest quota the kernel accepts, in microseconds.
+	cgroupMinCPUQuota = 1000
+)
+
+// applyLimits enables the controllers needed by the limits in params for
+// the launch cgroup, then sets the limits on it.
+func (ct *cgroupTracker) applyLimits(params CgroupParams) error {
+	files := cgroupLimitFiles(params)
+	if len(files) == 0 {
+		return nil
+	}
+
+	var controllers []string
+	for controller := range files {
+		controllers = append(controllers, controller)
+	}
+	slices.Sort(controllers)
+
+	parent := filepath.Dir(ct.path)
+	err := enableCgroupControllers(parent, controllers)
+	if err != nil {
+		return fmt.Errorf("while enabling controllers in (%s): %w", parent, err)
+	}
+
+	for _, controller := range controllers {
+		for name, value := range files[controller] {
+			err := os.WriteFile(filepath.Join(ct.path, name), []byte(value), 0)
+			if err != nil {
+				return fmt.Errorf("while setting %s to %q: %w", name, value, err)
+			}
+			ct.consumer.Infof("Set cgroup %s to %s", name, value)
+		}
+	}
+	return nil
+}
+
+// enableCgroupControllers makes controllers available to the children of
+// the cgroup at path, if they aren't already.
+func enableCgroupControllers(path string, controllers []string) error {
+	subtreeControl := filepath.Join(path, "cgroup.subtree_control")
+	contents, err := os.ReadFile(subtreeControl)
+	if err != nil {
+		return err
+	}
+	enabled := strings.Fields(string(contents))
+
+	var missing []string
+	for _, controller := range controllers {
+		if !slices.Contains(enabled, controller) {
+			missing = append(missing, "+"+controller)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return os.WriteFile(subtreeControl, []byte(strings.Join(missing, " ")), 0)
+}
+
+// parseCgroupEvents returns the counters of a flat keyed cgroup file, such
+// as memory.events, cgroup.events or cpu.stat.
+func parseCgroupEvents(contents string) map[string]uint64 {
+	events := make(map[string]uint64)
+	for line := range strings.SplitSeq(contents, "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			continue
+		}
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			continue
+		}
+		events[key] = n
+	}
+	return events
+}
+
+// defaultCgroupParent returns the parent of the current process' cgroup
+// in the cgroup v2 hierarchy, if it's delegated to us. That's a guess,
+// right when systemd runs the launcher in a scope or service of a
+// delegated user session, which is usually the case on desktops.
+func defaultCgroupParent() (string, error) {
+	mountPoint, err := cgroup2MountPoint()
+	if err != nil {
+		return "", err
+	}
+
+	selfCgroup, err := os.ReadFile(procSelfCgroupPath)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := parseCgroup2Path(string(selfCgroup))
+	if err != nil {
+		return "", err
+	}
+
+	parent := mountPoint
+	if relPath != "/" {
+		parent = filepath.Dir(filepath.Join(mountPoint, relPath))
+	}
+	err = checkCgroupDelegated(parent)
+	if err != nil {
+		return "", err
+	}
+	return parent, nil
+}
+
+// checkCgroupDelegated returns an error unless we may create cgroups in
+// parent and enable controllers for them.
+func checkCgroupDelegated(parent string) error {
+	for _, path := range []string{parent, filepath.Join(parent, "cgroup.subtree_control")} {
+		err := unix.Access(path, unix.W_OK)
+		if err != nil {
+			return fmt.Errorf("(%s) is not delegated to us: %w", parent, err)
+		}
+	}
+	return nil
+}
+
+// parseCgroup2Path extracts the unified hierarchy path from the contents
+// of /proc/<pid>/cgroup.
+func parseCgroup2Path(contents string) (string, error) {
+	for line := range strings.SplitSeq(contents, "\n") {
+		if path, ok := strings.CutPrefix(line, "0::"); ok {
+			return path, nil
+		}
+	}
+	return "", errors.New("not in a cgroup v2 hierarchy")
+}
+
+// cgroup2MountPoint returns where the cgroup v2 hierarchy is mounted,
+// usually /sys/fs/cgroup (or /sys/fs/cgroup/unified on hybrid systems).
+func cgroup2MountPoint() (string, error) {
+	f, err := os.Open(procSelfMountinfoPath)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if mountPoint, ok := parseCgroup2Mountinfo(scanner.Text()); ok {
+			return mountPoint, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", errors.New("cgroup2 filesystem is not mounted")
+}
+
+func parseCgroup2Mountinfo(line string) (string, bool) {
+	// 42 32 0:38 / /sys/fs/cgroup rw,relatime - cgroup2 cgroup2 rw
+	before, after, ok := strings.Cut(line, " - ")
+	if !ok {
+		return "", false
+	}
+	afterFields := strings.Fields(after)
+	if len(afterFields) == 0 || afterFields[0] != "cgroup2" {
+		return "", false
+	}
+	beforeFields := strings.Fields(before)
+	if len(beforeFields) < 5 {
+		return "", false
+	}
+	return beforeFields[4], true
+}
+
+func (ct *cgroupTracker) BeforeStart(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(ct.dir.Fd())
+	return nil
+}
+
+// withoutCgroupFD returns a copy of cmd that starts outside of the cgroup
+// BeforeStart set it up for, if it did.
+func withoutCgroupFD(cmd *exec.Cmd) (*exec.Cmd, bool) {
+	if cmd.SysProcAttr == nil || !cmd.SysProcAttr.UseCgroupFD {
+		return nil, false
+	}
+	retry := cloneCommand(cmd)
+	retry.SysProcAttr.UseCgroupFD = false
+	retry.SysProcAttr.CgroupFD = 0
+	return retry, true
+}
+
+func (ct *cgroupTracker) AfterStart(cmd *exec.Cmd) error {
+	return ct.closeDir()
+}
+
+func (ct *cgroupTracker) closeDir() error {
+	if ct.dir == nil {
+		return nil
+	}
+	err := ct.dir.Close()
+	ct.dir = nil
+	return err
+}
+
+// Pids returns the PIDs of every process currently in the cgroup.
+func (ct *cgroupTracker) Pids() ([]int, error) {
+	contents, err := os.ReadFile(filepath.Join(ct.path, "cgroup.procs"))
+	if err != nil {
+		return nil, err
+	}
+
+	var pids []int
+	for field := range strings.FieldsSeq(string(contents)) {
+		pid, err := strconv.Atoi(field)
+		if err != nil {
+			return nil, fmt.Errorf("parsing pid %q: %w", field, err)
+		}
+		pids = append(pids, pid)
+	}
+	return pids, nil
+}
+
+// contains returns true if pid is still in the cgroup.
+func (ct *cgroupTracker) contains(pid int) bool {
+	pids, err := ct.Pids()
+	if err != nil {
+		return false
+	}
+	return slices.Contains(pids, pid)
+}
+
+func (ct *cgroupTracker) Processes() []ProcessInfo {
+	pids, err := ct.Pids()
+	if err != nil {
+		ct.consumer.Warnf("Could not list cgroup processes: %s", err.Error())
+		return nil
+	}
+	return describeProcesses(pids)
+}
+
+func (ct *cgroupTracker) Signal(sig syscall.Signal) error {
+	pids, err := ct.Pids()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for _, pid := range pids {
+		err := signalPid(pid, sig, func() bool { return ct.contains(pid) })
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			ct.consumer.Warnf("Could not signal pid %d: %s", pid, err.Error())
+		}
+	}
+	return nil
+}
+
+func (ct *cgroupTracker) Kill() error {
+	err := os.WriteFile(filepath.Join(ct.path, "cgroup.kill"), []byte("1"), 0)
+	if err == nil {
+		return nil
+	}
+
+	// cgroup.kill needs Linux 5.14+, fall back to killing processes one
+	// by one. New forks may slip through, so callers keep polling Empty().
+	ct.consumer.Infof("Could not use cgroup.kill (%s), killing processes individually", err.Error())
+	return ct.Signal(syscall.SIGKILL)
+}
+
+func (ct *cgroupTracker) Empty() bool {
+	events, err := os.ReadFile(filepath.Join(ct.path, "cgroup.events"))
+	if err != nil {
+		pids, err := ct.Pids()
+		return err == nil && len(pids) == 0
+	}
+
+	populated, ok := parseCgroupEvents(string(events))["populated"]
+	return ok && populated == 0
+}
+
+// Freeze suspends every process in the cgroup with the cgroup v2 freezer.
+// Unlike SIGSTOP, processes can't tell, and new forks can't slip through.
+func (ct *cgroupTracker) Freeze() error {
+	return ct.setFrozen(true)
+}
+
+// Thaw resumes processes suspended by Freeze.
+func (ct *cgroupTracker) Thaw() error {
+	return ct.setFrozen(false)
+}
+
+// setFrozen writes cgroup.freeze, then waits for the change to be reported
+// by cgroup.events, since freezing happens asynchronously.
+func (ct *cgroupTracker) setFrozen(frozen bool) error {
+	value := "0"
+	if frozen {
+		value = "1"
+	}
+	err := os.WriteFile(filepath.Join(ct.path, "cgroup.freeze"), []byte(value), 0)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	want := uint64(0)
+	if frozen {
+		want = 1
+	}
+	deadline := time.Now().Add(cgroupFreezeTimeout)
+	for {
+		events, err := os.ReadFile(filepath.Join(ct.path, "cgroup.events"))
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		if got, ok := parseCgroupEvents(string(events))["frozen"]; ok && got == want {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return fmt.Errorf("cgroup.freeze=%s did not take effect within %s", value, cgroupFreezeTimeout)
+		}
+		time.Sleep(cgroupFreezePollInterval)
+	}
+}
+
+func (ct *cgroupTracker) Report(res *ExitResult) {
+	events, err := os.ReadFile(filepath.Join(ct.path, "memory.events"))
+	if err != nil {
+		// The memory controller isn't enabled for this cgroup.
+		return
+	}
+
+	oomKills := parseCgroupEvents(string(events))["oom_kill"]
+	if oomKills > 0 {
+		ct.consumer.Warnf("The out-of-memory killer killed %d process(es) of the launch", oomKills)
+		res.OOMKilled = true
+	}
+}
+
+func (ct *cgroupTracker) Close() error {
+	ct.closeDir()
+
+	err := os.Remove(ct.path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("while removing cgroup (%s): %w", ct.path, err)
+	}
+	return nil
+}
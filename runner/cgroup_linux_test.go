@@ -0,0 +1,289 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseCgroup2Path(t *testing.T) {
+	path, err := parseCgroup2Path("12:pids:/user.slice\n0::/user.slice/user-1000.slice/user@1000.service/app.slice/itch.scope\n")
+	require.NoError(t, err)
+	assert.Equal(t, "/user.slice/user-1000.slice/user@1000.service/app.slice/itch.scope", path)
+
+	_, err = parseCgroup2Path("4:memory:/foo\n")
+	assert.Error(t, err)
+}
+
+func TestParseCgroup2Mountinfo(t *testing.T) {
+	mountPoint, ok := parseCgroup2Mountinfo("42 32 0:38 / /sys/fs/cgroup/unified rw,relatime - cgroup2 cgroup2 rw")
+	require.True(t, ok)
+	assert.Equal(t, "/sys/fs/cgroup/unified", mountPoint)
+
+	_, ok = parseCgroup2Mountinfo("35 25 0:30 / /sys/fs/cgroup/memory rw - cgroup cgroup rw,memory")
+	assert.False(t, ok)
+}
+
+// newTestCgroupParent returns a writable cgroup v2 directory for tests to
+// create launch cgroups in, or skips the test.
+func newTestCgroupParent(t *testing.T) string {
+	t.Helper()
+
+	parent, err := defaultCgroupParent()
+	if err != nil {
+		t.Skipf("cgroup v2 not available: %s", err.Error())
+	}
+
+	dir, err := os.MkdirTemp(parent, "smaug-test-")
+	if err != nil {
+		t.Skipf("cannot create cgroups under (%s): %s", parent, err.Error())
+	}
+	t.Cleanup(func() {
+		os.Remove(dir)
+	})
+	return dir
+}
+
+// processRunning returns true if pid exists and isn't a zombie.
+func processRunning(pid int) bool {
+	stat, err := os.ReadFile(filepath.Join("/proc", strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return false
+	}
+	_, rest, ok := strings.Cut(string(stat), ") ")
+	return ok && !strings.HasPrefix(rest, "Z")
+}
+
+func newCgroupTestParams(t *testing.T, ctx context.Context) RunnerParams {
+	t.Helper()
+	return RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx: ctx,
+		CgroupParams: CgroupParams{
+			Enabled:    true,
+			ParentPath: newTestCgroupParent(t),
+		},
+	}
+}
+
+func TestCgroupTrackerWaitsForDetachedProcesses(t *testing.T) {
+	params := newCgroupTestParams(t, context.Background())
+
+	cmd := exec.Command("sh", "-c", "setsid sleep 0.5 & exit 0")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	require.NotNil(t, h.pg.tracker, "expected cgroup tracking to be enabled")
+
+	res, err := h.Wait()
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.GreaterOrEqual(t, res.Duration, 400*time.Millisecond)
+
+	entries, err := os.ReadDir(params.CgroupParams.ParentPath)
+	require.NoError(t, err)
+	for _, entry := range entries {
+		assert.False(t, entry.IsDir(), "launch cgroup (%s) should have been removed", entry.Name())
+	}
+}
+
+func TestCgroupTrackerKillsDetachedProcessesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := newCgroupTestParams(t, ctx)
+
+	pidFile := filepath.Join(t.TempDir(), "pid")
+	cmd := exec.Command("sh", "-c", "setsid sleep 30 & echo $! > \"$0\"; exec sleep 30", pidFile)
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	pidBytes, err := os.ReadFile(pidFile)
+	require.NoError(t, err)
+	detachedPid, err := strconv.Atoi(strings.TrimSpace(string(pidBytes)))
+	require.NoError(t, err)
+	require.True(t, processRunning(detachedPid))
+
+	cancel()
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return within 5 seconds after context cancellation")
+	}
+
+	res, _ := h.Wait()
+	assert.True(t, res.Cancelled)
+	assert.False(t, processRunning(detachedPid), "detached process should have been killed")
+}
+
+func TestCgroupLimitFiles(t *testing.T) {
+	assert.Empty(t, cgroupLimitFiles(CgroupParams{}))
+
+	files := cgroupLimitFiles(CgroupParams{
+		MemoryMax: 512 << 20,
+		CPUQuota:  1.5,
+		PidsMax:   256,
+	})
+	assert.Equal(t, map[string]map[string]string{
+		"memory": {"memory.max": "536870912"},
+		"cpu":    {"cpu.max": "150000 100000"},
+		"pids":   {"pids.max": "256"},
+	}, files)
+
+	files = cgroupLimitFiles(CgroupParams{CPUQuota: 0.001})
+	assert.Equal(t, "1000 100000", files["cpu"]["cpu.max"])
+}
+
+func TestParseCgroupEvents(t *testing.T) {
+	events := parseCgroupEvents("low 0\nhigh 0\nmax 12\noom 2\noom_kill 1\noom_group_kill 0\n")
+	assert.Equal(t, uint64(12), events["max"])
+	assert.Equal(t, uint64(1), events["oom_kill"])
+
+	_, ok := parseCgroupEvents("populated 1\n")["oom_kill"]
+	assert.False(t, ok)
+}
+
+// newFakeCgroup lays out a directory that looks enough like a cgroup (with
+// the given files) for a cgroupTracker to use.
+func newFakeCgroup(t *testing.T, files map[string]string) *cgroupTracker {
+	t.Helper()
+	parent := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte("cpu\n"), 0644))
+
+	path := filepath.Join(parent, "smaug-launch-test")
+	require.NoError(t, os.Mkdir(path, 0755))
+	for name, contents := range files {
+		require.NoError(t, os.WriteFile(filepath.Join(path, name), []byte(contents), 0644))
+	}
+
+	return &cgroupTracker{
+		consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		path: path,
+	}
+}
+
+func TestCgroupTrackerAppliesLimits(t *testing.T) {
+	ct := newFakeCgroup(t, map[string]string{
+		"memory.max": "max\n",
+		"cpu.max":    "max 100000\n",
+		"pids.max":   "max\n",
+	})
+
+	err := ct.applyLimits(CgroupParams{MemoryMax: 1 << 30, CPUQuota: 2, PidsMax: 64})
+	require.NoError(t, err)
+
+	read := func(path string) string {
+		contents, err := os.ReadFile(path)
+		require.NoError(t, err)
+		return string(contents)
+	}
+	assert.Equal(t, "1073741824", read(filepath.Join(ct.path, "memory.max")))
+	assert.Equal(t, "200000 100000", read(filepath.Join(ct.path, "cpu.max")))
+	assert.Equal(t, "64", read(filepath.Join(ct.path, "pids.max")))
+
+	// cpu was already enabled, only the others are added.
+	assert.Equal(t, "+memory +pids", read(filepath.Join(filepath.Dir(ct.path), "cgroup.subtree_control")))
+}
+
+func TestCgroupTrackerReportsOOMKill(t *testing.T) {
+	ct := newFakeCgroup(t, map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+	})
+	res := newExitResult()
+	res.Signal = syscall.SIGKILL
+	ct.Report(res)
+	assert.True(t, res.OOMKilled)
+	assert.Contains(t, res.String(), "out-of-memory")
+
+	ct = newFakeCgroup(t, map[string]string{
+		"memory.events": "low 0\nhigh 0\nmax 0\noom 0\noom_kill 0\n",
+	})
+	res = newExitResult()
+	ct.Report(res)
+	assert.False(t, res.OOMKilled)
+
+	// Without the memory controller, there's nothing to report.
+	ct = newFakeCgroup(t, nil)
+	res = newExitResult()
+	ct.Report(res)
+	assert.False(t, res.OOMKilled)
+}
+
+func TestCgroupMemoryMaxReportsOOMKill(t *testing.T) {
+	params := newCgroupTestParams(t, context.Background())
+	controllers, err := os.ReadFile(filepath.Join(params.CgroupParams.ParentPath, "cgroup.controllers"))
+	require.NoError(t, err)
+	if !slices.Contains(strings.Fields(string(controllers)), "memory") {
+		t.Skip("memory controller is not available")
+	}
+	params.CgroupParams.MemoryMax = 32 << 20
+
+	// tail has to buffer the whole "line", which never ends.
+	cmd := exec.Command("sh", "-c", "head -c 256M /dev/zero | tail -c 1 > /dev/null")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+
+	res, _ := h.Wait()
+	assert.True(t, res.OOMKilled)
+}
+
+func TestCgroupTrackerFallsBackWhenStartFails(t *testing.T) {
+	params := RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx: context.Background(),
+	}
+	// Not a cgroup, so clone3 rejects it.
+	ct := newFakeCgroup(t, nil)
+	dir, err := os.Open(ct.path)
+	require.NoError(t, err)
+	ct.dir = dir
+
+	cmd := exec.Command("sh", "-c", "exit 3")
+	h, err := startCommandWithTracker(params, cmd, BackendSimple, func() processTracker {
+		return ct
+	})
+	require.NoError(t, err)
+	assert.Nil(t, h.pg.tracker)
+	assert.False(t, h.pg.cmd.SysProcAttr.UseCgroupFD)
+
+	res, _ := h.Wait()
+	assert.Equal(t, 3, res.ExitCode)
+	assert.NoDirExists(t, ct.path)
+}
+
+func TestCheckCgroupDelegated(t *testing.T) {
+	if os.Geteuid() == 0 {
+		t.Skip("root may write anywhere")
+	}
+	parent := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), nil, 0o444))
+	assert.Error(t, checkCgroupDelegated(parent))
+
+	require.NoError(t, os.Chmod(filepath.Join(parent, "cgroup.subtree_control"), 0o644))
+	assert.NoError(t, checkCgroupDelegated(parent))
+}
@@ -0,0 +1,106 @@
+package runner
+
+import (
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// consoleDrainTimeout is how long output is still copied to
+// RunnerParams.Stdout after the process group exits, in case a process
+// outside of it still holds the terminal open.
+const consoleDrainTimeout = time.Second
+
+// Console is the controlling side of the pseudo-terminal a launch runs in
+// when RunnerParams.Console is set on Linux. Writing to it is like typing
+// into the terminal, and reading from it returns what the game displays.
+//
+// If RunnerParams.Stdin is set, it is copied to the console as input.
+// If RunnerParams.Stdout is set, everything the game displays is copied to
+// it instead, Read must not be used, and the console is closed once the
+// launch is over. Otherwise, the caller must read until io.EOF, then Close.
+type Console struct {
+	f      *os.File
+	tty    *os.File
+	resize func(f *os.File, cols, rows uint16) error
+	// RunnerParams.Stdin and Stdout, if set.
+	copyFrom io.Reader
+	copyTo   io.Writer
+	// Session log and output line events, if enabled. Everything read is
+	// written to it.
+	output io.Writer
+
+	closeOnce sync.Once
+	copyDone  chan struct{}
+}
+
+// Read reads what the game displayed. It returns io.EOF once every
+// process using the terminal has exited.
+func (c *Console) Read(p []byte) (int, error) {
+	n, err := c.f.Read(p)
+	if n > 0 && c.output != nil {
+		c.output.Write(p[:n])
+	}
+	if errors.Is(err, syscall.EIO) {
+		// Linux reports a hung-up terminal with EIO rather than EOF.
+		return n, io.EOF
+	}
+	return n, err
+}
+
+// Write sends input to the game, as if typed in the terminal.
+func (c *Console) Write(p []byte) (int, error) {
+	return c.f.Write(p)
+}
+
+// Resize changes the terminal's window size. The game receives SIGWINCH.
+func (c *Console) Resize(cols, rows uint16) error {
+	return c.resize(c.f, cols, rows)
+}
+
+// Close releases the terminal.
+func (c *Console) Close() error {
+	var err error
+	c.closeOnce.Do(func() {
+		if c.tty != nil {
+			c.tty.Close()
+		}
+		err = c.f.Close()
+	})
+	return err
+}
+
+// started is called once the process is running. The parent's copy of the
+// tty is closed, so that the console sees EOF once the process exits.
+func (c *Console) started() {
+	c.tty.Close()
+	c.tty = nil
+
+	if c.copyFrom != nil {
+		go io.Copy(c, c.copyFrom)
+	}
+	if c.copyTo != nil {
+		c.copyDone = make(chan struct{})
+		go func() {
+			defer close(c.copyDone)
+			io.Copy(c.copyTo, c)
+		}()
+	}
+}
+
+// finish is called once the launch is over. If output is being copied,
+// it waits for the copy to complete (or gives up after a while), then
+// closes the console.
+func (c *Console) finish() {
+	if c.copyDone == nil {
+		return
+	}
+	select {
+	case <-c.copyDone:
+	case <-time.After(consoleDrainTimeout):
+	}
+	c.Close()
+}
@@ -0,0 +1,13 @@
+//go:build !linux && !windows
+
+package runner
+
+import (
+	"os/exec"
+	"runtime"
+)
+
+func attachConsole(params RunnerParams, cmd *exec.Cmd) (*Console, error) {
+	params.Consumer.Infof("Console mode is not supported on %s, ignoring", runtime.GOOS)
+	return nil, nil
+}
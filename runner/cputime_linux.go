@@ -0,0 +1,67 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// cpuTimeTracker is implemented by process trackers that can account for
+// the CPU time of every process of a launch, including exited ones.
+type cpuTimeTracker interface {
+	CPUTime() (time.Duration, error)
+}
+
+func (pg *processGroup) cpuTimeFunc() func() (time.Duration, error) {
+	return pg.cpuTime
+}
+
+// cpuTime returns the CPU time used so far by the launch. Without a tracker
+// that accounts for it, it is the sum of the CPU time of the main process,
+// the members of its process group and tracked processes, plus that of
+// their children they waited for. The time of processes reaped by someone
+// else (e.g. orphans reparented to init) is missed.
+func (pg *processGroup) cpuTime() (time.Duration, error) {
+	if ctt, ok := pg.tracker.(cpuTimeTracker); ok {
+		return ctt.CPUTime()
+	}
+
+	stats, err := listProcStats()
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	members := map[int]struct{}{pg.main.pid: {}}
+	if pg.tracker != nil {
+		for _, p := range pg.tracker.Processes() {
+			members[p.Pid] = struct{}{}
+		}
+	}
+
+	var total time.Duration
+	for _, st := range stats {
+		_, tracked := members[st.pid]
+		if tracked || (pg.pgid != 0 && st.pgrp == pg.pgid) {
+			total += st.cpuTime()
+		}
+	}
+	return total, nil
+}
+
+// CPUTime returns the CPU time used by every process that was ever in the
+// cgroup, from cpu.stat (available without the cpu controller).
+func (ct *cgroupTracker) CPUTime() (time.Duration, error) {
+	contents, err := os.ReadFile(filepath.Join(ct.path, "cpu.stat"))
+	if err != nil {
+		return 0, fmt.Errorf("%w", err)
+	}
+
+	usage, ok := parseCgroupEvents(string(contents))["usage_usec"]
+	if !ok {
+		return 0, fmt.Errorf("no usage_usec in cpu.stat")
+	}
+	return time.Duration(usage) * time.Microsecond, nil
+}
@@ -0,0 +1,108 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"os/exec"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCPUTimeLimit(t *testing.T) {
+	origInterval := cpuLimitPollInterval
+	cpuLimitPollInterval = 50 * time.Millisecond
+	t.Cleanup(func() {
+		cpuLimitPollInterval = origInterval
+	})
+
+	var mu sync.Mutex
+	var warnings []TimeLimitKind
+
+	params := RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx: context.Background(),
+		TimeLimits: TimeLimits{
+			CPUTime:    300 * time.Millisecond,
+			WarnBefore: 200 * time.Millisecond,
+			OnWarning: func(kind TimeLimitKind, remaining time.Duration) {
+				mu.Lock()
+				defer mu.Unlock()
+				warnings = append(warnings, kind)
+			},
+		},
+	}
+
+	// The CPU time of the busy child counts, even though sh only waits.
+	cmd := exec.Command("sh", "-c", "sh -c 'while :; do :; done'")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+
+	select {
+	case <-h.Done():
+	case <-time.After(10 * time.Second):
+		t.Fatal("Wait() did not return within 10 seconds")
+	}
+
+	res, _ := h.Wait()
+	assert.Equal(t, TimeLimitCPU, res.TimeLimit)
+	assert.False(t, res.Cancelled)
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []TimeLimitKind{TimeLimitCPU}, warnings)
+}
+
+func TestWatchTimeLimitsStopsWithoutCancelling(t *testing.T) {
+	consumer := &state.Consumer{}
+	w := watchTimeLimits(context.Background(), consumer, TimeLimits{WallClock: time.Hour}, nil)
+	w.Stop()
+	assert.Equal(t, TimeLimitNone, timeLimitCause(w.Context()))
+}
+
+func TestTimeLimitWarningCanPause(t *testing.T) {
+	consumer := &state.Consumer{}
+	watchers := make(chan *timeLimitWatcher, 1)
+	warned := make(chan struct{})
+	w := watchTimeLimits(context.Background(), consumer, TimeLimits{
+		WallClock:  300 * time.Millisecond,
+		WarnBefore: 250 * time.Millisecond,
+		OnWarning: func(kind TimeLimitKind, remaining time.Duration) {
+			(<-watchers).SetPaused(true)
+			close(warned)
+		},
+	}, nil)
+	watchers <- w
+
+	select {
+	case <-warned:
+	case <-time.After(5 * time.Second):
+		t.Fatal("OnWarning blocked")
+	}
+	// Paused before the limit was reached.
+	select {
+	case <-w.Context().Done():
+		t.Fatal("time limit reached while paused")
+	case <-time.After(500 * time.Millisecond):
+	}
+	w.Stop()
+	assert.Equal(t, TimeLimitNone, timeLimitCause(w.Context()))
+}
+
+func TestCgroupTrackerCPUTime(t *testing.T) {
+	ct := newFakeCgroup(t, map[string]string{
+		"cpu.stat": "usage_usec 1500000\nuser_usec 1000000\nsystem_usec 500000\n",
+	})
+	used, err := ct.CPUTime()
+	require.NoError(t, err)
+	assert.Equal(t, 1500*time.Millisecond, used)
+}
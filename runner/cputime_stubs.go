@@ -0,0 +1,11 @@
+//go:build !linux && !windows
+
+package runner
+
+import "time"
+
+// cpuTimeFunc returns nil, as measuring the CPU time of a launch is only
+// supported on Linux.
+func (pg *processGroup) cpuTimeFunc() func() (time.Duration, error) {
+	return nil
+}
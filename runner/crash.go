@@ -0,0 +1,498 @@
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const defaultCrashStderrLines = 100
+
+// crashSignals are the signals a launch is considered to have crashed from.
+var crashSignals = []syscall.Signal{syscall.SIGSEGV, syscall.SIGABRT, syscall.SIGBUS, syscall.SIGILL}
+
+// secretNamePattern matches the names of environment variables and options
+// whose values are redacted from crash bundles.
+var secretNamePattern = regexp.MustCompile(`(?i)key|token|secret|passw|credential|cookie|auth`)
+
+const redactedValue = "[redacted]"
+
+// corePatternPath tells where the kernel writes core dumps on Linux.
+const corePatternPath = "/proc/sys/kernel/core_pattern"
+
+func (cp CrashParams) withDefaults(installFolder string) CrashParams {
+	if cp.Dir == "" {
+		cp.Dir = filepath.Join(installFolder, ".itch", "crashes")
+	}
+	if cp.StderrLines <= 0 {
+		cp.StderrLines = defaultCrashStderrLines
+	}
+	return cp
+}
+
+// crashReport is written to crash.json in a crash bundle.
+type crashReport struct {
+	Time      time.Time     `json:"time"`
+	Signal    string        `json:"signal"`
+	Duration  string        `json:"duration"`
+	Backend   Backend       `json:"backend"`
+	OOMKilled bool          `json:"oomKilled,omitempty"`
+	Survivors []ProcessInfo `json:"survivors,omitempty"`
+
+	Target        string        `json:"target"`
+	Args          []string      `json:"args"`
+	Dir           string        `json:"dir"`
+	Env           []string      `json:"env"`
+	Command       []string      `json:"command"`
+	Sandbox       bool          `json:"sandbox"`
+	SandboxConfig SandboxConfig `json:"sandboxConfig"`
+
+	// Files of the bundle, besides crash.json.
+	Files []string `json:"files"`
+
+	// Anything worth knowing about what's missing from the bundle.
+	Notes []string `json:"notes,omitempty"`
+}
+
+// crashCollector writes a crash bundle for a launch that crashed. It keeps
+// the last lines of stderr until then.
+type crashCollector struct {
+	params    RunnerParams
+	cp        CrashParams
+	backend   Backend
+	command   []string
+	env       []string
+	policies  []policyFile
+	startTime time.Time
+
+	mu      sync.Mutex
+	stderr  *lineRing
+	partial []byte
+	w       io.Writer
+}
+
+// newCrashCollector returns nil unless params.CrashParams.Enabled. command
+// and env are those of the process actually started, e.g. bwrap. It must be
+// called before the process is started, core dumps older than that being
+// ignored, and once its sandbox policies are written: they are read right
+// away, since some are removed as soon as the launch is over.
+func newCrashCollector(params RunnerParams, command []string, env []string, backend Backend) *crashCollector {
+	if !params.CrashParams.Enabled {
+		return nil
+	}
+	cp := params.CrashParams.withDefaults(params.InstallFolder)
+	if env == nil {
+		env = os.Environ()
+	}
+	policies := sandboxPolicyFiles(params, backend, command)
+	for i := range policies {
+		policies[i] = policies[i].load()
+	}
+	return &crashCollector{
+		params:    params,
+		cp:        cp,
+		backend:   backend,
+		command:   command,
+		env:       env,
+		policies:  policies,
+		startTime: time.Now(),
+		stderr:    newLineRing(cp.StderrLines),
+	}
+}
+
+// stderrWriter returns a writer that keeps the last lines written to it,
+// then passes them on to w, which may be nil. It may be called on a nil
+// crashCollector, in which case it returns w as-is.
+func (cc *crashCollector) stderrWriter(w io.Writer) io.Writer {
+	if cc == nil {
+		return w
+	}
+	cc.w = w
+	return cc
+}
+
+func (cc *crashCollector) Write(p []byte) (int, error) {
+	cc.mu.Lock()
+	cc.stderr.feed(&cc.partial, p)
+	cc.mu.Unlock()
+
+	if cc.w == nil {
+		return len(p), nil
+	}
+	return cc.w.Write(p)
+}
+
+func isCrashSignal(sig syscall.Signal) bool {
+	for _, s := range crashSignals {
+		if sig == s {
+			return true
+		}
+	}
+	return false
+}
+
+// collect writes a crash bundle if the launch crashed, and records it in
+// res. Failing to is logged, but not otherwise reported. It may be called
+// on a nil crashCollector.
+func (cc *crashCollector) collect(res *ExitResult) {
+	if cc == nil || !isCrashSignal(res.Signal) {
+		return
+	}
+	consumer := cc.params.Consumer
+
+	bundle, err := cc.writeBundle(res, time.Now())
+	if err != nil {
+		consumer.Warnf("Could not write crash bundle: %s", err.Error())
+		return
+	}
+	res.CrashBundle = bundle
+	consumer.Warnf("Game crashed (%s), crash bundle written to (%s)", signalName(res.Signal), bundle)
+}
+
+func (cc *crashCollector) writeBundle(res *ExitResult, now time.Time) (string, error) {
+	params := cc.params
+
+	name := fmt.Sprintf("%s-%s", now.Format(sessionLogTimeFormat), signalName(res.Signal))
+	bundle := filepath.Join(cc.cp.Dir, name)
+	err := os.MkdirAll(bundle, 0o755)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+
+	report := crashReport{
+		Time:          now,
+		Signal:        signalName(res.Signal),
+		Duration:      res.Duration.String(),
+		Backend:       res.Backend,
+		OOMKilled:     res.OOMKilled,
+		Survivors:     res.Survivors,
+		Target:        params.FullTargetPath,
+		Args:          redactArgs(params.Args),
+		Dir:           params.Dir,
+		Env:           redactEnv(cc.env),
+		Command:       redactArgs(cc.command),
+		Sandbox:       params.Sandbox,
+		SandboxConfig: params.SandboxConfig,
+	}
+
+	addFile := func(name string, err error) {
+		if err != nil {
+			report.Notes = append(report.Notes, fmt.Sprintf("could not write %s: %s", name, err.Error()))
+			return
+		}
+		report.Files = append(report.Files, name)
+	}
+
+	if params.Console {
+		report.Notes = append(report.Notes, "stderr went to the console, and isn't included")
+	}
+
+	cc.mu.Lock()
+	cc.stderr.flush(&cc.partial)
+	stderrLines := cc.stderr.contents()
+	cc.mu.Unlock()
+	var stderr strings.Builder
+	for _, line := range stderrLines {
+		stderr.WriteString(line)
+		stderr.WriteString("\n")
+	}
+	addFile("stderr.log", os.WriteFile(filepath.Join(bundle, "stderr.log"), []byte(stderr.String()), 0o644))
+
+	if res.LogPath != "" {
+		addFile("session.log", copyFile(res.LogPath, filepath.Join(bundle, "session.log")))
+	}
+
+	for _, pf := range cc.policies {
+		addFile(pf.name, pf.write(filepath.Join(bundle, pf.name)))
+	}
+
+	if params.CrashParams.CoreDumps {
+		report.Notes = append(report.Notes, cc.collectCoreDump(bundle, addFile)...)
+	}
+
+	reportBytes, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	err = os.WriteFile(filepath.Join(bundle, "crash.json"), reportBytes, 0o644)
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return bundle, nil
+}
+
+// collectCoreDump moves a core dump written by the launch into bundle. It
+// returns notes on why none was found.
+func (cc *crashCollector) collectCoreDump(bundle string, addFile func(name string, err error)) []string {
+	dir := cc.params.Dir
+	if dir == "" {
+		var err error
+		dir, err = os.Getwd()
+		if err != nil {
+			return []string{fmt.Sprintf("could not look for a core dump: %s", err.Error())}
+		}
+	}
+
+	corePath := findCoreDump(dir, cc.startTime)
+	if corePath == "" {
+		notes := []string{fmt.Sprintf("no core dump found in %s", dir)}
+		if pattern, err := os.ReadFile(corePatternPath); err == nil && strings.HasPrefix(string(pattern), "|") {
+			notes = append(notes, fmt.Sprintf("core dumps are piped to %s", strings.TrimSpace(string(pattern[1:]))))
+		}
+		return notes
+	}
+
+	target := filepath.Join(bundle, filepath.Base(corePath))
+	err := os.Rename(corePath, target)
+	if err != nil {
+		// Probably a different filesystem.
+		err = copyFile(corePath, target)
+		if err == nil {
+			os.Remove(corePath)
+		}
+	}
+	addFile(filepath.Base(corePath), err)
+	return nil
+}
+
+// coreDumpClockSlack is how much older than the launch a core dump may look.
+// File times come from a coarse clock, so a core written right after the
+// launch may be stamped slightly before it.
+const coreDumpClockSlack = time.Second
+
+// findCoreDump returns the newest core dump written in dir since since, as
+// named by the default core_pattern ("core" or "core.PID"), or "" if there
+// is none.
+func findCoreDump(dir string, since time.Time) string {
+	since = since.Add(-coreDumpClockSlack)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return ""
+	}
+
+	var newest string
+	var newestTime time.Time
+	for _, e := range entries {
+		name := e.Name()
+		if name != "core" {
+			suffix, ok := strings.CutPrefix(name, "core.")
+			if !ok {
+				continue
+			}
+			if _, err := strconv.Atoi(suffix); err != nil {
+				continue
+			}
+		}
+		info, err := e.Info()
+		if err != nil || !info.Mode().IsRegular() || info.ModTime().Before(since) {
+			continue
+		}
+		if newest == "" || info.ModTime().After(newestTime) {
+			newest = filepath.Join(dir, name)
+			newestTime = info.ModTime()
+		}
+	}
+	return newest
+}
+
+// policyFile is a sandbox policy included in crash bundles, copied from
+// path, or written from contents.
+type policyFile struct {
+	name     string
+	path     string
+	contents []byte
+
+	// redact removes secrets from a copy of path.
+	redact func([]byte) ([]byte, error)
+
+	// err tells why path could not be loaded.
+	err error
+}
+
+// load reads pf.path into pf.contents.
+func (pf policyFile) load() policyFile {
+	if pf.path == "" {
+		return pf
+	}
+	contents, err := os.ReadFile(pf.path)
+	if err == nil && pf.redact != nil {
+		contents, err = pf.redact(contents)
+	}
+	if err != nil {
+		pf.err = fmt.Errorf("%w", err)
+		return pf
+	}
+	pf.path = ""
+	pf.contents = contents
+	return pf
+}
+
+func (pf policyFile) write(dst string) error {
+	pf = pf.load()
+	if pf.err != nil {
+		return pf.err
+	}
+	return os.WriteFile(dst, pf.contents, 0o644)
+}
+
+// sandboxPolicyFiles returns the policies generated for a sandboxed launch
+// with command: the files firejail, nsjail and OCI runtimes are given, and
+// the sandbox's options for bubblewrap, the native sandbox and systemd,
+// which take their policy on the command line.
+func sandboxPolicyFiles(params RunnerParams, backend Backend, command []string) []policyFile {
+	if !params.Sandbox {
+		return nil
+	}
+	lines := func(args []string) []byte {
+		return []byte(strings.Join(args, "\n") + "\n")
+	}
+
+	switch backend {
+	case BackendFirejail:
+		for _, arg := range command {
+			if profile, ok := strings.CutPrefix(arg, "--profile="); ok {
+				return []policyFile{{name: filepath.Base(profile), path: profile}}
+			}
+		}
+	case BackendNsjail:
+		if config, ok := optionValue(command, "--config"); ok {
+			return []policyFile{{name: "nsjail.cfg", path: config, redact: redactNsjailConfig}}
+		}
+	case BackendOCI:
+		if bundle, ok := optionValue(command, "--bundle"); ok {
+			return []policyFile{{name: "config.json", path: filepath.Join(bundle, "config.json"), redact: redactOCIConfig}}
+		}
+	case BackendBubblewrap, BackendNative:
+		if len(command) > 1 {
+			return []policyFile{{name: "sandbox.args", contents: lines(redactArgs(command[1:]))}}
+		}
+	case BackendSystemd:
+		var properties []string
+		for _, arg := range command {
+			if property, ok := strings.CutPrefix(arg, "--property="); ok {
+				properties = append(properties, property)
+			}
+		}
+		return []policyFile{{name: "unit.properties", contents: lines(properties)}}
+	}
+	if runtime.GOOS == "darwin" {
+		// sandbox-exec runs naked executables through the simple runner.
+		path := filepath.Join(params.InstallFolder, ".itch", "isolate-app.sb")
+		return []policyFile{{name: filepath.Base(path), path: path}}
+	}
+	return nil
+}
+
+// optionValue returns the value of a "--name value" option of command.
+func optionValue(command []string, name string) (string, bool) {
+	for i := 0; i+1 < len(command); i++ {
+		if command[i] == name {
+			return command[i+1], true
+		}
+	}
+	return "", false
+}
+
+// redactNsjailConfig redacts secret-looking variables of an nsjail config.
+func redactNsjailConfig(config []byte) ([]byte, error) {
+	lines := strings.Split(string(config), "\n")
+	for i, line := range lines {
+		kv, ok := strings.CutPrefix(line, `envar: "`)
+		if !ok {
+			continue
+		}
+		if name, _, _ := strings.Cut(kv, "="); isSecretName(name) {
+			lines[i] = `envar: "` + name + "=" + redactedValue + `"`
+		}
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// redactOCIConfig redacts secret-looking variables of an OCI runtime config.
+func redactOCIConfig(config []byte) ([]byte, error) {
+	var spec map[string]any
+	err := json.Unmarshal(config, &spec)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if process, ok := spec["process"].(map[string]any); ok {
+		if env, ok := process["env"].([]any); ok {
+			for i, kv := range env {
+				if kv, ok := kv.(string); ok {
+					env[i] = redactAssignment(kv)
+				}
+			}
+		}
+	}
+	return json.MarshalIndent(spec, "", "  ")
+}
+
+func isSecretName(name string) bool {
+	return secretNamePattern.MatchString(name)
+}
+
+// redactEnv redacts the values of secret-looking variables in env.
+func redactEnv(env []string) []string {
+	out := make([]string, len(env))
+	for i, kv := range env {
+		out[i] = redactAssignment(kv)
+	}
+	return out
+}
+
+func redactAssignment(s string) string {
+	name, _, ok := strings.Cut(s, "=")
+	if ok && isSecretName(name) {
+		return name + "=" + redactedValue
+	}
+	return s
+}
+
+// redactArgs redacts secret-looking "--name=value" options, as well as
+// variables passed to bubblewrap with "--setenv NAME VALUE".
+func redactArgs(args []string) []string {
+	out := make([]string, len(args))
+	for i := 0; i < len(args); i++ {
+		if args[i] == "--setenv" && i+2 < len(args) {
+			out[i], out[i+1], out[i+2] = args[i], args[i+1], args[i+2]
+			if isSecretName(args[i+1]) {
+				out[i+2] = redactedValue
+			}
+			i += 2
+			continue
+		}
+		out[i] = redactAssignment(args[i])
+	}
+	return out
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	_, err = io.Copy(out, in)
+	cerr := out.Close()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if cerr != nil {
+		return fmt.Errorf("%w", cerr)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLaunchRlimitsEnablesCoreDumps(t *testing.T) {
+	params := RunnerParams{CrashParams: CrashParams{Enabled: true, CoreDumps: true}}
+	assert.Equal(t, "core=18446744073709551615", formatRlimits(launchRlimits(params)))
+
+	params.CrashParams.MaxCoreSize = 1 << 20
+	assert.Equal(t, "core=1048576", formatRlimits(launchRlimits(params)))
+
+	params.ResourceLimits.DisableCoreDumps = true
+	assert.Equal(t, "core=0", formatRlimits(launchRlimits(params)))
+
+	params.CrashParams.Enabled = false
+	params.ResourceLimits.DisableCoreDumps = false
+	assert.Empty(t, launchRlimits(params))
+}
+
+func TestCrashBundleCollectsCoreDump(t *testing.T) {
+	pattern, err := os.ReadFile(corePatternPath)
+	if err != nil || strings.TrimSpace(string(pattern)) != "core" {
+		t.Skipf("core dumps aren't written to the working directory here (core_pattern %q)", pattern)
+	}
+
+	params := newCrashTestParams(t)
+	params.Dir = t.TempDir()
+	params.CrashParams.CoreDumps = true
+	params.CrashParams.MaxCoreSize = 64 << 20
+
+	res := runCrashTest(t, params, "kill -ABRT $$")
+	assert.Equal(t, syscall.SIGABRT, res.Signal)
+	require.NotEmpty(t, res.CrashBundle)
+
+	report := readCrashReport(t, res.CrashBundle)
+	assert.Contains(t, report.Files, "core", "notes: %v", report.Notes)
+	_, err = os.Stat(filepath.Join(res.CrashBundle, "core"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(params.Dir, "core"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCrashBundleUnderNativeSandbox(t *testing.T) {
+	requireUserNamespaces(t)
+
+	params, _ := newNativeTestParams(t, "kill -SEGV $$")
+	params.Sandbox = true
+	params.CrashParams.Enabled = true
+	res, err := runNative(t, params)
+	require.Error(t, err)
+	require.NotNil(t, res)
+
+	assert.Equal(t, syscall.SIGSEGV, res.Signal)
+	require.NotEmpty(t, res.CrashBundle)
+	report := readCrashReport(t, res.CrashBundle)
+	assert.Equal(t, BackendNative, report.Backend)
+	assert.Contains(t, report.Files, "sandbox.args")
+}
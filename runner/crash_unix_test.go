@@ -0,0 +1,205 @@
+//go:build !windows
+
+package runner
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCrashTestParams(t *testing.T) RunnerParams {
+	t.Helper()
+	return RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx:           context.Background(),
+		InstallFolder: t.TempDir(),
+		CrashParams:   CrashParams{Enabled: true},
+	}
+}
+
+func runCrashTest(t *testing.T, params RunnerParams, script string) *ExitResult {
+	t.Helper()
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Dir = params.Dir
+	cmd.Env = params.Env
+	cmd.Stderr = params.Stderr
+	applyResourceLimits(params, cmd)
+
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	select {
+	case <-h.Done():
+	case <-time.After(10 * time.Second):
+		h.Kill()
+		t.Fatal("Wait() did not return within 10 seconds")
+	}
+	res, _ := h.Wait()
+	require.NotNil(t, res)
+	return res
+}
+
+func readCrashReport(t *testing.T, bundle string) crashReport {
+	t.Helper()
+	reportBytes, err := os.ReadFile(filepath.Join(bundle, "crash.json"))
+	require.NoError(t, err)
+	var report crashReport
+	require.NoError(t, json.Unmarshal(reportBytes, &report))
+	return report
+}
+
+func TestCrashBundle(t *testing.T) {
+	params := newCrashTestParams(t)
+	params.CrashParams.StderrLines = 2
+	params.Env = []string{"PATH=" + os.Getenv("PATH"), "ITCHIO_API_KEY=hunter2", "LANG=C"}
+	params.LogParams.Enabled = true
+
+	res := runCrashTest(t, params, "echo one >&2; echo two >&2; echo three >&2; kill -SEGV $$")
+	assert.Equal(t, syscall.SIGSEGV, res.Signal)
+	require.NotEmpty(t, res.CrashBundle)
+	assert.Equal(t, filepath.Join(params.InstallFolder, ".itch", "crashes"), filepath.Dir(res.CrashBundle))
+
+	report := readCrashReport(t, res.CrashBundle)
+	assert.Equal(t, "SIGSEGV", report.Signal)
+	assert.Equal(t, BackendSimple, report.Backend)
+	assert.Contains(t, report.Env, "ITCHIO_API_KEY=[redacted]")
+	assert.Contains(t, report.Env, "LANG=C")
+	assert.Equal(t, []string{"stderr.log", "session.log"}, report.Files)
+
+	stderr, err := os.ReadFile(filepath.Join(res.CrashBundle, "stderr.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "two\nthree\n", string(stderr))
+
+	sessionLog, err := os.ReadFile(filepath.Join(res.CrashBundle, "session.log"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nthree\n", string(sessionLog))
+}
+
+func TestNoCrashBundleWithoutCrash(t *testing.T) {
+	for _, script := range []string{"exit 3", "kill -TERM $$"} {
+		params := newCrashTestParams(t)
+		res := runCrashTest(t, params, script)
+		assert.Empty(t, res.CrashBundle, "script %q", script)
+		_, err := os.Stat(filepath.Join(params.InstallFolder, ".itch", "crashes"))
+		assert.True(t, os.IsNotExist(err), "script %q", script)
+	}
+}
+
+func TestCrashBundleIncludesSandboxPolicy(t *testing.T) {
+	params := newCrashTestParams(t)
+	params.Sandbox = true
+	profilePath := filepath.Join(params.InstallFolder, ".itch", "isolate-app.profile")
+	require.NoError(t, os.MkdirAll(filepath.Dir(profilePath), 0o755))
+	require.NoError(t, os.WriteFile(profilePath, []byte("private-tmp\n"), 0o644))
+
+	cc := newCrashCollector(params, []string{"firejail", "--profile=" + profilePath}, nil, BackendFirejail)
+	res := newExitResult()
+	res.Signal = syscall.SIGABRT
+	res.Backend = BackendFirejail
+	cc.collect(res)
+	require.NotEmpty(t, res.CrashBundle)
+
+	report := readCrashReport(t, res.CrashBundle)
+	assert.Equal(t, BackendFirejail, report.Backend)
+	assert.Contains(t, report.Files, "isolate-app.profile")
+	profile, err := os.ReadFile(filepath.Join(res.CrashBundle, "isolate-app.profile"))
+	require.NoError(t, err)
+	assert.Equal(t, "private-tmp\n", string(profile))
+}
+
+func TestCrashBundleIncludesGeneratedPolicies(t *testing.T) {
+	params := newCrashTestParams(t)
+	params.Sandbox = true
+	dir := t.TempDir()
+
+	nsjailConfig := filepath.Join(dir, "nsjail-launch.cfg")
+	require.NoError(t, os.WriteFile(nsjailConfig, []byte("envar: \"LANG=C\"\nenvar: \"ITCHIO_API_KEY=hunter2\"\n"), 0o600))
+	ociBundle := filepath.Join(dir, "oci-launch")
+	require.NoError(t, os.MkdirAll(ociBundle, 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(ociBundle, "config.json"), []byte(`{"process":{"env":["LANG=C","ITCHIO_API_KEY=hunter2"]}}`), 0o600))
+
+	for _, tc := range []struct {
+		backend  Backend
+		command  []string
+		name     string
+		contents []string
+	}{
+		{BackendNsjail, []string{"nsjail", "--config", nsjailConfig}, "nsjail.cfg", []string{"envar: \"LANG=C\"\n", "ITCHIO_API_KEY=[redacted]"}},
+		{BackendOCI, []string{"crun", "run", "--bundle", ociBundle, "smaug-launch-1"}, "config.json", []string{`"LANG=C"`, "ITCHIO_API_KEY=[redacted]"}},
+		{BackendBubblewrap, []string{"bwrap", "--unshare-pid", "--setenv", "ITCHIO_API_KEY", "hunter2", "--", "/bin/game"}, "sandbox.args", []string{"--unshare-pid\n", "ITCHIO_API_KEY\n[redacted]\n"}},
+		{BackendSystemd, []string{"systemd-run", "--user", "--property=PrivateNetwork=yes", "--", "/bin/game"}, "unit.properties", []string{"PrivateNetwork=yes\n"}},
+	} {
+		cc := newCrashCollector(params, tc.command, nil, tc.backend)
+		res := newExitResult()
+		res.Signal = syscall.SIGSEGV
+		res.Backend = tc.backend
+		cc.collect(res)
+		require.NotEmpty(t, res.CrashBundle, "backend %s", tc.backend)
+
+		assert.Contains(t, readCrashReport(t, res.CrashBundle).Files, tc.name, "backend %s", tc.backend)
+		contents, err := os.ReadFile(filepath.Join(res.CrashBundle, tc.name))
+		require.NoError(t, err)
+		for _, want := range tc.contents {
+			assert.Contains(t, string(contents), want, "backend %s", tc.backend)
+		}
+		assert.NotContains(t, string(contents), "hunter2", "backend %s", tc.backend)
+		require.NoError(t, os.RemoveAll(res.CrashBundle))
+	}
+
+	// Per-launch policies are removed by the time the launch is over.
+	cc := newCrashCollector(params, []string{"crun", "run", "--bundle", ociBundle, "smaug-launch-1"}, nil, BackendOCI)
+	require.NoError(t, os.RemoveAll(ociBundle))
+	res := newExitResult()
+	res.Signal = syscall.SIGSEGV
+	res.Backend = BackendOCI
+	cc.collect(res)
+	require.NotEmpty(t, res.CrashBundle)
+	assert.Contains(t, readCrashReport(t, res.CrashBundle).Files, "config.json")
+}
+
+func TestRedactArgs(t *testing.T) {
+	assert.Equal(t, []string{
+		"--setenv", "HOME", "/home/player",
+		"--setenv", "ITCHIO_API_KEY", "[redacted]",
+		"--auth-token=[redacted]",
+		"--level=3",
+		"key",
+	}, redactArgs([]string{
+		"--setenv", "HOME", "/home/player",
+		"--setenv", "ITCHIO_API_KEY", "abcdef",
+		"--auth-token=abcdef",
+		"--level=3",
+		"key",
+	}))
+}
+
+func TestFindCoreDump(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Now().Add(-time.Minute)
+	for _, name := range []string{"core.1234", "core.txt", "corefile"} {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	old := filepath.Join(dir, "core")
+	require.NoError(t, os.WriteFile(old, nil, 0o644))
+	require.NoError(t, os.Chtimes(old, start.Add(-time.Hour), start.Add(-time.Hour)))
+
+	assert.Equal(t, filepath.Join(dir, "core.1234"), findCoreDump(dir, start))
+	assert.Equal(t, "", findCoreDump(dir, time.Now().Add(time.Minute)))
+
+	// Stamped by a coarse clock, slightly before the launch started.
+	require.NoError(t, os.Chtimes(old, time.Now(), time.Now()))
+	assert.Equal(t, old, findCoreDump(dir, time.Now().Add(300*time.Millisecond)))
+}
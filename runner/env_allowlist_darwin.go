@@ -25,19 +25,8 @@ func darwinSandboxEnvAllowlist() []string {
 }
 
 func collectAllowedEnvDarwin(paramsEnv []string, hostEnv []string, extraKeys []string) []string {
-	allowlist := darwinSandboxEnvAllowlist()
-	allowlist = append(allowlist, extraKeys...)
-	out := make([]string, 0, len(allowlist))
-	for _, key := range allowlist {
-		if val, found := envLookupWithPresenceDarwin(paramsEnv, key); found {
-			out = append(out, key+"="+val)
-			continue
-		}
-		if val, found := envLookupWithPresenceDarwin(hostEnv, key); found {
-			out = append(out, key+"="+val)
-		}
-	}
-	return out
+	allowlist := append(darwinSandboxEnvAllowlist(), extraKeys...)
+	return EnvPolicy{Allow: allowlist}.environ(paramsEnv, hostEnv)
 }
 
 func envLookupWithPresenceDarwin(env []string, key string) (string, bool) {
@@ -29,19 +29,8 @@ func SandboxEnvAllowlist() []string {
 }
 
 func collectAllowedEnv(paramsEnv []string, hostEnv []string, extraKeys []string) []string {
-	allowlist := SandboxEnvAllowlist()
-	allowlist = append(allowlist, extraKeys...)
-	out := make([]string, 0, len(allowlist))
-	for _, key := range allowlist {
-		if val, found := envLookupWithPresence(paramsEnv, key); found {
-			out = append(out, key+"="+val)
-			continue
-		}
-		if val, found := envLookupWithPresence(hostEnv, key); found {
-			out = append(out, key+"="+val)
-		}
-	}
-	return out
+	allowlist := append(SandboxEnvAllowlist(), extraKeys...)
+	return EnvPolicy{Allow: allowlist}.environ(paramsEnv, hostEnv)
 }
 
 // envLookup looks up a key in a []string{"KEY=VALUE", ...} slice.
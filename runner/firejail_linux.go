@@ -7,9 +7,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"text/template"
-
-	"github.com/itchio/smaug/runner/policies"
+	"slices"
+	"strings"
 )
 
 type firejailRunner struct {
@@ -17,8 +16,18 @@ type firejailRunner struct {
 }
 
 var _ Runner = (*firejailRunner)(nil)
+var _ Starter = (*firejailRunner)(nil)
 var firejailCommand = exec.Command
 
+// firejailRlimitOptions maps rlimit names to the firejail profile options
+// that set them.
+var firejailRlimitOptions = map[string]string{
+	"as":     "rlimit-as",
+	"nofile": "rlimit-nofile",
+	"cpu":    "rlimit-cpu",
+	"nproc":  "rlimit-nproc",
+}
+
 func newFirejailRunner(params RunnerParams) (Runner, error) {
 	if params.FirejailParams.BinaryPath == "" {
 		return nil, fmt.Errorf("FirejailParams.BinaryPath must be set")
@@ -36,7 +45,7 @@ func (fr *firejailRunner) Prepare() error {
 	return nil
 }
 
-func (fr *firejailRunner) Run() error {
+func (fr *firejailRunner) Start() (*Handle, error) {
 	params := fr.params
 	consumer := params.Consumer
 
@@ -46,33 +55,70 @@ func (fr *firejailRunner) Run() error {
 	consumer.Opf("Writing sandbox profile to (%s)", sandboxProfilePath)
 	err := os.MkdirAll(filepath.Dir(sandboxProfilePath), 0755)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	sandboxTemplate, err := template.New("firejail-profile").Parse(policies.FirejailTemplate)
+	policy := launchSandboxPolicy(params)
+
+	sandboxFile, err := os.OpenFile(sandboxProfilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
+	defer sandboxFile.Close()
 
-	sandboxFile, err := os.OpenFile(sandboxProfilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	_, err = sandboxFile.WriteString(firejailProfile(params, policy))
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	err = sandboxTemplate.Execute(sandboxFile, params)
+	// firejail applies most limits itself through profile options. The
+	// rest are set on firejail by the rlimit shim and inherited from there.
+	var shimLimits []rlimit
+	for _, l := range launchRlimits(params) {
+		option, ok := firejailRlimitOptions[l.name]
+		if !ok {
+			shimLimits = append(shimLimits, l)
+			continue
+		}
+		_, err = fmt.Fprintf(sandboxFile, "%s %d\n", option, l.value)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	seccompOption, err := firejailSeccompOption(params.SandboxConfig)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
+	}
+	if seccompOption != "" {
+		_, err = fmt.Fprintln(sandboxFile, seccompOption)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+	}
+
+	if params.SandboxConfig.Landlock && checkLandlock(consumer) {
+		home := envLookup(params.Env, "HOME")
+		if home == "" {
+			home = os.Getenv("HOME")
+		}
+		for _, option := range firejailLandlockOptions(landlockRulesFor(params, home)) {
+			_, err = fmt.Fprintln(sandboxFile, option)
+			if err != nil {
+				return nil, fmt.Errorf("%w", err)
+			}
+		}
 	}
 
 	msg := fmt.Sprintf("Running (%s) through firejail", params.FullTargetPath)
-	if params.SandboxConfig.NoNetwork {
+	if policy.Network == NetworkNone {
 		msg += " (networking disabled)"
 	}
 	consumer.Opf("%s", msg)
 
 	var args []string
 	args = append(args, fmt.Sprintf("--profile=%s", sandboxProfilePath))
-	if params.SandboxConfig.NoNetwork {
+	if policy.Network == NetworkNone {
 		args = append(args, "--net=none")
 	}
 	args = append(args, "--")
@@ -81,29 +127,104 @@ func (fr *firejailRunner) Run() error {
 
 	cmd := firejailCommand(firejailPath, args...)
 	cmd.Dir = params.Dir
-	cmd.Env = collectAllowedEnv(params.Env, os.Environ(), params.SandboxConfig.AllowEnv)
+	cmd.Env = policy.Env.environ(params.Env, os.Environ())
+	cmd.Stdin = params.Stdin
 	cmd.Stdout = params.Stdout
 	cmd.Stderr = params.Stderr
+	wrapWithRlimitShim(consumer, cmd, shimLimits)
+
+	return startCommand(params, cmd, BackendFirejail)
+}
 
-	pg, err := NewProcessGroup(consumer, cmd, params.Ctx)
+func (fr *firejailRunner) Run() (*ExitResult, error) {
+	h, err := fr.Start()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
+	return h.Wait()
+}
 
-	err = pg.AfterStart()
-	if err != nil {
-		return fmt.Errorf("%w", err)
+// firejailProfile returns the profile enforcing the filesystem rules of
+// policy that firejail can: firejail shares the host's filesystem, so paths
+// are only blacklisted. Paths exposed again inside a denied folder are
+// kept visible by blacklisting the folder's other entries instead, level by
+// level, since firejail can't undo a blacklist of their parent. Rules with a
+// Source and tmpfs rules are skipped.
+func firejailProfile(params RunnerParams, policy *SandboxPolicy) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "include /etc/firejail/itch_game_%s.local\n", params.Name)
+	b.WriteString("include /etc/firejail/itch_games_globals.local\n\n")
+
+	home := os.Getenv("HOME")
+	line := func(option string, path string) {
+		if filepath.IsAbs(home) && path != home && isPathWithin(path, home) {
+			path = "${HOME}" + strings.TrimPrefix(path, strings.TrimSuffix(home, "/"))
+		}
+		fmt.Fprintf(&b, "%s %s\n", option, path)
 	}
 
-	err = cmd.Start()
-	if err != nil {
-		return fmt.Errorf("%w", err)
+	for i, rule := range policy.Filesystem {
+		if rule.Source != "" {
+			continue
+		}
+		switch rule.Access {
+		case FilesystemReadOnly, FilesystemReadWrite:
+			if !isExposedBy(policy.Filesystem[:i], rule.Path, rule.Access) {
+				line("noblacklist", rule.Path)
+			}
+		case FilesystemDeny:
+			var exposed []string
+			for _, later := range policy.Filesystem[i+1:] {
+				if later.Source == "" && (later.Access == FilesystemReadOnly || later.Access == FilesystemReadWrite) {
+					exposed = append(exposed, later.Path)
+				}
+			}
+			firejailBlacklist(line, rule.Path, exposed)
+		}
 	}
+	return b.String()
+}
 
-	err = pg.Wait()
-	if err != nil {
-		return fmt.Errorf("%w", err)
+// firejailBlacklist blacklists path, except for the exposed paths inside
+// it.
+func firejailBlacklist(line func(option string, path string), path string, exposed []string) {
+	var inside []string
+	for _, e := range exposed {
+		if isPathWithin(path, e) {
+			// Exposed again as a whole.
+			return
+		}
+		if isPathWithin(e, path) {
+			inside = append(inside, e)
+		}
+	}
+	if len(inside) == 0 {
+		line("blacklist", path)
+		return
 	}
 
-	return nil
+	// Entries of path on the way to exposed paths.
+	var entries []string
+	for _, e := range inside {
+		rel, err := filepath.Rel(path, e)
+		if err != nil {
+			continue
+		}
+		first, _, _ := strings.Cut(rel, "/")
+		entry := filepath.Join(path, first)
+		if !slices.Contains(entries, entry) {
+			entries = append(entries, entry)
+		}
+	}
+	slices.Sort(entries)
+
+	for _, entry := range entries {
+		line("noblacklist", entry)
+	}
+	line("blacklist", filepath.Join(path, "*"))
+	for _, entry := range entries {
+		if !slices.Contains(inside, entry) {
+			firejailBlacklist(line, entry, inside)
+		}
+	}
 }
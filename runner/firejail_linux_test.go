@@ -73,7 +73,8 @@ func TestFirejailNoNetworkAddsNetNone(t *testing.T) {
 	}
 
 	fr := newFirejailTestRunner(t, true)
-	require.NoError(t, fr.Run())
+	_, err := fr.Run()
+	require.NoError(t, err)
 	assert.Equal(t, "/fake/firejail", gotName)
 	assert.Contains(t, gotArgs, "--net=none")
 }
@@ -91,7 +92,8 @@ func TestFirejailNoNetworkDisabledOmitsNetNone(t *testing.T) {
 	}
 
 	fr := newFirejailTestRunner(t, false)
-	require.NoError(t, fr.Run())
+	_, err := fr.Run()
+	require.NoError(t, err)
 	assert.NotContains(t, gotArgs, "--net=none")
 }
 
@@ -115,7 +117,8 @@ func TestFirejailUsesAllowlistedEnvironment(t *testing.T) {
 		"TMP=/game/.itch/temp",
 	}
 
-	require.NoError(t, fr.Run())
+	_, err := fr.Run()
+	require.NoError(t, err)
 
 	gotEnv := parseEnvironmentOutput(stdout.String())
 	assert.Equal(t, "sandbox-user", gotEnv["USER"])
@@ -145,7 +148,8 @@ func TestFirejailEnvironmentPrefersParamsAndFallsBackToHost(t *testing.T) {
 		"USER=params-user",
 	}
 
-	require.NoError(t, fr.Run())
+	_, err := fr.Run()
+	require.NoError(t, err)
 
 	gotEnv := parseEnvironmentOutput(stdout.String())
 	assert.Equal(t, "params-user", gotEnv["USER"])
@@ -163,7 +167,8 @@ func TestFirejailProfileBlacklistsSensitivePaths(t *testing.T) {
 	}
 
 	fr := newFirejailTestRunner(t, false)
-	require.NoError(t, fr.Run())
+	_, err := fr.Run()
+	require.NoError(t, err)
 
 	profilePath := filepath.Join(fr.params.InstallFolder, ".itch", "isolate-app.profile")
 	profileBytes, err := os.ReadFile(profilePath)
@@ -175,3 +180,23 @@ func TestFirejailProfileBlacklistsSensitivePaths(t *testing.T) {
 	assert.Contains(t, profileText, "blacklist ${HOME}/.aws")
 	assert.Contains(t, profileText, "blacklist ${HOME}/.config/google-chrome")
 }
+
+func TestFirejailPassesStdin(t *testing.T) {
+	origCommand := firejailCommand
+	t.Cleanup(func() {
+		firejailCommand = origCommand
+	})
+
+	firejailCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("cat")
+	}
+
+	var stdout bytes.Buffer
+	fr := newFirejailTestRunner(t, false)
+	fr.params.Stdin = strings.NewReader("hello from stdin\n")
+	fr.params.Stdout = &stdout
+
+	_, err := fr.Run()
+	require.NoError(t, err)
+	assert.Equal(t, "hello from stdin\n", stdout.String())
+}
@@ -0,0 +1,200 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// flatpakInfoPath exists in every Flatpak sandbox.
+var flatpakInfoPath = "/.flatpak-info"
+
+// flatpakSpawnPath is where the Freedesktop runtime ships flatpak-spawn.
+const flatpakSpawnPath = "/usr/bin/flatpak-spawn"
+
+var flatpakSpawnCommand = exec.Command
+
+// flatpakRuntimePaths are part of every Flatpak sandbox.
+var flatpakRuntimePaths = []string{"/usr", "/app", "/bin", "/sbin", "/lib", "/lib64"}
+
+// runningInFlatpak returns true if the launcher runs inside a Flatpak
+// sandbox, where user namespaces can't be nested, so that bubblewrap,
+// firejail and the native sandbox don't work.
+func runningInFlatpak() bool {
+	_, err := os.Stat(flatpakInfoPath)
+	return err == nil
+}
+
+type flatpakRunner struct {
+	params RunnerParams
+}
+
+var _ Runner = (*flatpakRunner)(nil)
+var _ Starter = (*flatpakRunner)(nil)
+
+func newFlatpakRunner(params RunnerParams) (Runner, error) {
+	if params.FlatpakParams.BinaryPath == "" {
+		params.FlatpakParams.BinaryPath = flatpakSpawnPath
+	}
+	if params.InstallFolder == "" {
+		return nil, fmt.Errorf("InstallFolder must be set for the flatpak sandbox")
+	}
+
+	fr := &flatpakRunner{
+		params: params,
+	}
+	return fr, nil
+}
+
+func (fr *flatpakRunner) Prepare() error {
+	return nil
+}
+
+// Start asks the Flatpak portal to run the game in a new sandbox of the
+// launcher's app, with nothing but display, sound and GPU access, and the
+// paths it needs. flatpak-spawn relays the game's output, signals and exit
+// code, and --watch-bus makes the game exit if flatpak-spawn does.
+func (fr *flatpakRunner) Start() (*Handle, error) {
+	params := fr.params
+	consumer := params.Consumer
+
+	if params.SandboxConfig.Landlock {
+		consumer.Warnf("Landlock isn't supported by the flatpak sandbox, ignoring it")
+	}
+	if params.SandboxConfig.Seccomp != SeccompPresetNone || len(params.SandboxConfig.SeccompDeny) > 0 {
+		consumer.Warnf("Custom seccomp filters aren't supported by the flatpak sandbox, only Flatpak's own applies")
+	}
+	if len(launchRlimits(params)) > 0 {
+		consumer.Warnf("Resource limits can't be applied through flatpak-spawn, ignoring them")
+	}
+
+	policy := launchSandboxPolicy(params)
+
+	// The sandbox can only expose paths as they are, so HOME points to the
+	// per-game home instead of being mounted over.
+	homeTarget, hasHome := envLookupWithPresence(params.Env, "HOME")
+	if !hasHome {
+		homeTarget = os.Getenv("HOME")
+	}
+	var home string
+	for _, rule := range policy.Filesystem {
+		if rule.Access != FilesystemReadWrite || rule.Source == "" {
+			continue
+		}
+		err := os.MkdirAll(rule.Source, 0o755)
+		if err != nil {
+			return nil, fmt.Errorf("%w", err)
+		}
+		if rule.Path == homeTarget {
+			home = rule.Source
+		}
+	}
+
+	msg := fmt.Sprintf("Running (%s) through flatpak-spawn", params.FullTargetPath)
+	if policy.Network == NetworkNone {
+		msg += " (networking disabled)"
+	}
+	consumer.Opf("%s", msg)
+
+	args := []string{
+		"--sandbox",
+		"--watch-bus",
+	}
+	if slices.Contains(policy.Sockets, SocketX11) || slices.Contains(policy.Sockets, SocketWayland) {
+		args = append(args, "--sandbox-flag=share-display")
+	}
+	if slices.Contains(policy.Sockets, SocketPulseAudio) || slices.Contains(policy.Sockets, SocketPipeWire) {
+		args = append(args, "--sandbox-flag=share-sound")
+	}
+	if slices.Contains(policy.Devices, DeviceGPU) {
+		args = append(args, "--sandbox-flag=share-gpu")
+	}
+	if policy.Network == NetworkNone {
+		args = append(args, "--no-network")
+	}
+	for _, path := range flatpakExposedPaths(policy) {
+		args = append(args, "--sandbox-expose-path="+path)
+	}
+	for _, path := range flatpakReadOnlyPaths(policy) {
+		args = append(args, "--sandbox-expose-path-ro="+path)
+	}
+	if params.Dir != "" {
+		args = append(args, "--directory="+params.Dir)
+	}
+
+	// The sandbox starts from the portal's environment.
+	args = append(args, "--clear-env")
+	for _, kv := range policy.Env.environ(params.Env, os.Environ()) {
+		if home != "" && strings.HasPrefix(kv, "HOME=") {
+			continue
+		}
+		args = append(args, "--env="+kv)
+	}
+	if home != "" {
+		args = append(args, "--env=HOME="+home)
+	}
+
+	args = append(args, "--")
+	args = append(args, params.FullTargetPath)
+	args = append(args, params.Args...)
+
+	cmd := flatpakSpawnCommand(params.FlatpakParams.BinaryPath, args...)
+	cmd.Dir = params.Dir
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+
+	return startCommand(params, cmd, BackendFlatpak)
+}
+
+func (fr *flatpakRunner) Run() (*ExitResult, error) {
+	h, err := fr.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h.Wait()
+}
+
+// flatpakExposedPaths returns the paths a launch may write to, as they are
+// on the host. Denied paths inside them can't be hidden by the portal.
+func flatpakExposedPaths(policy *SandboxPolicy) []string {
+	var paths []string
+	for _, rule := range policy.Filesystem {
+		path := rule.hostPath()
+		if rule.Access == FilesystemReadWrite && !slices.Contains(paths, path) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// flatpakReadOnlyPaths returns the paths a launch may only read, besides
+// those the sandbox has of its own or can already write to, e.g. the
+// directory of the game's executable when it isn't in the install folder.
+func flatpakReadOnlyPaths(policy *SandboxPolicy) []string {
+	provided := append(flatpakExposedPaths(policy), flatpakRuntimePaths...)
+	provided = append(provided, sandboxSystemPaths...)
+
+	var paths []string
+	for _, rule := range policy.Filesystem {
+		path := rule.hostPath()
+		if rule.Access != FilesystemReadOnly || slices.Contains(paths, path) {
+			continue
+		}
+		if !slices.ContainsFunc(provided, func(dir string) bool { return isPathWithin(path, dir) }) {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// isPathWithin returns true if path is dir or is inside it.
+func isPathWithin(path string, dir string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, "../")
+}
@@ -0,0 +1,130 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeFlatpak makes the launcher look like it runs inside Flatpak, or not.
+func fakeFlatpak(t *testing.T, inFlatpak bool) {
+	t.Helper()
+	origPath := flatpakInfoPath
+	t.Cleanup(func() {
+		flatpakInfoPath = origPath
+	})
+
+	flatpakInfoPath = filepath.Join(t.TempDir(), ".flatpak-info")
+	if inFlatpak {
+		require.NoError(t, os.WriteFile(flatpakInfoPath, []byte("[Application]\nname=io.itch.itch\n"), 0o644))
+	}
+}
+
+func TestFlatpakRunner(t *testing.T) {
+	fakeFlatpak(t, true)
+	origCommand := flatpakSpawnCommand
+	t.Cleanup(func() {
+		flatpakSpawnCommand = origCommand
+	})
+
+	var gotName string
+	var gotArgs []string
+	flatpakSpawnCommand = func(name string, args ...string) *exec.Cmd {
+		gotName = name
+		gotArgs = append([]string{}, args...)
+		return exec.Command("sh", "-c", "echo hello")
+	}
+
+	params, stdout := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.SandboxConfig.NoNetwork = true
+	params.TempDir = t.TempDir()
+	params.Dir = params.InstallFolder
+	params.FlatpakParams.BinaryPath = filepath.Join(t.TempDir(), "flatpak-spawn")
+	require.NoError(t, os.WriteFile(params.FlatpakParams.BinaryPath, nil, 0o755))
+	// Ignored in favor of flatpak-spawn.
+	params.BubblewrapParams.BinaryPath = "/usr/bin/bwrap"
+
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	require.IsType(t, &flatpakRunner{}, r)
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, BackendFlatpak, res.Backend)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	home := filepath.Join(params.InstallFolder, ".itch", "home")
+	assert.DirExists(t, home)
+	assert.Equal(t, params.FlatpakParams.BinaryPath, gotName)
+	assert.Equal(t, "--sandbox", gotArgs[0])
+	for _, arg := range []string{
+		"--watch-bus",
+		"--no-network",
+		"--sandbox-expose-path=" + params.InstallFolder,
+		"--sandbox-expose-path=" + home,
+		"--sandbox-expose-path=" + params.TempDir,
+		"--directory=" + params.InstallFolder,
+		"--clear-env",
+		"--env=HOME=" + home,
+		"--env=PATH=/usr/bin:/bin",
+	} {
+		assert.Contains(t, gotArgs, arg)
+	}
+	assert.NotContains(t, gotArgs, "--env=HOME=/home/player")
+	assert.NotContains(t, gotArgs, "--env=SMAUG_TEST_SECRET=1")
+	// /bin/sh is part of the runtime.
+	for _, arg := range gotArgs {
+		assert.NotRegexp(t, "^--sandbox-expose-path-ro=", arg)
+	}
+	assert.Equal(t, []string{"--", "/bin/sh", "-c", "true"}, gotArgs[len(gotArgs)-4:])
+}
+
+func TestFlatpakReadOnlyPaths(t *testing.T) {
+	readOnlyPaths := func(params RunnerParams) []string {
+		policy, err := DefaultSandboxPolicy(params)
+		require.NoError(t, err)
+		return flatpakReadOnlyPaths(policy)
+	}
+
+	params := RunnerParams{
+		InstallFolder:  "/home/player/games/tetris",
+		FullTargetPath: "/home/player/games/tetris/bin/tetris",
+	}
+	assert.Empty(t, readOnlyPaths(params))
+
+	params.FullTargetPath = "/home/player/.local/share/runtimes/wine/bin/wine"
+	assert.Equal(t, []string{"/home/player/.local/share/runtimes/wine/bin"}, readOnlyPaths(params))
+
+	params.FullTargetPath = "/home/player/games/tetris-launcher/run"
+	assert.NotEmpty(t, readOnlyPaths(params))
+
+	// Only extra paths are exposed from custom policies too.
+	policy := &SandboxPolicy{Filesystem: []FilesystemRule{
+		{Path: "/usr/share/fonts", Access: FilesystemReadOnly},
+		{Path: "/opt/shared-assets", Access: FilesystemReadOnly},
+		{Path: "/opt/shared-assets/saves", Access: FilesystemReadWrite},
+	}}
+	assert.Equal(t, []string{"/opt/shared-assets"}, flatpakReadOnlyPaths(policy))
+	assert.Equal(t, []string{"/opt/shared-assets/saves"}, flatpakExposedPaths(policy))
+}
+
+func TestFlatpakNotSelectedOutsideFlatpak(t *testing.T) {
+	fakeFlatpak(t, false)
+
+	params, _ := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &nativeRunner{}, r)
+
+	params.SandboxConfig.Type = SandboxTypeFlatpak
+	r, err = GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &flatpakRunner{}, r)
+}
@@ -0,0 +1,16 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func runningInFlatpak() bool {
+	return false
+}
+
+func newFlatpakRunner(params RunnerParams) (Runner, error) {
+	return nil, fmt.Errorf("flatpak runner is not implemented on %s", runtime.GOOS)
+}
@@ -78,7 +78,7 @@ func (wr *fujiRunner) Prepare() error {
 	return nil
 }
 
-func (wr *fujiRunner) Run() error {
+func (wr *fujiRunner) Run() (*ExitResult, error) {
 	var err error
 	params := wr.params
 	consumer := params.Consumer
@@ -88,12 +88,12 @@ func (wr *fujiRunner) Run() error {
 
 	env, err := wr.getEnvironment()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	sp, err := wr.getSharingPolicy()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	consumer.Infof("Sharing policy: %s", sp)
@@ -119,6 +119,7 @@ func (wr *fujiRunner) Run() error {
 		cmd.Password = creds.Password
 		cmd.Dir = params.Dir
 		cmd.Env = env
+		cmd.Stdin = params.Stdin
 		cmd.Stdout = params.Stdout
 		cmd.Stderr = params.Stderr
 
@@ -134,11 +135,18 @@ func (wr *fujiRunner) Run() error {
 
 		pg, err = NewProcessGroup(consumer, cmd, params.Ctx)
 		if err != nil {
-			return fmt.Errorf("%w", err)
+			return nil, fmt.Errorf("%w", err)
 		}
+		pg.timeLimits = params.TimeLimits
+
+		pg.log = openSessionLog(params)
+		pg.lines = newOutputLines(params)
+		cmd.Stdout = pg.lines.writer(OutputStdout, pg.log.writer(cmd.Stdout))
+		cmd.Stderr = pg.lines.writer(OutputStderr, pg.log.writer(cmd.Stderr))
 
 		err = cmd.Start()
 		if err != nil {
+			pg.log.abort()
 			// After granting ACL permissions, Windows may not have fully
 			// propagated them yet. Retry on access denied errors since the
 			// permissions will become effective shortly.
@@ -148,14 +156,14 @@ func (wr *fujiRunner) Run() error {
 					select {
 					case <-time.After(startRetryDelay):
 					case <-params.Ctx.Done():
-						return fmt.Errorf("%w", params.Ctx.Err())
+						return nil, fmt.Errorf("%w", params.Ctx.Err())
 					}
 				} else {
 					time.Sleep(startRetryDelay)
 				}
 				continue
 			}
-			return fmt.Errorf("%w", err)
+			return nil, fmt.Errorf("%w", err)
 		}
 
 		break
@@ -163,15 +171,18 @@ func (wr *fujiRunner) Run() error {
 
 	err = pg.AfterStart()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	err = pg.Wait()
+	res, err := pg.Wait()
+	res.Backend = BackendFuji
+	pg.lines.flush()
+	err = pg.log.finish(res, err)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return res, fmt.Errorf("%w", err)
 	}
 
-	return nil
+	return res, nil
 }
 
 func (wr *fujiRunner) getSharingPolicy() (*winox.SharingPolicy, error) {
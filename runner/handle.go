@@ -0,0 +1,108 @@
+package runner
+
+import (
+	"os"
+	"syscall"
+)
+
+// Starter is implemented by runners that can launch a process without
+// blocking until it exits. Run is equivalent to Start followed by Wait.
+type Starter interface {
+	Start() (*Handle, error)
+}
+
+// Handle tracks a process group launched with Starter.Start.
+// Cancelling RunnerParams.Ctx still shuts the group down as with Run.
+type Handle struct {
+	pg   *processGroup
+	pid  int
+	done chan struct{}
+
+	res *ExitResult
+	err error
+}
+
+func newHandle(pg *processGroup, pid int, backend Backend) *Handle {
+	h := &Handle{
+		pg:   pg,
+		pid:  pid,
+		done: make(chan struct{}),
+	}
+
+	go func() {
+		res, err := pg.Wait()
+		res.Backend = backend
+		res.decodeRelayedSignal()
+		pg.lines.flush()
+		err = pg.log.finish(res, err)
+		pg.crash.collect(res)
+		h.res = res
+		h.err = err
+		close(h.done)
+	}()
+
+	return h
+}
+
+// Console returns the pseudo-terminal the launch runs in, or nil if
+// RunnerParams.Console wasn't set or not on Linux.
+func (h *Handle) Console() *Console {
+	return h.pg.console
+}
+
+// Pid returns the process ID of the main process.
+func (h *Handle) Pid() int {
+	return h.pid
+}
+
+// Done returns a channel that is closed once Wait would no longer block.
+func (h *Handle) Done() <-chan struct{} {
+	return h.done
+}
+
+// Wait blocks until the process group has exited, then returns the same
+// values Run would have.
+func (h *Handle) Wait() (*ExitResult, error) {
+	<-h.done
+	return h.res, h.err
+}
+
+// Signal sends sig to every process in the group. It returns
+// os.ErrProcessDone if the group has already been waited for.
+func (h *Handle) Signal(sig syscall.Signal) error {
+	select {
+	case <-h.done:
+		return os.ErrProcessDone
+	default:
+	}
+	return h.pg.signal(sig)
+}
+
+// Kill immediately kills every process in the group.
+func (h *Handle) Kill() error {
+	return h.Signal(syscall.SIGKILL)
+}
+
+// Pause suspends every process in the group until Resume is called. On
+// Linux with cgroup tracking, the cgroup freezer is used. Otherwise, the
+// group is sent SIGSTOP. Time spent paused is not counted in
+// ExitResult.Duration, nor against TimeLimits.WallClock. Not supported on
+// Windows.
+func (h *Handle) Pause() error {
+	select {
+	case <-h.done:
+		return os.ErrProcessDone
+	default:
+	}
+	return h.pg.pause()
+}
+
+// Resume continues a group suspended by Pause.
+func (h *Handle) Resume() error {
+	select {
+	case <-h.done:
+		return os.ErrProcessDone
+	default:
+	}
+	return h.pg.resume()
+}
@@ -0,0 +1,410 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"unsafe"
+
+	"github.com/itchio/headway/state"
+	"golang.org/x/sys/unix"
+)
+
+// landlockShimEnv is set on a re-executed launcher to make it restrict
+// filesystem access with Landlock and exec into its arguments instead of
+// running normally. Its value is a JSON-encoded landlockRules.
+const landlockShimEnv = "SMAUG_LANDLOCK"
+
+// landlockLauncherPath is where the launcher is mounted in bubblewrap and
+// native sandboxes, so that it can apply Landlock rules from inside them.
+const landlockLauncherPath = "/run/smaug/launcher"
+
+// landlockSystemPaths may be read and executed from, if they exist.
+var landlockSystemPaths = []string{
+	"/usr", "/lib", "/lib32", "/lib64", "/bin", "/sbin", "/etc", "/opt",
+	"/sys", "/proc", "/run",
+}
+
+// landlockDevicePaths may be read and written to, if they exist: GPUs,
+// audio, input devices, /dev/null, /dev/shm, etc.
+var landlockDevicePaths = []string{"/dev"}
+
+// landlockFileAccess are the access rights that apply to files, as opposed
+// to directories. Rules on files may only grant those.
+const landlockFileAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_TRUNCATE |
+	unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+
+const landlockReadExecAccess = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+	unix.LANDLOCK_ACCESS_FS_READ_FILE |
+	unix.LANDLOCK_ACCESS_FS_READ_DIR
+
+// landlockRules are the paths a launch may access. Anything else is denied.
+type landlockRules struct {
+	ReadExec  []string `json:"rx,omitempty"`
+	ReadWrite []string `json:"rw,omitempty"`
+}
+
+func init() {
+	spec, ok := os.LookupEnv(landlockShimEnv)
+	if !ok {
+		return
+	}
+	if _, ok := os.LookupEnv(rlimitShimEnv); ok {
+		// The rlimit shim runs first, and re-executes the launcher without
+		// rlimitShimEnv once the limits are set.
+		return
+	}
+	os.Exit(runLandlockShim(spec, os.Args))
+}
+
+// landlockABI returns the Landlock ABI version supported by the kernel, or
+// 0 if Landlock is unsupported or disabled.
+var landlockABI = kernelLandlockABI
+
+func kernelLandlockABI() int {
+	abi, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, 0, 0, unix.LANDLOCK_CREATE_RULESET_VERSION)
+	if errno != 0 {
+		return 0
+	}
+	return int(abi)
+}
+
+// landlockHandledAccess returns the filesystem access rights known to a
+// given ABI version. Those are denied unless a rule grants them.
+func landlockHandledAccess(abi int) uint64 {
+	var access uint64 = unix.LANDLOCK_ACCESS_FS_EXECUTE |
+		unix.LANDLOCK_ACCESS_FS_WRITE_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_FILE |
+		unix.LANDLOCK_ACCESS_FS_READ_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_DIR |
+		unix.LANDLOCK_ACCESS_FS_REMOVE_FILE |
+		unix.LANDLOCK_ACCESS_FS_MAKE_CHAR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_DIR |
+		unix.LANDLOCK_ACCESS_FS_MAKE_REG |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_FIFO |
+		unix.LANDLOCK_ACCESS_FS_MAKE_BLOCK |
+		unix.LANDLOCK_ACCESS_FS_MAKE_SYM
+	if abi >= 2 {
+		access |= unix.LANDLOCK_ACCESS_FS_REFER
+	}
+	if abi >= 3 {
+		access |= unix.LANDLOCK_ACCESS_FS_TRUNCATE
+	}
+	if abi >= 5 {
+		access |= unix.LANDLOCK_ACCESS_FS_IOCTL_DEV
+	}
+	return access
+}
+
+// landlockRulesFor returns the rules of a launch: system paths are
+// read-only, and only the install folder, temp dirs (TempDir, /tmp and
+// $TMPDIR), working directory and home (the sandbox home, where there's
+// one) are writable.
+func landlockRulesFor(params RunnerParams, home string) landlockRules {
+	rules := landlockRules{
+		ReadExec:  append([]string{}, landlockSystemPaths...),
+		ReadWrite: append([]string{}, landlockDevicePaths...),
+	}
+	tmpDir, ok := envLookupWithPresence(params.Env, "TMPDIR")
+	if !ok {
+		tmpDir = os.Getenv("TMPDIR")
+	}
+	for _, path := range []string{params.InstallFolder, params.TempDir, "/tmp", params.Dir, home} {
+		if path != "" {
+			rules.ReadWrite = append(rules.ReadWrite, path)
+		}
+	}
+	if filepath.IsAbs(tmpDir) && tmpDir != "/tmp" {
+		rules.ReadWrite = append(rules.ReadWrite, tmpDir)
+	}
+	if xauthority := landlockXauthority(params.Env); xauthority != "" {
+		rules.ReadExec = append(rules.ReadExec, xauthority)
+	}
+	return rules
+}
+
+// landlockXauthority returns the X11 authority file of the session, if any.
+func landlockXauthority(env []string) string {
+	xauthority := envLookup(env, "XAUTHORITY")
+	if xauthority == "" {
+		xauthority = os.Getenv("XAUTHORITY")
+	}
+	if xauthority == "" {
+		if home := os.Getenv("HOME"); home != "" {
+			xauthority = filepath.Join(home, ".Xauthority")
+		}
+	}
+	if _, err := os.Stat(xauthority); err != nil {
+		return ""
+	}
+	return xauthority
+}
+
+// apply restricts the current thread, and whatever it executes, to rules.
+// Paths that don't exist are skipped.
+func (rules landlockRules) apply(abi int) error {
+	handled := landlockHandledAccess(abi)
+	attr := unix.LandlockRulesetAttr{Access_fs: handled}
+	fd, _, errno := unix.Syscall(unix.SYS_LANDLOCK_CREATE_RULESET, uintptr(unsafe.Pointer(&attr)), unsafe.Sizeof(attr), 0)
+	if errno != 0 {
+		return fmt.Errorf("creating ruleset: %w", errno)
+	}
+	rulesetFd := int(fd)
+	defer unix.Close(rulesetFd)
+
+	addRules := func(paths []string, access uint64) error {
+		for _, path := range paths {
+			err := addLandlockRule(rulesetFd, path, access&handled)
+			if err != nil {
+				return fmt.Errorf("allowing (%s): %w", path, err)
+			}
+		}
+		return nil
+	}
+	err := addRules(rules.ReadExec, landlockReadExecAccess)
+	if err != nil {
+		return err
+	}
+	err = addRules(rules.ReadWrite, handled)
+	if err != nil {
+		return err
+	}
+
+	// Required to restrict oneself without CAP_SYS_ADMIN.
+	err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("setting no_new_privs: %w", err)
+	}
+	_, _, errno = unix.Syscall(unix.SYS_LANDLOCK_RESTRICT_SELF, uintptr(rulesetFd), 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("enforcing ruleset: %w", errno)
+	}
+	return nil
+}
+
+func addLandlockRule(rulesetFd int, path string, access uint64) error {
+	fd, err := unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if errors.Is(err, unix.ENOENT) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer unix.Close(fd)
+
+	var st unix.Stat_t
+	err = unix.Fstat(fd, &st)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if st.Mode&unix.S_IFMT != unix.S_IFDIR {
+		access &= landlockFileAccess
+	}
+
+	attr := unix.LandlockPathBeneathAttr{Allowed_access: access, Parent_fd: int32(fd)}
+	_, _, errno := unix.Syscall6(unix.SYS_LANDLOCK_ADD_RULE, uintptr(rulesetFd), unix.LANDLOCK_RULE_PATH_BENEATH, uintptr(unsafe.Pointer(&attr)), 0, 0, 0)
+	if errno != 0 {
+		return fmt.Errorf("%w", errno)
+	}
+	return nil
+}
+
+// runLandlockShim applies the rules in spec, then replaces the current
+// process with args[1], passing it args[2:] as its argv. It only returns
+// if something went wrong, with the exit code to use.
+func runLandlockShim(spec string, args []string) int {
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "smaug: landlock shim: %s\n", err.Error())
+		return 127
+	}
+
+	if len(args) < 3 {
+		return fail(fmt.Errorf("expected a target to run, got %q", args))
+	}
+
+	// Landlock and no_new_privs apply to the calling thread, which must be
+	// the one that execs.
+	runtime.LockOSThread()
+
+	var rules landlockRules
+	err := json.Unmarshal([]byte(spec), &rules)
+	if err != nil {
+		return fail(fmt.Errorf("malformed rules: %w", err))
+	}
+
+	// The launcher already warned about it if Landlock is unsupported.
+	if abi := landlockABI(); abi > 0 {
+		err = rules.apply(abi)
+		if err != nil {
+			return fail(err)
+		}
+	}
+
+	os.Unsetenv(landlockShimEnv)
+	err = syscall.Exec(args[1], args[2:], os.Environ())
+	return fail(fmt.Errorf("exec (%s): %w", args[1], err))
+}
+
+// checkLandlock reports whether Landlock can be used, warning if it can't.
+func checkLandlock(consumer *state.Consumer) bool {
+	abi := landlockABI()
+	if abi == 0 {
+		consumer.Warnf("Landlock is not supported by this kernel, filesystem access won't be restricted by it")
+		return false
+	}
+	consumer.Infof("Restricting filesystem access with Landlock (ABI v%d)", abi)
+	return true
+}
+
+func formatLandlockRules(rules landlockRules) string {
+	// Only strings and slices of strings, this can't fail.
+	spec, _ := json.Marshal(rules)
+	return string(spec)
+}
+
+// wrapWithLandlockShim rewrites cmd so that it runs through the launcher
+// binary, which applies rules before exec'ing into the original command.
+// Landlock restricts the calling thread, which can't be done between
+// fork and exec from Go.
+func wrapWithLandlockShim(consumer *state.Consumer, cmd *exec.Cmd, rules landlockRules) {
+	if !checkLandlock(consumer) {
+		return
+	}
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env[:len(env):len(env)], landlockShimEnv+"="+formatLandlockRules(rules))
+
+	cmd.Args = append([]string{"smaug-landlock", cmd.Path}, cmd.Args...)
+	cmd.Path = launcherPath
+}
+
+// bubblewrapLandlockArgs adds the options that run command through the
+// Landlock shim inside a bubblewrap (or native) sandbox, and returns the
+// command to run instead. The launcher is mounted into the sandbox for it.
+func bubblewrapLandlockArgs(args *[]string, seen map[string]struct{}, params RunnerParams, home string, command []string) []string {
+	consumer := params.Consumer
+
+	if !checkLandlock(consumer) {
+		return command
+	}
+	launcher, err := os.Executable()
+	if err != nil {
+		consumer.Warnf("Could not find launcher binary, not using Landlock: %s", err.Error())
+		return command
+	}
+
+	ensureSandboxParentDirs(args, seen, landlockLauncherPath)
+	*args = append(*args, "--ro-bind", launcher, landlockLauncherPath)
+	*args = append(*args, "--setenv", landlockShimEnv, formatLandlockRules(landlockRulesFor(params, home)))
+	return append([]string{landlockLauncherPath, command[0]}, command...)
+}
+
+// firejailLandlockOptions returns the firejail profile options that
+// enforce rules. firejail applies Landlock itself (0.9.74 or later).
+func firejailLandlockOptions(rules landlockRules) []string {
+	options := []string{"landlock.enforce"}
+	for _, path := range rules.ReadExec {
+		options = append(options, "landlock.fs.read "+path, "landlock.fs.execute "+path)
+	}
+	for _, path := range rules.ReadWrite {
+		options = append(options,
+			"landlock.fs.read "+path,
+			"landlock.fs.write "+path,
+			"landlock.fs.execute "+path,
+			"landlock.fs.makeipc "+path,
+		)
+	}
+	return options
+}
+
+type landlockRunner struct {
+	params RunnerParams
+}
+
+var _ Runner = (*landlockRunner)(nil)
+var _ Starter = (*landlockRunner)(nil)
+
+func newLandlockRunner(params RunnerParams) (Runner, error) {
+	if params.InstallFolder == "" {
+		return nil, fmt.Errorf("InstallFolder must be set for the landlock sandbox")
+	}
+
+	lr := &landlockRunner{
+		params: params,
+	}
+	return lr, nil
+}
+
+func (lr *landlockRunner) Prepare() error {
+	return nil
+}
+
+// Start runs the game like the simple runner does, but restricted by
+// Landlock, with a per-game home directory, since the user's home isn't
+// writable.
+func (lr *landlockRunner) Start() (*Handle, error) {
+	params := lr.params
+	consumer := params.Consumer
+
+	// Landlock may be skipped when stacked on another sandbox, but the game
+	// would run unrestricted here.
+	if landlockABI() == 0 {
+		return nil, fmt.Errorf("Landlock is not supported by this kernel")
+	}
+	consumer.Opf("Running (%s) with Landlock", params.FullTargetPath)
+	if params.SandboxConfig.Seccomp != SeccompPresetNone || len(params.SandboxConfig.SeccompDeny) > 0 {
+		consumer.Warnf("Seccomp filtering isn't supported by the landlock sandbox, ignoring it")
+	}
+
+	home := filepath.Join(params.InstallFolder, ".itch", "home")
+	err := os.MkdirAll(home, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	var env []string
+	for _, kv := range launchSandboxPolicy(params).Env.environ(params.Env, os.Environ()) {
+		if !strings.HasPrefix(kv, "HOME=") && !strings.HasPrefix(kv, "XAUTHORITY=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, "HOME="+home)
+	// X11 libraries look in $HOME otherwise.
+	if xauthority := landlockXauthority(params.Env); xauthority != "" {
+		env = append(env, "XAUTHORITY="+xauthority)
+	}
+
+	cmd := exec.Command(params.FullTargetPath, params.Args...)
+	cmd.Dir = params.Dir
+	cmd.Env = env
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+	wrapWithLandlockShim(consumer, cmd, landlockRulesFor(params, home))
+	applyResourceLimits(params, cmd)
+
+	return startCommand(params, cmd, BackendLandlock)
+}
+
+func (lr *landlockRunner) Run() (*ExitResult, error) {
+	h, err := lr.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h.Wait()
+}
@@ -0,0 +1,170 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+func requireLandlock(t *testing.T) {
+	t.Helper()
+	if landlockABI() == 0 {
+		t.Skip("Landlock is not supported")
+	}
+}
+
+func TestLandlockRulesFor(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	params.TempDir = t.TempDir()
+
+	rules := landlockRulesFor(params, "/home/player")
+	assert.Contains(t, rules.ReadExec, "/usr")
+	assert.Contains(t, rules.ReadExec, "/etc")
+	assert.Contains(t, rules.ReadWrite, "/dev")
+	assert.Contains(t, rules.ReadWrite, params.InstallFolder)
+	assert.Contains(t, rules.ReadWrite, params.TempDir)
+	assert.Contains(t, rules.ReadWrite, "/home/player")
+	assert.Contains(t, rules.ReadWrite, "/tmp")
+
+	params.Env = append(params.Env, "TMPDIR=/var/tmp/game")
+	rules = landlockRulesFor(params, "/home/player")
+	assert.Contains(t, rules.ReadWrite, "/var/tmp/game")
+}
+
+func TestLandlockHandledAccess(t *testing.T) {
+	newer := uint64(unix.LANDLOCK_ACCESS_FS_REFER | unix.LANDLOCK_ACCESS_FS_TRUNCATE | unix.LANDLOCK_ACCESS_FS_IOCTL_DEV)
+	assert.Zero(t, landlockHandledAccess(1)&newer)
+	assert.Equal(t, uint64(unix.LANDLOCK_ACCESS_FS_REFER|unix.LANDLOCK_ACCESS_FS_TRUNCATE), landlockHandledAccess(3)&newer)
+	assert.Equal(t, newer, landlockHandledAccess(5)&newer)
+	// ABI 4 and 6 are about networking and scoping, not the filesystem.
+	assert.Equal(t, landlockHandledAccess(5), landlockHandledAccess(7))
+}
+
+func TestLandlockRunner(t *testing.T) {
+	requireLandlock(t)
+
+	// Not in the temp dir, which is writable.
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	outside, err := os.MkdirTemp(wd, ".landlock-test-")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(outside) })
+
+	params, stdout := newNativeTestParams(t, strings.Join([]string{
+		"echo installed > ./install.txt && echo install=ok",
+		"echo saved > $HOME/save.txt && echo home=ok",
+		"(echo nope > " + filepath.Join(outside, "nope.txt") + ") 2>/dev/null || echo outside=denied",
+		"test -r /etc/passwd && cat /etc/passwd > /dev/null && echo etc=ok",
+		"echo nofile=$(ulimit -n)",
+		"mktemp -p /tmp >/dev/null && echo tmp=ok",
+		"test -z \"$SMAUG_TEST_SECRET\" && echo env=cleared",
+	}, "; "))
+	params.Dir = params.InstallFolder
+	// Goes through the rlimit shim first, then the Landlock one.
+	params.ResourceLimits.OpenFiles = 256
+	params.Env = []string{"PATH=/usr/bin:/bin", "HOME=/nonexistent", "SMAUG_TEST_SECRET=1"}
+
+	r, err := newLandlockRunner(params)
+	require.NoError(t, err)
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, BackendLandlock, res.Backend)
+
+	out := stdout.String()
+	assert.Contains(t, out, "install=ok\n")
+	assert.Contains(t, out, "home=ok\n")
+	assert.Contains(t, out, "outside=denied\n")
+	assert.Contains(t, out, "etc=ok\n")
+	assert.Contains(t, out, "nofile=256\n")
+	assert.Contains(t, out, "tmp=ok\n")
+	assert.Contains(t, out, "env=cleared\n")
+	assert.NoFileExists(t, filepath.Join(outside, "nope.txt"))
+	assert.FileExists(t, filepath.Join(params.InstallFolder, ".itch", "home", "save.txt"))
+}
+
+func TestLandlockRunnerNeedsLandlock(t *testing.T) {
+	original := landlockABI
+	landlockABI = func() int { return 0 }
+	t.Cleanup(func() { landlockABI = original })
+
+	params, _ := newNativeTestParams(t, "true")
+	r, err := newLandlockRunner(params)
+	require.NoError(t, err)
+	_, err = r.Run()
+	assert.ErrorContains(t, err, "Landlock is not supported")
+}
+
+func TestLandlockRunnerNeedsInstallFolder(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	params.InstallFolder = ""
+	_, err := newLandlockRunner(params)
+	assert.Error(t, err)
+}
+
+func TestBubblewrapLandlockRunsThroughShim(t *testing.T) {
+	requireLandlock(t)
+
+	params, _ := newNativeTestParams(t, "true")
+	params.SandboxConfig.Landlock = true
+
+	args := bubblewrapArgs(params)
+	launcher, err := os.Executable()
+	require.NoError(t, err)
+	assert.Contains(t, strings.Join(args, "\x00"), strings.Join([]string{"--ro-bind", launcher, landlockLauncherPath}, "\x00"))
+	assert.Len(t, bubblewrapSetenvValues(args, landlockShimEnv), 1)
+
+	spec, err := parseNativeSandboxArgs(args)
+	require.NoError(t, err)
+	assert.Equal(t, []string{landlockLauncherPath, "/bin/sh", "/bin/sh", "-c", "true"}, spec.command)
+}
+
+func TestFirejailLandlockProfileOptions(t *testing.T) {
+	requireLandlock(t)
+
+	origCommand := firejailCommand
+	t.Cleanup(func() {
+		firejailCommand = origCommand
+	})
+	firejailCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "true")
+	}
+
+	fr := newFirejailTestRunner(t, false)
+	fr.params.SandboxConfig.Landlock = true
+	_, err := fr.Run()
+	require.NoError(t, err)
+
+	profile, err := os.ReadFile(filepath.Join(fr.params.InstallFolder, ".itch", "isolate-app.profile"))
+	require.NoError(t, err)
+	lines := strings.Split(string(profile), "\n")
+	assert.Contains(t, lines, "landlock.enforce")
+	assert.Contains(t, lines, "landlock.fs.read /usr")
+	assert.Contains(t, lines, "landlock.fs.write "+fr.params.InstallFolder)
+	assert.NotContains(t, lines, "landlock.fs.write /usr")
+}
+
+func TestNativeSandboxWithLandlock(t *testing.T) {
+	requireUserNamespaces(t)
+	requireLandlock(t)
+
+	params, stdout := newNativeTestParams(t, strings.Join([]string{
+		"echo saved > $HOME/save.txt && echo home=ok",
+		// /tmp is private to the sandbox, and writable.
+		"touch /tmp/ok && echo tmp=ok",
+		"touch /usr/nope 2>/dev/null || echo usr=denied",
+	}, "; "))
+	params.SandboxConfig.Landlock = true
+
+	res, err := runNative(t, params)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "home=ok\ntmp=ok\nusr=denied\n", stdout.String())
+}
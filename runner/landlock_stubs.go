@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newLandlockRunner(params RunnerParams) (Runner, error) {
+	return nil, fmt.Errorf("landlock runner is not implemented on %s", runtime.GOOS)
+}
@@ -0,0 +1,521 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// nativeSandboxBase is where the sandbox's root is assembled. Being in a
+// mount namespace of our own, anything works, as long as it exists.
+const nativeSandboxBase = "/tmp"
+
+// nativeSandboxDevices are bind-mounted from the host into --dev mounts.
+var nativeSandboxDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+// nativeSandboxDevLinks are symlinks created in --dev mounts.
+var nativeSandboxDevLinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+	"stderr": "/proc/self/fd/2",
+	"ptmx":   "pts/ptmx",
+}
+
+// nativeSandboxIgnoredSignals reach the game through its process group, and
+// must not kill the sandbox's init process before the game is done.
+var nativeSandboxIgnoredSignals = []os.Signal{
+	syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM,
+	syscall.SIGUSR1, syscall.SIGUSR2, syscall.SIGPIPE,
+}
+
+func init() {
+	if _, ok := os.LookupEnv(nativeSandboxEnv); !ok {
+		return
+	}
+	if _, ok := os.LookupEnv(rlimitShimEnv); ok {
+		// The rlimit shim runs first, and re-executes the launcher without
+		// rlimitShimEnv once the limits are set.
+		return
+	}
+	os.Exit(runNativeSandbox(os.Args[1:]))
+}
+
+// runNativeSandbox runs in new user, mount, and usually PID and UTS
+// namespaces. It assembles the sandbox's filesystem from args (bwrap
+// options, see bubblewrapArgs), pivots into it, drops privileges, then runs
+// the game and reaps processes until it exits. It returns the game's exit
+// code, or 128+signal if it was killed, like bwrap does.
+func runNativeSandbox(args []string) int {
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "smaug: native sandbox: %s\n", err.Error())
+		return 127
+	}
+
+	// Capabilities and no_new_privs are per-thread, and the game is forked
+	// from this one.
+	runtime.LockOSThread()
+	os.Unsetenv(nativeSandboxEnv)
+
+	spec, err := parseNativeSandboxArgs(args)
+	if err != nil {
+		return fail(err)
+	}
+
+	dir := spec.chdir
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+
+	err = setupNativeSandboxRoot(spec.ops)
+	if err != nil {
+		return fail(err)
+	}
+	if spec.unshareNet {
+		err = bringUpLoopback()
+		if err != nil {
+			return fail(fmt.Errorf("bringing up loopback: %w", err))
+		}
+	}
+	err = dropPrivileges()
+	if err != nil {
+		return fail(fmt.Errorf("dropping privileges: %w", err))
+	}
+	if spec.seccompFd >= 0 {
+		err = installSeccompProgramFrom(spec.seccompFd)
+		if err != nil {
+			return fail(fmt.Errorf("installing seccomp filter: %w", err))
+		}
+	}
+
+	if err := os.Chdir(dir); err != nil {
+		if spec.chdir != "" {
+			return fail(fmt.Errorf("%w", err))
+		}
+		dir = "/"
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, nativeSandboxIgnoredSignals...)
+	go func() {
+		for range signals {
+		}
+	}()
+
+	env := spec.environ()
+	path, err := lookPathIn(spec.command[0], envLookup(env, "PATH"))
+	if err != nil {
+		return fail(err)
+	}
+	proc, err := os.StartProcess(path, spec.command, &os.ProcAttr{
+		Dir:   dir,
+		Env:   env,
+		Files: []*os.File{os.Stdin, os.Stdout, os.Stderr},
+	})
+	if err != nil {
+		return fail(fmt.Errorf("%w", err))
+	}
+
+	for {
+		var ws syscall.WaitStatus
+		pid, err := syscall.Wait4(-1, &ws, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return fail(fmt.Errorf("waiting for game: %w", err))
+		}
+		if pid != proc.Pid {
+			// An orphan reparented to us, as the namespace's init.
+			continue
+		}
+		if ws.Signaled() {
+			return 128 + int(ws.Signal())
+		}
+		return ws.ExitStatus()
+	}
+}
+
+// setupNativeSandboxRoot builds the sandbox's filesystem and makes it the
+// root. As bwrap does, it first pivots into a tmpfs, so that the host's
+// filesystem is at /oldroot while ops are applied to /newroot, then pivots
+// into /newroot and detaches the host's filesystem.
+func setupNativeSandboxRoot(ops []nativeSandboxOp) error {
+	err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, "")
+	if err != nil {
+		return fmt.Errorf("making mounts private: %w", err)
+	}
+	err = unix.Mount("tmpfs", nativeSandboxBase, "tmpfs", unix.MS_NODEV|unix.MS_NOSUID, "mode=0755")
+	if err != nil {
+		return fmt.Errorf("mounting tmpfs: %w", err)
+	}
+	for _, name := range []string{"newroot", "oldroot"} {
+		err = os.Mkdir(filepath.Join(nativeSandboxBase, name), 0o755)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+	err = unix.PivotRoot(nativeSandboxBase, filepath.Join(nativeSandboxBase, "oldroot"))
+	if err != nil {
+		return fmt.Errorf("pivoting to tmpfs: %w", err)
+	}
+	err = os.Chdir("/")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	err = unix.Mount("/newroot", "/newroot", "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("mounting new root: %w", err)
+	}
+
+	for _, op := range ops {
+		err = applyNativeSandboxOp(op)
+		if err != nil {
+			return fmt.Errorf("--%s %s: %w", op.kind, op.target, err)
+		}
+	}
+
+	err = unix.Unmount("/oldroot", unix.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("detaching host filesystem: %w", err)
+	}
+	err = os.Chdir("/newroot")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	// Stacks the old root on top of the new one, to detach it right after.
+	err = unix.PivotRoot(".", ".")
+	if err != nil {
+		return fmt.Errorf("pivoting to new root: %w", err)
+	}
+	err = unix.Unmount(".", unix.MNT_DETACH)
+	if err != nil {
+		return fmt.Errorf("detaching tmpfs: %w", err)
+	}
+	return os.Chdir("/")
+}
+
+func applyNativeSandboxOp(op nativeSandboxOp) error {
+	target := filepath.Join("/newroot", op.target)
+	source := filepath.Join("/oldroot", op.source)
+
+	switch op.kind {
+	case "ro-bind", "bind", "dev-bind":
+		err := bindMount(source, target)
+		if err != nil {
+			return err
+		}
+		switch op.kind {
+		case "ro-bind":
+			return remountTree(target, unix.MS_NODEV|unix.MS_RDONLY)
+		case "bind":
+			return remountTree(target, unix.MS_NODEV)
+		}
+		return nil
+	case "proc":
+		err := os.MkdirAll(target, 0o755)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		return mountFS("proc", target, unix.MS_NOSUID|unix.MS_NODEV|unix.MS_NOEXEC, "")
+	case "tmpfs":
+		err := os.MkdirAll(target, 0o755)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+		return mountFS("tmpfs", target, unix.MS_NOSUID|unix.MS_NODEV, "mode=0755")
+	case "dev":
+		return mountDev(target)
+	case "dir":
+		return os.MkdirAll(target, 0o755)
+	}
+	return fmt.Errorf("unsupported operation")
+}
+
+// bindMount bind-mounts source on target, creating target as a directory
+// or an empty file, depending on what source is.
+func bindMount(source, target string) error {
+	info, err := os.Stat(source)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if info.IsDir() {
+		err = os.MkdirAll(target, 0o755)
+	} else {
+		err = createMountPointFile(target)
+	}
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	err = unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, "")
+	if err != nil {
+		return fmt.Errorf("bind-mounting: %w", err)
+	}
+	return nil
+}
+
+func createMountPointFile(path string) error {
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE, 0o644)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return f.Close()
+}
+
+// remountTree adds flags to a recursive bind mount and to every mount
+// below it, which a remount of target alone leaves as they are.
+func remountTree(target string, flags uintptr) error {
+	err := remount(target, flags)
+	if err != nil {
+		return err
+	}
+
+	// The host's /proc is the only one mounted yet.
+	f, err := os.Open(filepath.Join("/oldroot", procSelfMountinfoPath))
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	mountPoints, err := submountsOf(f, target)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for _, mountPoint := range mountPoints {
+		err = remount(mountPoint, flags)
+		// Like bwrap, skip what can't be reached: it can't be written to
+		// either.
+		if errors.Is(err, unix.EACCES) || errors.Is(err, unix.ENOENT) {
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", mountPoint, err)
+		}
+	}
+	return nil
+}
+
+// submountsOf returns the mount points strictly below target listed in
+// mountinfo, in mount order.
+func submountsOf(mountinfo io.Reader, target string) ([]string, error) {
+	var mountPoints []string
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(mountinfo)
+	for scanner.Scan() {
+		// 36 35 98:0 /mnt1 /mnt2 rw,noatime master:1 - ext3 /dev/root rw
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		mountPoint := unescapeMountinfo(fields[4])
+		if mountPoint == target || !isPathWithin(mountPoint, target) || seen[mountPoint] {
+			continue
+		}
+		seen[mountPoint] = true
+		mountPoints = append(mountPoints, mountPoint)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return mountPoints, nil
+}
+
+// unescapeMountinfo decodes the octal escapes (\040 for a space...) of a
+// mountinfo path.
+func unescapeMountinfo(path string) string {
+	if !strings.Contains(path, `\`) {
+		return path
+	}
+	var b strings.Builder
+	for i := 0; i < len(path); i++ {
+		if path[i] == '\\' && i+3 < len(path) {
+			if n, err := strconv.ParseUint(path[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(n))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(path[i])
+	}
+	return b.String()
+}
+
+// remount adds flags to a bind mount. The flags it already has must be
+// kept: in a user namespace, clearing them is not allowed.
+func remount(target string, flags uintptr) error {
+	var st unix.Statfs_t
+	err := unix.Statfs(target, &st)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	for stFlag, msFlag := range map[int64]uintptr{
+		unix.ST_RDONLY:      unix.MS_RDONLY,
+		unix.ST_NOSUID:      unix.MS_NOSUID,
+		unix.ST_NODEV:       unix.MS_NODEV,
+		unix.ST_NOEXEC:      unix.MS_NOEXEC,
+		unix.ST_NOATIME:     unix.MS_NOATIME,
+		unix.ST_NODIRATIME:  unix.MS_NODIRATIME,
+		unix.ST_RELATIME:    unix.MS_RELATIME,
+		unix.ST_SYNCHRONOUS: unix.MS_SYNCHRONOUS,
+	} {
+		if int64(st.Flags)&stFlag != 0 {
+			flags |= msFlag
+		}
+	}
+
+	err = unix.Mount("", target, "", unix.MS_BIND|unix.MS_REMOUNT|flags, "")
+	if err != nil {
+		return fmt.Errorf("remounting: %w", err)
+	}
+	return nil
+}
+
+func mountFS(fstype, target string, flags uintptr, data string) error {
+	err := unix.Mount(fstype, target, fstype, flags, data)
+	if err != nil {
+		return fmt.Errorf("mounting %s: %w", fstype, err)
+	}
+	return nil
+}
+
+// mountDev sets up a minimal /dev, like bwrap's --dev: a tmpfs with a few
+// host devices, a private devpts instance and the usual symlinks.
+func mountDev(target string) error {
+	err := os.MkdirAll(target, 0o755)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	err = mountFS("tmpfs", target, unix.MS_NOSUID, "mode=0755")
+	if err != nil {
+		return err
+	}
+
+	for _, name := range nativeSandboxDevices {
+		err = bindMount(filepath.Join("/oldroot/dev", name), filepath.Join(target, name))
+		if err != nil {
+			return fmt.Errorf("%s: %w", name, err)
+		}
+	}
+	for name, dest := range nativeSandboxDevLinks {
+		err = os.Symlink(dest, filepath.Join(target, name))
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	err = os.Mkdir(filepath.Join(target, "shm"), 0o1777)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	pts := filepath.Join(target, "pts")
+	err = os.Mkdir(pts, 0o755)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return mountFS("devpts", pts, unix.MS_NOSUID|unix.MS_NOEXEC, "newinstance,ptmxmode=0666,mode=620")
+}
+
+// bringUpLoopback brings lo up in a new network namespace, where it starts
+// out down.
+func bringUpLoopback() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer unix.Close(fd)
+
+	ifr, err := unix.NewIfreq("lo")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	err = unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifr)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	ifr.SetUint16(ifr.Uint16() | unix.IFF_UP)
+	err = unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifr)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// dropPrivileges gives up every capability held in the user namespace, and
+// any way of gaining them back, before running the game.
+func dropPrivileges() error {
+	for c := 0; ; c++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(c), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			// Past the last capability the kernel knows about.
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("dropping capability %d from bounding set: %w", c, err)
+		}
+	}
+
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	var data [2]unix.CapUserData
+	err := unix.Capset(&header, &data[0])
+	if err != nil {
+		return fmt.Errorf("clearing capabilities: %w", err)
+	}
+
+	err = unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0)
+	if err != nil {
+		return fmt.Errorf("setting no_new_privs: %w", err)
+	}
+	return nil
+}
+
+// lookPathIn finds file in pathEnv like execvp does, as bwrap runs its
+// command with it. exec.LookPath would search the launcher's PATH instead.
+func lookPathIn(file string, pathEnv string) (string, error) {
+	if strings.Contains(file, "/") {
+		return file, nil
+	}
+	for dir := range strings.SplitSeq(pathEnv, ":") {
+		if dir == "" {
+			dir = "."
+		}
+		path := filepath.Join(dir, file)
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() && info.Mode()&0o111 != 0 {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("%s: not found in PATH", file)
+}
+
+// installSeccompProgramFrom reads a seccomp program from fd, which it
+// closes, and installs it.
+func installSeccompProgramFrom(fd int) error {
+	f := os.NewFile(uintptr(fd), "seccomp")
+	data, err := io.ReadAll(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	prog, err := unmarshalSeccompProgram(data)
+	if err != nil {
+		return err
+	}
+	return installSeccompProgram(prog)
+}
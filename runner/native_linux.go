@@ -0,0 +1,236 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"syscall"
+)
+
+// nativeSandboxEnv is set on a re-executed launcher to make it set up a
+// sandbox from bubblewrap options and run the game in it, instead of
+// running normally.
+const nativeSandboxEnv = "SMAUG_NATIVE_SANDBOX"
+
+type nativeRunner struct {
+	params RunnerParams
+}
+
+var _ Runner = (*nativeRunner)(nil)
+var _ Starter = (*nativeRunner)(nil)
+
+func newNativeRunner(params RunnerParams) (Runner, error) {
+	nr := &nativeRunner{
+		params: params,
+	}
+	return nr, nil
+}
+
+func (nr *nativeRunner) Prepare() error {
+	return nil
+}
+
+// Start sets up the same sandbox as the bubblewrap runner, without bwrap.
+// The launcher binary is re-executed in new namespaces, and implements the
+// options bwrap would have been given (see runNativeSandbox).
+func (nr *nativeRunner) Start() (*Handle, error) {
+	params := nr.params
+	consumer := params.Consumer
+
+	msg := fmt.Sprintf("Running (%s) in a native sandbox", params.FullTargetPath)
+	if launchSandboxPolicy(params).Network == NetworkNone {
+		msg += " (networking disabled)"
+	}
+	consumer.Opf("%s", msg)
+
+	args := bubblewrapArgs(params)
+
+	seccompFile, err := seccompProgramFile(params)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if seccompFile != nil {
+		// The helper has read it by the time the game runs.
+		defer seccompFile.Close()
+		args = append([]string{"--seccomp", "3"}, args...)
+	}
+
+	spec, err := parseNativeSandboxArgs(args)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	cmd := exec.Command(launcherPath)
+	cmd.Args = append([]string{"smaug-sandbox"}, args...)
+	cmd.Dir = params.Dir
+	cmd.Env = []string{nativeSandboxEnv + "=1"}
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+	if seccompFile != nil {
+		// Becomes fd 3.
+		cmd.ExtraFiles = []*os.File{seccompFile}
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags: spec.cloneflags(),
+		// Map the current user to itself, like bwrap does.
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+		Setsid:      spec.newSession,
+	}
+	if spec.dieWithParent {
+		cmd.SysProcAttr.Pdeathsig = syscall.SIGKILL
+	}
+	applyResourceLimits(params, cmd)
+
+	return startCommand(params, cmd, BackendNative)
+}
+
+func (nr *nativeRunner) Run() (*ExitResult, error) {
+	h, err := nr.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h.Wait()
+}
+
+// nativeSandboxOp is a filesystem operation, named after the bwrap option
+// it implements, e.g. "ro-bind".
+type nativeSandboxOp struct {
+	kind   string
+	source string
+	target string
+}
+
+// nativeSandboxSpec is what the native sandbox implements of a bwrap
+// command line.
+type nativeSandboxSpec struct {
+	ops []nativeSandboxOp
+
+	unsharePid    bool
+	unshareUTS    bool
+	unshareNet    bool
+	unshareIPC    bool
+	dieWithParent bool
+	newSession    bool
+	clearEnv      bool
+
+	// File descriptor to read a seccomp program from, or -1.
+	seccompFd int
+
+	// From --setenv, as "KEY=value".
+	env     []string
+	chdir   string
+	command []string
+}
+
+// parseNativeSandboxArgs parses the options bubblewrapArgs produces. Others
+// are rejected rather than silently ignored, so the native sandbox can't be
+// weaker than bwrap's.
+func parseNativeSandboxArgs(args []string) (*nativeSandboxSpec, error) {
+	spec := &nativeSandboxSpec{seccompFd: -1}
+
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		need := func(n int) ([]string, error) {
+			if i+n >= len(args) {
+				return nil, fmt.Errorf("%s needs %d arguments", arg, n)
+			}
+			values := args[i+1 : i+1+n]
+			i += n
+			return values, nil
+		}
+
+		switch arg {
+		case "--ro-bind", "--bind", "--dev-bind":
+			values, err := need(2)
+			if err != nil {
+				return nil, err
+			}
+			spec.ops = append(spec.ops, nativeSandboxOp{kind: arg[2:], source: values[0], target: values[1]})
+		case "--proc", "--dev", "--tmpfs", "--dir":
+			values, err := need(1)
+			if err != nil {
+				return nil, err
+			}
+			spec.ops = append(spec.ops, nativeSandboxOp{kind: arg[2:], target: values[0]})
+		case "--setenv":
+			values, err := need(2)
+			if err != nil {
+				return nil, err
+			}
+			spec.env = append(spec.env, values[0]+"="+values[1])
+		case "--chdir":
+			values, err := need(1)
+			if err != nil {
+				return nil, err
+			}
+			spec.chdir = values[0]
+		case "--seccomp":
+			values, err := need(1)
+			if err != nil {
+				return nil, err
+			}
+			fd, err := strconv.Atoi(values[0])
+			if err != nil || fd < 0 {
+				return nil, fmt.Errorf("invalid file descriptor %q for --seccomp", values[0])
+			}
+			spec.seccompFd = fd
+		case "--unshare-user":
+			// Always done, mounting needs it.
+		case "--unshare-pid":
+			spec.unsharePid = true
+		case "--unshare-uts":
+			spec.unshareUTS = true
+		case "--unshare-net":
+			spec.unshareNet = true
+		case "--unshare-ipc":
+			spec.unshareIPC = true
+		case "--die-with-parent":
+			spec.dieWithParent = true
+		case "--new-session":
+			spec.newSession = true
+		case "--clearenv":
+			spec.clearEnv = true
+		case "--":
+			spec.command = args[i+1:]
+			if len(spec.command) == 0 {
+				return nil, fmt.Errorf("no command to run")
+			}
+			return spec, nil
+		default:
+			return nil, fmt.Errorf("unsupported sandbox option %q", arg)
+		}
+	}
+
+	return nil, fmt.Errorf("no command to run")
+}
+
+func (spec *nativeSandboxSpec) cloneflags() uintptr {
+	flags := uintptr(syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS)
+	if spec.unsharePid {
+		flags |= syscall.CLONE_NEWPID
+	}
+	if spec.unshareUTS {
+		flags |= syscall.CLONE_NEWUTS
+	}
+	if spec.unshareNet {
+		flags |= syscall.CLONE_NEWNET
+	}
+	if spec.unshareIPC {
+		flags |= syscall.CLONE_NEWIPC
+	}
+	return flags
+}
+
+// environ returns the environment of the sandboxed game.
+func (spec *nativeSandboxSpec) environ() []string {
+	var env []string
+	if !spec.clearEnv {
+		env = os.Environ()
+	}
+	return append(env, spec.env...)
+}
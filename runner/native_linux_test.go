@@ -0,0 +1,223 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func requireUserNamespaces(t *testing.T) {
+	t.Helper()
+	cmd := exec.Command("/bin/true")
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+		UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+		GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+	}
+	if err := cmd.Run(); err != nil {
+		t.Skipf("user namespaces are not available: %s", err.Error())
+	}
+}
+
+func newNativeTestParams(t *testing.T, script string) (RunnerParams, *bytes.Buffer) {
+	t.Helper()
+	var stdout bytes.Buffer
+	params := RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx:            context.Background(),
+		InstallFolder:  t.TempDir(),
+		Env:            []string{"HOME=/home/player", "PATH=/usr/bin:/bin", "SMAUG_TEST_SECRET=1"},
+		FullTargetPath: "/bin/sh",
+		Args:           []string{"-c", script},
+		Stdout:         &stdout,
+		Stderr:         os.Stderr,
+	}
+	return params, &stdout
+}
+
+func runNative(t *testing.T, params RunnerParams) (*ExitResult, error) {
+	t.Helper()
+	r, err := newNativeRunner(params)
+	require.NoError(t, err)
+	return r.Run()
+}
+
+func TestParseNativeSandboxArgs(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	params.Dir = params.InstallFolder
+	params.SandboxConfig.NoNetwork = true
+
+	spec, err := parseNativeSandboxArgs(bubblewrapArgs(params))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/bin/sh", "-c", "true"}, spec.command)
+	assert.Equal(t, params.InstallFolder, spec.chdir)
+	assert.True(t, spec.clearEnv)
+	assert.True(t, spec.unsharePid)
+	assert.True(t, spec.unshareUTS)
+	assert.True(t, spec.unshareNet)
+	assert.True(t, spec.dieWithParent)
+	assert.True(t, spec.newSession)
+	assert.Contains(t, spec.env, "HOME=/home/player")
+	assert.Contains(t, spec.ops, nativeSandboxOp{kind: "proc", target: "/proc"})
+	assert.Contains(t, spec.ops, nativeSandboxOp{kind: "ro-bind", source: "/usr", target: "/usr"})
+	assert.Contains(t, spec.ops, nativeSandboxOp{
+		kind:   "bind",
+		source: filepath.Join(params.InstallFolder, ".itch", "home"),
+		target: "/home/player",
+	})
+}
+
+func TestParseNativeSandboxArgsRejectsUnknownOptions(t *testing.T) {
+	for _, args := range [][]string{
+		{"--cap-add", "ALL", "--", "/bin/true"},
+		{"--seccomp", "three", "--", "/bin/true"},
+		{"--ro-bind", "/usr"},
+		{"--unshare-pid"},
+		{"--unshare-pid", "--"},
+	} {
+		_, err := parseNativeSandboxArgs(args)
+		assert.Error(t, err, "args %q", args)
+	}
+}
+
+func TestNativeSandbox(t *testing.T) {
+	requireUserNamespaces(t)
+
+	hostFile := filepath.Join(os.TempDir(), "smaug-native-test-host-file")
+	require.NoError(t, os.WriteFile(hostFile, nil, 0o644))
+	t.Cleanup(func() { os.Remove(hostFile) })
+
+	params, stdout := newNativeTestParams(t, strings.Join([]string{
+		"echo init=$(tr '\\0' ' ' < /proc/1/cmdline | cut -d' ' -f1)",
+		"echo home=$HOME",
+		"echo saved > $HOME/save.txt",
+		"touch /usr/smaug-native-test 2>/dev/null || echo usr=readonly",
+		"test -e " + hostFile + " || echo tmp=private",
+		"test -z \"$SMAUG_TEST_SECRET\" && echo env=cleared",
+		"exit 7",
+	}, "; "))
+	res, err := runNative(t, params)
+	require.Error(t, err)
+	require.NotNil(t, res)
+
+	// The game's exit code goes through the helper.
+	assert.Equal(t, 7, res.ExitCode)
+	assert.Equal(t, BackendNative, res.Backend)
+	out := stdout.String()
+	// The helper is the PID namespace's init.
+	assert.Contains(t, out, "init=smaug-sandbox\n")
+	assert.Contains(t, out, "home=/home/player\n")
+	assert.Contains(t, out, "usr=readonly\n")
+	assert.Contains(t, out, "tmp=private\n")
+	assert.Contains(t, out, "env=cleared\n")
+
+	saved, err := os.ReadFile(filepath.Join(params.InstallFolder, ".itch", "home", "save.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "saved\n", string(saved))
+}
+
+func TestNativeSandboxReportsSignal(t *testing.T) {
+	requireUserNamespaces(t)
+
+	params, _ := newNativeTestParams(t, "kill -SEGV $$")
+	res, err := runNative(t, params)
+	require.Error(t, err)
+	require.NotNil(t, res)
+
+	// The helper exits with 128+N, which is decoded back.
+	assert.Equal(t, -1, res.ExitCode)
+	assert.Equal(t, syscall.SIGSEGV, res.Signal)
+	assert.Contains(t, res.String(), "killed by signal SIGSEGV")
+}
+
+func TestNativeSandboxNoNetwork(t *testing.T) {
+	requireUserNamespaces(t)
+
+	params, stdout := newNativeTestParams(t, "cut -d: -f1 /proc/net/dev | tail -n +3 | tr -d ' '")
+	params.SandboxConfig.NoNetwork = true
+	res, err := runNative(t, params)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "lo\n", stdout.String())
+}
+
+func TestNativeSandboxCancel(t *testing.T) {
+	requireUserNamespaces(t)
+
+	params, _ := newNativeTestParams(t, "sleep 60")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params.Ctx = ctx
+
+	r, err := newNativeRunner(params)
+	require.NoError(t, err)
+	h, err := r.(Starter).Start()
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-h.Done():
+	case <-time.After(10 * time.Second):
+		h.Kill()
+		t.Fatal("Wait() did not return within 10 seconds")
+	}
+	res, _ := h.Wait()
+	require.NotNil(t, res)
+	assert.True(t, res.Cancelled)
+}
+
+func TestSubmountsOf(t *testing.T) {
+	mountinfo := strings.Join([]string{
+		"22 1 0:21 / / rw - tmpfs tmpfs rw",
+		"23 22 0:22 / /newroot/usr ro - ext4 /dev/sda1 ro",
+		"24 23 0:23 / /newroot/usr/share/my\\040games rw - ext4 /dev/sdb1 rw",
+		"25 22 0:24 / /newroot/usrlocal rw - tmpfs tmpfs rw",
+		"26 23 0:25 / /newroot/usr/lib rw - tmpfs tmpfs rw",
+		"27 26 0:26 / /newroot/usr/lib rw - tmpfs tmpfs rw",
+	}, "\n")
+	mountPoints, err := submountsOf(strings.NewReader(mountinfo), "/newroot/usr")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/newroot/usr/share/my games", "/newroot/usr/lib"}, mountPoints)
+}
+
+func TestNativeSandboxReadOnlySubmounts(t *testing.T) {
+	requireUserNamespaces(t)
+	if os.Geteuid() != 0 {
+		t.Skip("mounting a tmpfs needs root")
+	}
+
+	params, stdout := newNativeTestParams(t, "touch /data/sub/file 2>/dev/null || echo readonly")
+	data := filepath.Join(params.InstallFolder, "data")
+	sub := filepath.Join(data, "sub")
+	require.NoError(t, os.MkdirAll(sub, 0o755))
+	require.NoError(t, syscall.Mount("tmpfs", sub, "tmpfs", 0, ""))
+	t.Cleanup(func() { syscall.Unmount(sub, syscall.MNT_DETACH) })
+
+	policy, err := DefaultSandboxPolicy(params)
+	require.NoError(t, err)
+	policy.Filesystem = append(policy.Filesystem, FilesystemRule{Path: "/data", Source: data, Access: FilesystemReadOnly})
+	params.SandboxConfig.Policy = policy
+
+	res, err := runNative(t, params)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "readonly\n", stdout.String())
+}
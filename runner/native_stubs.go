@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newNativeRunner(params RunnerParams) (Runner, error) {
+	return nil, fmt.Errorf("native sandbox runner is not implemented on %s", runtime.GOOS)
+}
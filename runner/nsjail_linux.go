@@ -0,0 +1,299 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+var nsjailCommand = exec.Command
+
+// nsjailRlimits are the rlimit fields of nsjail's config, by rlimit name,
+// and the size of their unit in bytes, or 1 for counts and seconds. nsjail
+// applies low limits by default (e.g. a 1 MiB file size and 600 seconds of
+// CPU time), so those smaug doesn't set are explicitly inherited instead.
+var nsjailRlimits = []struct {
+	name  string
+	field string
+	unit  uint64
+}{
+	{"as", "rlimit_as", 1 << 20},
+	{"core", "rlimit_core", 1 << 20},
+	{"cpu", "rlimit_cpu", 1},
+	{"fsize", "rlimit_fsize", 1 << 20},
+	{"nofile", "rlimit_nofile", 1},
+	{"nproc", "rlimit_nproc", 1},
+	{"stack", "rlimit_stack", 1 << 20},
+}
+
+// nsjailDevices are bound into the /dev tmpfs, like bwrap --dev does.
+var nsjailDevices = []string{"null", "zero", "full", "random", "urandom", "tty"}
+
+type nsjailRunner struct {
+	params RunnerParams
+}
+
+var _ Runner = (*nsjailRunner)(nil)
+var _ Starter = (*nsjailRunner)(nil)
+
+func newNsjailRunner(params RunnerParams) (Runner, error) {
+	if params.NsjailParams.BinaryPath == "" {
+		return nil, fmt.Errorf("NsjailParams.BinaryPath must be set")
+	}
+	if params.InstallFolder == "" {
+		return nil, fmt.Errorf("InstallFolder must be set for the nsjail sandbox")
+	}
+
+	nr := &nsjailRunner{
+		params: params,
+	}
+	return nr, nil
+}
+
+func (nr *nsjailRunner) Prepare() error {
+	return nil
+}
+
+// Start writes an nsjail config for the same sandbox as the bubblewrap
+// runner's to {InstallFolder}/.itch/nsjail/{launch name}.cfg, and runs
+// nsjail with it. The config is removed once the launch is over.
+func (nr *nsjailRunner) Start() (*Handle, error) {
+	params := nr.params
+	consumer := params.Consumer
+
+	sandbox, err := parseNativeSandboxArgs(bubblewrapArgs(params))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	seccompNames, err := seccompNativeDenyList(params.SandboxConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	name, err := newLaunchName()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// Each launch gets its own config, so concurrent launches don't
+	// overwrite each other's.
+	config := renderNsjailConfig(sandbox, launchRlimits(params), seccompNames, os.Getuid(), os.Getgid())
+	configPath := filepath.Join(params.InstallFolder, ".itch", "nsjail", name+".cfg")
+	consumer.Opf("Writing nsjail config to (%s)", configPath)
+	err = os.MkdirAll(filepath.Dir(configPath), 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	// The environment may hold secrets.
+	err = os.WriteFile(configPath, []byte(config), 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	msg := fmt.Sprintf("Running (%s) through nsjail", params.FullTargetPath)
+	if launchSandboxPolicy(params).Network == NetworkNone {
+		msg += " (networking disabled)"
+	}
+	consumer.Opf("%s", msg)
+
+	cmd := nsjailCommand(params.NsjailParams.BinaryPath, "--config", configPath)
+	cmd.Dir = params.Dir
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+
+	h, err := startCommand(params, cmd, BackendNsjail)
+	if err != nil {
+		os.Remove(configPath)
+		return nil, fmt.Errorf("%w", err)
+	}
+	go func() {
+		<-h.Done()
+		os.Remove(configPath)
+	}()
+	return h, nil
+}
+
+func (nr *nsjailRunner) Run() (*ExitResult, error) {
+	h, err := nr.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h.Wait()
+}
+
+// renderNsjailConfig translates a sandbox, as set up by bubblewrap, into an
+// nsjail config, in protobuf text format (see nsjail's config.proto). The
+// user is mapped to itself, like bwrap does. Syscalls in seccompNames are
+// denied with a Kafel policy.
+func renderNsjailConfig(sandbox *nativeSandboxSpec, limits []rlimit, seccompNames []string, uid, gid int) string {
+	var b strings.Builder
+	field := func(indent string, name string, value string) {
+		fmt.Fprintf(&b, "%s%s: %s\n", indent, name, value)
+	}
+	quoted := func(indent string, name string, value string) {
+		field(indent, name, nsjailQuote(value))
+	}
+	flag := func(indent string, name string, value bool) {
+		field(indent, name, strconv.FormatBool(value))
+	}
+
+	b.WriteString("# Generated by smaug, changes will be overwritten.\n\n")
+	quoted("", "name", "smaug")
+	field("", "mode", "ONCE")
+	field("", "log_level", "WARNING")
+	field("", "time_limit", "0")
+	flag("", "keep_env", false)
+	flag("", "keep_caps", false)
+	flag("", "skip_setsid", !sandbox.newSession)
+	flag("", "mount_proc", false)
+	if sandbox.chdir != "" {
+		quoted("", "cwd", sandbox.chdir)
+	}
+
+	b.WriteString("\n")
+	flag("", "clone_newuser", true)
+	flag("", "clone_newns", true)
+	flag("", "clone_newpid", sandbox.unsharePid)
+	flag("", "clone_newuts", sandbox.unshareUTS)
+	flag("", "clone_newnet", sandbox.unshareNet)
+	flag("", "clone_newipc", sandbox.unshareIPC)
+	flag("", "clone_newcgroup", false)
+	for _, mapping := range []struct {
+		name string
+		id   int
+	}{{"uidmap", uid}, {"gidmap", gid}} {
+		b.WriteString(mapping.name + " {\n")
+		quoted("\t", "inside_id", strconv.Itoa(mapping.id))
+		quoted("\t", "outside_id", strconv.Itoa(mapping.id))
+		field("\t", "count", "1")
+		b.WriteString("}\n")
+	}
+
+	b.WriteString("\n")
+	for _, r := range nsjailRlimits {
+		value, set := uint64(0), false
+		for _, l := range limits {
+			if l.name == r.name {
+				value, set = l.value, true
+			}
+		}
+		switch {
+		case !set:
+			field("", r.field+"_type", "SOFT")
+		case value == unix.RLIM_INFINITY:
+			field("", r.field+"_type", "INF")
+		default:
+			if value > 0 {
+				value = max(value/r.unit, 1)
+			}
+			field("", r.field+"_type", "VALUE")
+			field("", r.field, strconv.FormatUint(value, 10))
+		}
+	}
+
+	mount := func(fields func()) {
+		b.WriteString("\nmount {\n")
+		fields()
+		b.WriteString("}\n")
+	}
+	bind := func(source, target string, rw bool, nodev bool) {
+		mount(func() {
+			quoted("\t", "src", source)
+			quoted("\t", "dst", target)
+			flag("\t", "is_bind", true)
+			flag("\t", "rw", rw)
+			flag("\t", "nosuid", true)
+			flag("\t", "nodev", nodev)
+		})
+	}
+	fs := func(target, fstype, options string) {
+		mount(func() {
+			quoted("\t", "dst", target)
+			quoted("\t", "fstype", fstype)
+			flag("\t", "rw", true)
+			flag("\t", "nosuid", true)
+			if options != "" {
+				quoted("\t", "options", options)
+			}
+		})
+	}
+	for _, op := range sandbox.ops {
+		switch op.kind {
+		case "ro-bind":
+			bind(op.source, op.target, false, true)
+		case "bind":
+			bind(op.source, op.target, true, true)
+		case "dev-bind":
+			bind(op.source, op.target, true, false)
+		case "proc":
+			fs(op.target, "proc", "")
+		case "dev":
+			fs(op.target, "tmpfs", "mode=755")
+			for _, device := range nsjailDevices {
+				bind(filepath.Join("/dev", device), filepath.Join(op.target, device), true, false)
+			}
+			fs(filepath.Join(op.target, "pts"), "devpts", "newinstance,ptmxmode=0666,mode=620")
+			fs(filepath.Join(op.target, "shm"), "tmpfs", "mode=1777")
+		case "tmpfs":
+			fs(op.target, "tmpfs", "mode=755")
+		case "dir":
+			// nsjail creates mount points itself.
+		}
+	}
+
+	b.WriteString("\n")
+	for _, kv := range sandbox.env {
+		quoted("", "envar", kv)
+	}
+
+	if len(seccompNames) > 0 {
+		b.WriteString("\n")
+		quoted("", "seccomp_string", "POLICY smaug {")
+		quoted("", "seccomp_string", "  ERRNO(1) { "+strings.Join(seccompNames, ", ")+" }")
+		quoted("", "seccomp_string", "}")
+		quoted("", "seccomp_string", "USE smaug DEFAULT ALLOW")
+	}
+
+	b.WriteString("\nexec_bin {\n")
+	quoted("\t", "path", sandbox.command[0])
+	for _, arg := range sandbox.command[1:] {
+		quoted("\t", "arg", arg)
+	}
+	b.WriteString("}\n")
+
+	return b.String()
+}
+
+// nsjailQuote quotes s as a protobuf text format string. Bytes other than
+// control characters, quotes and backslashes are kept as they are, so
+// UTF-8 paths stay readable.
+func nsjailQuote(s string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case c == '"' || c == '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case c == '\n':
+			b.WriteString(`\n`)
+		case c == '\t':
+			b.WriteString(`\t`)
+		case c < 0x20 || c == 0x7f:
+			fmt.Fprintf(&b, `\%03o`, c)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
@@ -0,0 +1,112 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNsjailConfigGolden(t *testing.T) {
+	sandbox, err := parseNativeSandboxArgs(goldenSandboxArgs)
+	require.NoError(t, err)
+	config := renderNsjailConfig(sandbox, nil, nil, 1000, 1000)
+	checkGolden(t, filepath.Join("testdata", "nsjail", "default.cfg"), []byte(config))
+}
+
+func TestNsjailConfigGoldenRestricted(t *testing.T) {
+	args := append([]string{"--unshare-net"}, goldenSandboxArgs...)
+	sandbox, err := parseNativeSandboxArgs(args)
+	require.NoError(t, err)
+	limits := ResourceLimits{AddressSpace: 2 << 30, OpenFiles: 256, DisableCoreDumps: true}.rlimits()
+	config := renderNsjailConfig(sandbox, limits, []string{"ptrace", "keyctl", "bpf"}, 1000, 1000)
+	checkGolden(t, filepath.Join("testdata", "nsjail", "restricted.cfg"), []byte(config))
+}
+
+func TestNsjailQuote(t *testing.T) {
+	assert.Equal(t, `"/games/My Game"`, nsjailQuote("/games/My Game"))
+	assert.Equal(t, `"a\"b\\c\nd\001"`, nsjailQuote("a\"b\\c\nd\x01"))
+	assert.Equal(t, `"/jeux/élan"`, nsjailQuote("/jeux/élan"))
+}
+
+func TestNsjailRunner(t *testing.T) {
+	origCommand := nsjailCommand
+	t.Cleanup(func() {
+		nsjailCommand = origCommand
+	})
+
+	var gotName string
+	var gotArgs []string
+	var contents []byte
+	var mode os.FileMode
+	nsjailCommand = func(name string, args ...string) *exec.Cmd {
+		gotName = name
+		gotArgs = append([]string{}, args...)
+		var err error
+		contents, err = os.ReadFile(args[1])
+		require.NoError(t, err)
+		info, err := os.Stat(args[1])
+		require.NoError(t, err)
+		mode = info.Mode().Perm()
+		return exec.Command("sh", "-c", "echo hello")
+	}
+
+	params, stdout := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.SandboxConfig.Type = SandboxTypeNsjail
+	params.SandboxConfig.NoNetwork = true
+	params.NsjailParams.BinaryPath = "/fake/nsjail"
+	params.ResourceLimits.OpenFiles = 256
+	if _, ok := seccompArchitectures[runtime.GOARCH]; ok {
+		params.SandboxConfig.Seccomp = SeccompPresetDefault
+	}
+
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, BackendNsjail, res.Backend)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	assert.Equal(t, "/fake/nsjail", gotName)
+	require.Len(t, gotArgs, 2)
+	assert.Equal(t, "--config", gotArgs[0])
+	configPath := gotArgs[1]
+	assert.Equal(t, filepath.Join(params.InstallFolder, ".itch", "nsjail"), filepath.Dir(configPath))
+	assert.True(t, strings.HasPrefix(filepath.Base(configPath), "smaug-launch-"))
+	assert.Eventually(t, func() bool {
+		_, err := os.Stat(configPath)
+		return os.IsNotExist(err)
+	}, 5*time.Second, 10*time.Millisecond)
+
+	assert.Equal(t, os.FileMode(0o600), mode)
+	lines := strings.Split(string(contents), "\n")
+	assert.Contains(t, lines, "clone_newnet: true")
+	assert.Contains(t, lines, "rlimit_nofile: 256")
+	assert.Contains(t, lines, `envar: "HOME=/home/player"`)
+	assert.NotContains(t, string(contents), "SMAUG_TEST_SECRET")
+	assert.Contains(t, lines, `	src: "`+params.InstallFolder+`"`)
+	assert.Contains(t, lines, `	path: "/bin/sh"`)
+	if params.SandboxConfig.Seccomp != SeccompPresetNone {
+		assert.Contains(t, string(contents), "ptrace")
+	}
+}
+
+func TestNsjailRunnerNeedsParams(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	_, err := newNsjailRunner(params)
+	assert.Error(t, err)
+
+	params.NsjailParams.BinaryPath = "/fake/nsjail"
+	params.InstallFolder = ""
+	_, err = newNsjailRunner(params)
+	assert.Error(t, err)
+}
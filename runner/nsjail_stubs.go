@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newNsjailRunner(params RunnerParams) (Runner, error) {
+	return nil, fmt.Errorf("nsjail runner is not implemented on %s", runtime.GOOS)
+}
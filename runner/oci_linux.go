@@ -0,0 +1,452 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/itchio/headway/state"
+	"golang.org/x/sys/unix"
+)
+
+// ociVersion is the version of the runtime spec bundles follow.
+const ociVersion = "1.0.2"
+
+var ociRuntimeCommand = exec.Command
+
+// ociSeccompArchitectures maps audit architectures to libseccomp's names.
+var ociSeccompArchitectures = map[uint32]string{
+	unix.AUDIT_ARCH_X86_64:  "SCMP_ARCH_X86_64",
+	unix.AUDIT_ARCH_I386:    "SCMP_ARCH_X86",
+	unix.AUDIT_ARCH_AARCH64: "SCMP_ARCH_AARCH64",
+}
+
+// ociSpec is the subset of the OCI runtime spec's config.json that bundles
+// use. See https://github.com/opencontainers/runtime-spec/blob/main/config.md
+type ociSpec struct {
+	OCIVersion string     `json:"ociVersion"`
+	Process    ociProcess `json:"process"`
+	Root       ociRoot    `json:"root"`
+	Mounts     []ociMount `json:"mounts"`
+	Linux      ociLinux   `json:"linux"`
+}
+
+type ociProcess struct {
+	Terminal        bool            `json:"terminal"`
+	User            ociUser         `json:"user"`
+	Args            []string        `json:"args"`
+	Env             []string        `json:"env"`
+	Cwd             string          `json:"cwd"`
+	Capabilities    ociCapabilities `json:"capabilities"`
+	Rlimits         []ociRlimit     `json:"rlimits,omitempty"`
+	NoNewPrivileges bool            `json:"noNewPrivileges"`
+}
+
+type ociUser struct {
+	UID uint32 `json:"uid"`
+	GID uint32 `json:"gid"`
+}
+
+type ociCapabilities struct {
+	Bounding    []string `json:"bounding"`
+	Effective   []string `json:"effective"`
+	Inheritable []string `json:"inheritable"`
+	Permitted   []string `json:"permitted"`
+	Ambient     []string `json:"ambient"`
+}
+
+type ociRlimit struct {
+	Type string `json:"type"`
+	Hard uint64 `json:"hard"`
+	Soft uint64 `json:"soft"`
+}
+
+type ociRoot struct {
+	Path     string `json:"path"`
+	Readonly bool   `json:"readonly"`
+}
+
+type ociMount struct {
+	Destination string   `json:"destination"`
+	Type        string   `json:"type"`
+	Source      string   `json:"source"`
+	Options     []string `json:"options,omitempty"`
+}
+
+type ociLinux struct {
+	UIDMappings []ociIDMapping `json:"uidMappings"`
+	GIDMappings []ociIDMapping `json:"gidMappings"`
+	Namespaces  []ociNamespace `json:"namespaces"`
+	Seccomp     *ociSeccomp    `json:"seccomp,omitempty"`
+}
+
+type ociIDMapping struct {
+	ContainerID uint32 `json:"containerID"`
+	HostID      uint32 `json:"hostID"`
+	Size        uint32 `json:"size"`
+}
+
+type ociNamespace struct {
+	Type string `json:"type"`
+}
+
+type ociSeccomp struct {
+	DefaultAction string       `json:"defaultAction"`
+	Architectures []string     `json:"architectures"`
+	Syscalls      []ociSyscall `json:"syscalls"`
+}
+
+type ociSyscall struct {
+	Names    []string `json:"names"`
+	Action   string   `json:"action"`
+	ErrnoRet uint     `json:"errnoRet"`
+}
+
+// newOCISpec translates a sandbox, as set up by bubblewrap, into a runtime
+// spec. The user is mapped to itself, like bwrap does. The root is an empty
+// read-only directory, that only holds mount points.
+func newOCISpec(sandbox *nativeSandboxSpec, limits []rlimit, seccomp *ociSeccomp, uid, gid uint32) *ociSpec {
+	spec := &ociSpec{
+		OCIVersion: ociVersion,
+		Process: ociProcess{
+			User: ociUser{UID: uid, GID: gid},
+			Args: sandbox.command,
+			Env:  sandbox.env,
+			Cwd:  "/",
+			Capabilities: ociCapabilities{
+				Bounding:    []string{},
+				Effective:   []string{},
+				Inheritable: []string{},
+				Permitted:   []string{},
+				Ambient:     []string{},
+			},
+			NoNewPrivileges: true,
+		},
+		Root:   ociRoot{Path: "rootfs", Readonly: true},
+		Mounts: []ociMount{},
+		Linux: ociLinux{
+			UIDMappings: []ociIDMapping{{ContainerID: uid, HostID: uid, Size: 1}},
+			GIDMappings: []ociIDMapping{{ContainerID: gid, HostID: gid, Size: 1}},
+			Namespaces:  []ociNamespace{{Type: "user"}, {Type: "mount"}},
+			Seccomp:     seccomp,
+		},
+	}
+	if sandbox.chdir != "" {
+		spec.Process.Cwd = sandbox.chdir
+	}
+	if spec.Process.Env == nil {
+		spec.Process.Env = []string{}
+	}
+
+	for _, l := range limits {
+		spec.Process.Rlimits = append(spec.Process.Rlimits, ociRlimit{
+			Type: "RLIMIT_" + strings.ToUpper(l.name),
+			Hard: l.value,
+			Soft: l.value,
+		})
+	}
+
+	namespaces := []struct {
+		enabled bool
+		name    string
+	}{
+		{sandbox.unsharePid, "pid"},
+		{sandbox.unshareUTS, "uts"},
+		{sandbox.unshareNet, "network"},
+		{sandbox.unshareIPC, "ipc"},
+	}
+	for _, ns := range namespaces {
+		if ns.enabled {
+			spec.Linux.Namespaces = append(spec.Linux.Namespaces, ociNamespace{Type: ns.name})
+		}
+	}
+
+	for _, op := range sandbox.ops {
+		var mounts []ociMount
+		switch op.kind {
+		case "ro-bind":
+			mounts = []ociMount{{op.target, "bind", op.source, []string{"rbind", "ro", "nosuid", "nodev"}}}
+		case "bind":
+			mounts = []ociMount{{op.target, "bind", op.source, []string{"rbind", "rw", "nosuid", "nodev"}}}
+		case "dev-bind":
+			mounts = []ociMount{{op.target, "bind", op.source, []string{"rbind", "rw", "nosuid"}}}
+		case "proc":
+			mounts = []ociMount{{op.target, "proc", "proc", []string{"nosuid", "noexec", "nodev"}}}
+		case "dev":
+			// The runtime adds null, zero, full, random, urandom and tty.
+			mounts = []ociMount{
+				{op.target, "tmpfs", "tmpfs", []string{"nosuid", "strictatime", "mode=755", "size=65536k"}},
+				{filepath.Join(op.target, "pts"), "devpts", "devpts", []string{"nosuid", "noexec", "newinstance", "ptmxmode=0666", "mode=0620"}},
+				{filepath.Join(op.target, "shm"), "tmpfs", "shm", []string{"nosuid", "noexec", "nodev", "mode=1777", "size=65536k"}},
+			}
+		case "tmpfs":
+			mounts = []ociMount{{op.target, "tmpfs", "tmpfs", []string{"nosuid", "nodev", "mode=755"}}}
+		case "dir":
+			// The runtime creates mount points itself.
+		}
+		spec.Mounts = append(spec.Mounts, mounts...)
+	}
+
+	return spec
+}
+
+// ociSeccompFor returns the seccomp section that denies the syscalls config
+// asks for, or nil if it asks for none. Runtimes compile it with libseccomp,
+// which skips names an architecture lacks.
+func ociSeccompFor(config SandboxConfig, archs []uint32) (*ociSeccomp, error) {
+	// Also validates names.
+	prog, err := seccompFilter(config)
+	if err != nil || prog == nil {
+		return nil, err
+	}
+	names, err := seccompDenyList(config)
+	if err != nil {
+		return nil, err
+	}
+
+	seccomp := &ociSeccomp{
+		DefaultAction: "SCMP_ACT_ALLOW",
+		Syscalls: []ociSyscall{{
+			Names:    names,
+			Action:   "SCMP_ACT_ERRNO",
+			ErrnoRet: uint(unix.EPERM),
+		}},
+	}
+	for _, arch := range archs {
+		seccomp.Architectures = append(seccomp.Architectures, ociSeccompArchitectures[arch])
+	}
+	return seccomp, nil
+}
+
+type ociRunner struct {
+	params RunnerParams
+}
+
+var _ Runner = (*ociRunner)(nil)
+var _ Starter = (*ociRunner)(nil)
+
+func newOCIRunner(params RunnerParams) (Runner, error) {
+	if params.OCIParams.BinaryPath == "" {
+		return nil, fmt.Errorf("OCIParams.BinaryPath must be set")
+	}
+	if params.InstallFolder == "" {
+		return nil, fmt.Errorf("InstallFolder must be set for the oci sandbox")
+	}
+
+	or := &ociRunner{
+		params: params,
+	}
+	return or, nil
+}
+
+func (or *ociRunner) Prepare() error {
+	return nil
+}
+
+// Start writes a bundle for the same sandbox as the bubblewrap runner's to
+// {InstallFolder}/.itch/oci/{container id}, and runs it with crun or runc.
+// The bundle is removed once the launch is over.
+func (or *ociRunner) Start() (*Handle, error) {
+	params := or.params
+	consumer := params.Consumer
+
+	sandbox, err := parseNativeSandboxArgs(bubblewrapArgs(params))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	seccomp, err := ociSeccompFor(params.SandboxConfig, seccompArchitectures[runtime.GOARCH])
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// Runtimes can't raise hard limits in a user namespace, clamp them
+	// like the rlimit shim does.
+	limits := launchRlimits(params)
+	for i, l := range limits {
+		var current unix.Rlimit
+		if unix.Getrlimit(l.resource, &current) == nil {
+			limits[i].value = min(l.value, current.Max)
+		}
+	}
+
+	id, err := newLaunchName()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	// Each launch gets its own bundle, so concurrent launches don't share
+	// a root.
+	spec := newOCISpec(sandbox, limits, seccomp, uint32(os.Getuid()), uint32(os.Getgid()))
+	bundle := filepath.Join(params.InstallFolder, ".itch", "oci", id)
+	err = writeOCIBundle(bundle, spec)
+	if err != nil {
+		os.RemoveAll(bundle)
+		return nil, fmt.Errorf("while writing OCI bundle: %w", err)
+	}
+
+	msg := fmt.Sprintf("Running (%s) in OCI container %s (bundle %s)", params.FullTargetPath, id, bundle)
+	if launchSandboxPolicy(params).Network == NetworkNone {
+		msg += " (networking disabled)"
+	}
+	consumer.Opf("%s", msg)
+
+	runtimePath := params.OCIParams.BinaryPath
+	cmd := ociRuntimeCommand(runtimePath, "run", "--bundle", bundle, id)
+	cmd.Dir = params.Dir
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+
+	tracker := &ociContainerTracker{
+		consumer:    consumer,
+		runtimePath: runtimePath,
+		id:          id,
+		bundle:      bundle,
+	}
+	h, err := startCommandWithTracker(params, cmd, BackendOCI, func() processTracker {
+		return tracker
+	})
+	if err != nil {
+		os.RemoveAll(bundle)
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h, nil
+}
+
+func (or *ociRunner) Run() (*ExitResult, error) {
+	h, err := or.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h.Wait()
+}
+
+// writeOCIBundle writes spec to bundle/config.json, next to an empty root
+// directory.
+func writeOCIBundle(bundle string, spec *ociSpec) error {
+	rootfs := filepath.Join(bundle, spec.Root.Path)
+	err := os.MkdirAll(rootfs, 0o755)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	contents, err := json.MarshalIndent(spec, "", "\t")
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	// The environment may hold secrets.
+	err = os.WriteFile(filepath.Join(bundle, "config.json"), append(contents, '\n'), 0o600)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// ociContainerTracker tracks a launch through the runtime's view of its
+// container. The game runs in its own PID namespace, out of reach of the
+// runtime's process group.
+type ociContainerTracker struct {
+	consumer    *state.Consumer
+	runtimePath string
+	id          string
+	bundle      string
+
+	// The runtime process, which forwards signals to the container.
+	main *os.Process
+}
+
+var _ processTracker = (*ociContainerTracker)(nil)
+
+func (ct *ociContainerTracker) run(args ...string) (string, error) {
+	cmd := ociRuntimeCommand(ct.runtimePath, args...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", filepath.Base(ct.runtimePath), args[0], err)
+	}
+	return string(output), nil
+}
+
+func (ct *ociContainerTracker) BeforeStart(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (ct *ociContainerTracker) AfterStart(cmd *exec.Cmd) error {
+	ct.main = cmd.Process
+	return nil
+}
+
+// Processes lists the container's processes. That needs the runtime to
+// manage its cgroup, which rootless containers may not have: none are
+// listed then.
+func (ct *ociContainerTracker) Processes() []ProcessInfo {
+	output, err := ct.run("ps", "--format", "json", ct.id)
+	if err != nil {
+		ct.consumer.Debugf("Could not list processes of container %s: %s", ct.id, err.Error())
+		return nil
+	}
+	var pids []int
+	err = json.Unmarshal([]byte(output), &pids)
+	if err != nil {
+		ct.consumer.Debugf("Could not list processes of container %s: %s", ct.id, err.Error())
+		return nil
+	}
+	return describeProcesses(pids)
+}
+
+func (ct *ociContainerTracker) Signal(sig syscall.Signal) error {
+	_, err := ct.run("kill", ct.id, strconv.Itoa(int(sig)))
+	if err == nil {
+		return nil
+	}
+
+	// The container may not be created yet.
+	ct.consumer.Infof("Could not signal container %s (%s), signalling process %d", ct.id, err.Error(), ct.main.Pid)
+	err = ct.main.Signal(sig)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func (ct *ociContainerTracker) Kill() error {
+	return ct.Signal(syscall.SIGKILL)
+}
+
+// Empty returns true once the container has stopped, or is gone: runtimes
+// delete containers when run returns.
+func (ct *ociContainerTracker) Empty() bool {
+	output, err := ct.run("state", ct.id)
+	if err != nil {
+		return true
+	}
+	var containerState struct {
+		Status string `json:"status"`
+	}
+	err = json.Unmarshal([]byte(output), &containerState)
+	return err != nil || containerState.Status == "stopped"
+}
+
+func (ct *ociContainerTracker) Report(res *ExitResult) {}
+
+// Close deletes the container, in case the runtime died before doing so,
+// then its bundle.
+func (ct *ociContainerTracker) Close() error {
+	_, err := ct.run("delete", "--force", ct.id)
+	if err != nil {
+		ct.consumer.Debugf("Could not delete container %s: %s", ct.id, err.Error())
+	}
+	err = os.RemoveAll(ct.bundle)
+	if err != nil {
+		ct.consumer.Debugf("Could not remove bundle %s: %s", ct.bundle, err.Error())
+	}
+	return nil
+}
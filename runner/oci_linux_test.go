@@ -0,0 +1,201 @@
+//go:build linux
+
+package runner
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+// goldenSandboxArgs is a bubblewrap command line like bubblewrapArgs makes,
+// with fixed paths so that bundles and configs made from it are the same on
+// every machine.
+var goldenSandboxArgs = []string{
+	"--ro-bind", "/usr", "/usr",
+	"--ro-bind", "/etc", "/etc",
+	"--ro-bind", "/sys", "/sys",
+	"--proc", "/proc",
+	"--dev", "/dev",
+	"--tmpfs", "/tmp",
+	"--dev-bind", "/dev/dri", "/dev/dri",
+	"--dir", "/home",
+	"--bind", "/games/My Game/.itch/home", "/home/player",
+	"--bind", "/games/My Game", "/games/My Game",
+	"--ro-bind", "/tmp/.X11-unix", "/tmp/.X11-unix",
+	"--unshare-user",
+	"--unshare-pid",
+	"--unshare-uts",
+	"--die-with-parent",
+	"--new-session",
+	"--clearenv",
+	"--setenv", "HOME", "/home/player",
+	"--setenv", "DISPLAY", ":0",
+	"--chdir", "/games/My Game",
+	"--", "/games/My Game/game.x86_64", "--fullscreen",
+}
+
+func checkOCIGolden(t *testing.T, name string, spec *ociSpec) {
+	t.Helper()
+	bundle := t.TempDir()
+	require.NoError(t, writeOCIBundle(bundle, spec))
+	assert.DirExists(t, filepath.Join(bundle, "rootfs"))
+	got, err := os.ReadFile(filepath.Join(bundle, "config.json"))
+	require.NoError(t, err)
+	checkGolden(t, filepath.Join("testdata", "oci", name+".json"), got)
+}
+
+// checkGolden compares got to the contents of golden, after rewriting it
+// with -update.
+func checkGolden(t *testing.T, golden string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		require.NoError(t, os.MkdirAll(filepath.Dir(golden), 0o755))
+		require.NoError(t, os.WriteFile(golden, got, 0o644))
+	}
+	want, err := os.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(got))
+}
+
+func TestOCISpecGolden(t *testing.T) {
+	sandbox, err := parseNativeSandboxArgs(goldenSandboxArgs)
+	require.NoError(t, err)
+	checkOCIGolden(t, "default", newOCISpec(sandbox, nil, nil, 1000, 1000))
+}
+
+func TestOCISpecGoldenRestricted(t *testing.T) {
+	if _, ok := seccompArchitectures[runtime.GOARCH]; !ok {
+		t.Skipf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+
+	args := append([]string{"--unshare-net"}, goldenSandboxArgs...)
+	sandbox, err := parseNativeSandboxArgs(args)
+	require.NoError(t, err)
+	limits := ResourceLimits{OpenFiles: 256, DisableCoreDumps: true}.rlimits()
+	seccomp, err := ociSeccompFor(SandboxConfig{Seccomp: SeccompPresetDefault}, []uint32{unix.AUDIT_ARCH_X86_64, unix.AUDIT_ARCH_I386})
+	require.NoError(t, err)
+	checkOCIGolden(t, "restricted", newOCISpec(sandbox, limits, seccomp, 1000, 1000))
+}
+
+func TestOCISeccompFor(t *testing.T) {
+	seccomp, err := ociSeccompFor(SandboxConfig{}, []uint32{unix.AUDIT_ARCH_X86_64})
+	require.NoError(t, err)
+	assert.Nil(t, seccomp)
+
+	_, err = ociSeccompFor(SandboxConfig{SeccompDeny: []string{"frobnicate"}}, []uint32{unix.AUDIT_ARCH_X86_64})
+	assert.Error(t, err)
+}
+
+func TestOCIRunner(t *testing.T) {
+	origCommand := ociRuntimeCommand
+	t.Cleanup(func() {
+		ociRuntimeCommand = origCommand
+	})
+
+	var calls [][]string
+	var spec ociSpec
+	var mode os.FileMode
+	ociRuntimeCommand = func(name string, args ...string) *exec.Cmd {
+		calls = append(calls, append([]string{name}, args...))
+		switch args[0] {
+		case "run":
+			configPath := filepath.Join(args[2], "config.json")
+			contents, err := os.ReadFile(configPath)
+			require.NoError(t, err)
+			require.NoError(t, json.Unmarshal(contents, &spec))
+			info, err := os.Stat(configPath)
+			require.NoError(t, err)
+			mode = info.Mode().Perm()
+			return exec.Command("sh", "-c", "echo hello")
+		case "state":
+			// Deleted along with the container once run returns.
+			return exec.Command("sh", "-c", "exit 1")
+		}
+		return exec.Command("sh", "-c", "true")
+	}
+
+	params, stdout := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.SandboxConfig.Type = SandboxTypeOCI
+	params.SandboxConfig.NoNetwork = true
+	params.OCIParams.BinaryPath = "/fake/crun"
+
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, BackendOCI, res.Backend)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	require.NotEmpty(t, calls)
+	run := calls[0]
+	require.Len(t, run, 5)
+	id := run[4]
+	bundle := filepath.Join(params.InstallFolder, ".itch", "oci", id)
+	assert.Equal(t, []string{"/fake/crun", "run", "--bundle", bundle}, run[:4])
+	assert.Equal(t, []string{"/fake/crun", "delete", "--force", id}, calls[len(calls)-1])
+	assert.NoDirExists(t, bundle)
+
+	assert.Equal(t, os.FileMode(0o600), mode)
+	assert.Equal(t, []string{"/bin/sh", "-c", "true"}, spec.Process.Args)
+	assert.Contains(t, spec.Process.Env, "HOME=/home/player")
+	assert.NotContains(t, spec.Process.Env, "SMAUG_TEST_SECRET=1")
+	assert.Contains(t, spec.Linux.Namespaces, ociNamespace{Type: "network"})
+	assert.Equal(t, uint32(os.Getuid()), spec.Process.User.UID)
+	assert.Contains(t, spec.Mounts, ociMount{
+		Destination: params.InstallFolder,
+		Type:        "bind",
+		Source:      params.InstallFolder,
+		Options:     []string{"rbind", "rw", "nosuid", "nodev"},
+	})
+}
+
+func TestOCIContainerTrackerProcesses(t *testing.T) {
+	origCommand := ociRuntimeCommand
+	t.Cleanup(func() {
+		ociRuntimeCommand = origCommand
+	})
+
+	ps := fmt.Sprintf("echo '[%d]'", os.Getpid())
+	ociRuntimeCommand = func(name string, args ...string) *exec.Cmd {
+		assert.Equal(t, []string{"ps", "--format", "json", "smaug-launch-1"}, args)
+		return exec.Command("sh", "-c", ps)
+	}
+
+	params, _ := newNativeTestParams(t, "true")
+	ct := &ociContainerTracker{
+		consumer:    params.Consumer,
+		runtimePath: "/fake/crun",
+		id:          "smaug-launch-1",
+	}
+	processes := ct.Processes()
+	require.Len(t, processes, 1)
+	assert.Equal(t, os.Getpid(), processes[0].Pid)
+
+	// Without a cgroup to look into, runtimes fail to list them.
+	ps = "exit 1"
+	assert.Empty(t, ct.Processes())
+}
+
+func TestOCIRunnerNeedsParams(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	_, err := newOCIRunner(params)
+	assert.Error(t, err)
+
+	params.OCIParams.BinaryPath = "/fake/crun"
+	params.InstallFolder = ""
+	_, err = newOCIRunner(params)
+	assert.Error(t, err)
+}
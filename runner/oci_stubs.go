@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newOCIRunner(params RunnerParams) (Runner, error) {
+	return nil, fmt.Errorf("oci runner is not implemented on %s", runtime.GOOS)
+}
@@ -0,0 +1,168 @@
+package runner
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// OutputStream identifies which output of a launch a line was written to.
+type OutputStream string
+
+const (
+	OutputStdout OutputStream = "stdout"
+	OutputStderr OutputStream = "stderr"
+)
+
+// maxOutputLineLength bounds the length of OutputLine.Text, in bytes. The
+// rest of a longer line is dropped.
+const maxOutputLineLength = 8192
+
+// OutputLine is a line written by a launch, see RunnerParams.OnOutputLine.
+type OutputLine struct {
+	// Position of the line among every line of the launch, both streams
+	// included, starting at 1. Across streams, it's the order lines reached
+	// the launcher, not necessarily the one they were written in.
+	Seq uint64
+
+	Stream OutputStream
+
+	// When the end of the line was received. It includes a monotonic clock
+	// reading, so durations between lines are unaffected by clock changes.
+	Time time.Time
+
+	// The line, without its line ending. Invalid UTF-8 and control
+	// characters other than tabs and escapes are replaced with U+FFFD.
+	Text string
+
+	// True if the line was longer than 8 KiB, and only its beginning was
+	// kept.
+	Truncated bool
+}
+
+// outputLines splits the output of a launch into lines. Both streams share
+// a lock, so lines are numbered and reported in the order they reach the
+// launcher.
+type outputLines struct {
+	onLine func(line OutputLine)
+
+	mu      sync.Mutex
+	seq     uint64
+	streams []*outputLineStream
+}
+
+type outputLineStream struct {
+	lines      *outputLines
+	stream     OutputStream
+	w          io.Writer
+	buf        []byte
+	discarding bool
+}
+
+// newOutputLines returns nil unless params.OnOutputLine is set.
+func newOutputLines(params RunnerParams) *outputLines {
+	if params.OnOutputLine == nil {
+		return nil
+	}
+	return &outputLines{onLine: params.OnOutputLine}
+}
+
+// writer returns a writer that splits what's written to it into lines, then
+// passes it on to w, which may be nil. It may be called on a nil
+// outputLines, in which case it returns w as-is.
+func (ol *outputLines) writer(stream OutputStream, w io.Writer) io.Writer {
+	if ol == nil {
+		return w
+	}
+	s := &outputLineStream{lines: ol, stream: stream, w: w}
+	ol.mu.Lock()
+	ol.streams = append(ol.streams, s)
+	ol.mu.Unlock()
+	return s
+}
+
+func (s *outputLineStream) Write(p []byte) (int, error) {
+	s.lines.mu.Lock()
+	s.feed(p, time.Now())
+	s.lines.mu.Unlock()
+
+	if s.w == nil {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
+
+// feed must be called with the lock held.
+func (s *outputLineStream) feed(p []byte, now time.Time) {
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		chunk := p
+		if i >= 0 {
+			chunk = p[:i]
+		}
+
+		if !s.discarding {
+			room := maxOutputLineLength - len(s.buf)
+			if len(chunk) > room {
+				s.buf = append(s.buf, chunk[:room]...)
+				s.emit(now, true)
+				s.discarding = true
+			} else {
+				s.buf = append(s.buf, chunk...)
+			}
+		}
+
+		if i < 0 {
+			return
+		}
+		if !s.discarding {
+			s.emit(now, false)
+		}
+		s.discarding = false
+		p = p[i+1:]
+	}
+}
+
+func (s *outputLineStream) emit(now time.Time, truncated bool) {
+	s.lines.seq++
+	s.lines.onLine(OutputLine{
+		Seq:       s.lines.seq,
+		Stream:    s.stream,
+		Time:      now,
+		Text:      sanitizeOutputLine(s.buf),
+		Truncated: truncated,
+	})
+	s.buf = s.buf[:0]
+}
+
+// flush reports unterminated last lines, once the launch is over. It may
+// be called on a nil outputLines.
+func (ol *outputLines) flush() {
+	if ol == nil {
+		return
+	}
+
+	ol.mu.Lock()
+	defer ol.mu.Unlock()
+
+	now := time.Now()
+	for _, s := range ol.streams {
+		if len(s.buf) > 0 && !s.discarding {
+			s.emit(now, false)
+		}
+	}
+}
+
+func sanitizeOutputLine(b []byte) string {
+	b = bytes.TrimSuffix(b, []byte("\r"))
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\x1b' || !unicode.IsControl(r) {
+			return r
+		}
+		return utf8.RuneError
+	}, strings.ToValidUTF8(string(b), string(utf8.RuneError)))
+}
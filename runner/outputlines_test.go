@@ -0,0 +1,91 @@
+package runner
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func collectOutputLines(lines *[]OutputLine) RunnerParams {
+	return RunnerParams{
+		OnOutputLine: func(line OutputLine) {
+			*lines = append(*lines, line)
+		},
+	}
+}
+
+func TestOutputLinesAreTaggedAndNumbered(t *testing.T) {
+	var got []OutputLine
+	ol := newOutputLines(collectOutputLines(&got))
+	var stdoutTarget bytes.Buffer
+	stdout := ol.writer(OutputStdout, &stdoutTarget)
+	stderr := ol.writer(OutputStderr, nil)
+
+	fmt.Fprint(stdout, "loading")
+	fmt.Fprint(stderr, "warning: no audio device\r\n")
+	fmt.Fprint(stdout, " done\nready\n\nbye")
+	ol.flush()
+
+	assert.Equal(t, "loading done\nready\n\nbye", stdoutTarget.String())
+
+	require.Len(t, got, 5)
+	expected := []struct {
+		stream OutputStream
+		text   string
+	}{
+		{OutputStderr, "warning: no audio device"},
+		{OutputStdout, "loading done"},
+		{OutputStdout, "ready"},
+		{OutputStdout, ""},
+		{OutputStdout, "bye"},
+	}
+	for i, e := range expected {
+		assert.Equal(t, uint64(i+1), got[i].Seq)
+		assert.Equal(t, e.stream, got[i].Stream)
+		assert.Equal(t, e.text, got[i].Text)
+		assert.False(t, got[i].Truncated)
+		if i > 0 {
+			assert.False(t, got[i].Time.Before(got[i-1].Time))
+		}
+	}
+}
+
+func TestOutputLinesTruncatesLongLines(t *testing.T) {
+	var got []OutputLine
+	ol := newOutputLines(collectOutputLines(&got))
+	w := ol.writer(OutputStdout, nil)
+
+	long := strings.Repeat("a", maxOutputLineLength)
+	fmt.Fprint(w, long[:100])
+	fmt.Fprint(w, long[100:]+"bbbb")
+	fmt.Fprint(w, "cccc\nshort\n"+long+"\n")
+	ol.flush()
+
+	require.Len(t, got, 3)
+	assert.Equal(t, long, got[0].Text)
+	assert.True(t, got[0].Truncated)
+	assert.Equal(t, "short", got[1].Text)
+	assert.False(t, got[1].Truncated)
+	assert.Equal(t, long, got[2].Text)
+	assert.False(t, got[2].Truncated)
+}
+
+func TestOutputLinesSanitizesBinaryOutput(t *testing.T) {
+	assert.Equal(t, "\x1b[31mred\x1b[0m\tok", sanitizeOutputLine([]byte("\x1b[31mred\x1b[0m\tok")))
+	assert.Equal(t, "a�b�c", sanitizeOutputLine([]byte("a\x00b\xffc")))
+	assert.Equal(t, "héllo", sanitizeOutputLine([]byte("héllo\r")))
+}
+
+func TestNilOutputLinesPassesWriterThrough(t *testing.T) {
+	var ol *outputLines
+	assert.Nil(t, newOutputLines(RunnerParams{}))
+
+	var buf bytes.Buffer
+	assert.Same(t, &buf, ol.writer(OutputStdout, &buf))
+	assert.Nil(t, ol.writer(OutputStderr, nil))
+	ol.flush()
+}
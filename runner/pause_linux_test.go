@@ -0,0 +1,135 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newPauseTestParams(t *testing.T, ctx context.Context) RunnerParams {
+	t.Helper()
+	return RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx: ctx,
+	}
+}
+
+func processState(t *testing.T, pid int) byte {
+	t.Helper()
+	st, err := readProcStat(pid)
+	require.NoError(t, err)
+	return st.state
+}
+
+func TestPauseStopsProcessGroup(t *testing.T) {
+	params := newPauseTestParams(t, context.Background())
+	h, err := startCommand(params, exec.Command("sleep", "30"), BackendSimple)
+	require.NoError(t, err)
+	defer func() {
+		h.Kill()
+		h.Wait()
+	}()
+
+	require.NoError(t, h.Pause())
+	assert.Eventually(t, func() bool {
+		return processState(t, h.Pid()) == 'T'
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, h.Resume())
+	assert.Eventually(t, func() bool {
+		return processState(t, h.Pid()) != 'T'
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCancelWhilePausedSendsShutdownSignal(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	params := newPauseTestParams(t, ctx)
+	h, err := startCommand(params, exec.Command("sleep", "30"), BackendSimple)
+	require.NoError(t, err)
+
+	require.NoError(t, h.Pause())
+	cancel()
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return within 5 seconds after context cancellation")
+	}
+
+	res, _ := h.Wait()
+	assert.True(t, res.Cancelled)
+	assert.False(t, res.Escalated, "a stopped process should still get SIGTERM")
+}
+
+func TestPausedTimeDoesNotCountAgainstWallClockLimit(t *testing.T) {
+	params := newPauseTestParams(t, context.Background())
+	params.TimeLimits = TimeLimits{WallClock: 500 * time.Millisecond}
+
+	h, err := startCommand(params, exec.Command("sleep", "30"), BackendSimple)
+	require.NoError(t, err)
+
+	require.NoError(t, h.Pause())
+	time.Sleep(700 * time.Millisecond)
+	select {
+	case <-h.Done():
+		t.Fatal("time limit was reached while paused")
+	default:
+	}
+	require.NoError(t, h.Resume())
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return within 5 seconds after the time limit")
+	}
+
+	res, _ := h.Wait()
+	assert.Equal(t, TimeLimitWallClock, res.TimeLimit)
+	assert.GreaterOrEqual(t, res.Paused, 700*time.Millisecond)
+	assert.GreaterOrEqual(t, res.Duration, 500*time.Millisecond)
+	assert.Less(t, res.Duration, 700*time.Millisecond)
+}
+
+func TestPauseUsesCgroupFreezer(t *testing.T) {
+	params := newCgroupTestParams(t, context.Background())
+	h, err := startCommand(params, exec.Command("sleep", "30"), BackendSimple)
+	require.NoError(t, err)
+	defer func() {
+		h.Kill()
+		h.Wait()
+	}()
+
+	ct, ok := h.pg.tracker.(*cgroupTracker)
+	require.True(t, ok, "expected cgroup tracking to be enabled")
+	if _, err := os.Stat(filepath.Join(ct.path, "cgroup.freeze")); err != nil {
+		t.Skip("cgroup freezer is not available")
+	}
+
+	frozen := func() uint64 {
+		events, err := os.ReadFile(filepath.Join(ct.path, "cgroup.events"))
+		require.NoError(t, err)
+		return parseCgroupEvents(string(events))["frozen"]
+	}
+
+	require.NoError(t, h.Pause())
+	assert.True(t, h.pg.frozen)
+	assert.Equal(t, uint64(1), frozen())
+
+	require.NoError(t, h.Resume())
+	assert.Equal(t, uint64(0), frozen())
+}
@@ -0,0 +1,80 @@
+//go:build linux
+
+package runner
+
+import (
+	"errors"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+var pidfdOpen = unix.PidfdOpen
+
+// pidHandle refers to a process by pidfd when the kernel supports it
+// (Linux 5.3+), so that it can be signalled and polled without racing
+// against the pid being reaped and recycled. Without pidfd support it
+// falls back to the raw pid.
+type pidHandle struct {
+	pid int
+	fd  int
+}
+
+func openPidHandle(pid int) *pidHandle {
+	fd, err := pidfdOpen(pid, 0)
+	if err != nil {
+		fd = -1
+	}
+	return &pidHandle{pid: pid, fd: fd}
+}
+
+// UsesPidfd returns true if the handle is backed by a pidfd.
+func (h *pidHandle) UsesPidfd() bool {
+	return h.fd >= 0
+}
+
+func (h *pidHandle) Signal(sig syscall.Signal) error {
+	if h.fd < 0 {
+		return syscall.Kill(h.pid, sig)
+	}
+	return unix.PidfdSendSignal(h.fd, sig, nil, 0)
+}
+
+// Exited returns true once the process has terminated. With a pidfd this
+// is true as soon as it exits, even if it hasn't been reaped yet.
+func (h *pidHandle) Exited() bool {
+	if h.fd < 0 {
+		return errors.Is(syscall.Kill(h.pid, 0), syscall.ESRCH)
+	}
+
+	fds := []unix.PollFd{{Fd: int32(h.fd), Events: unix.POLLIN}}
+	for {
+		n, err := unix.Poll(fds, 0)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		return err == nil && n > 0
+	}
+}
+
+func (h *pidHandle) Close() error {
+	if h.fd < 0 {
+		return nil
+	}
+	err := unix.Close(h.fd)
+	h.fd = -1
+	return err
+}
+
+// signalPid sends sig to pid. When pidfds are available, stillOurs is
+// checked after the pidfd is opened, which guarantees the process being
+// signalled is the one that was checked, and not a recycled pid.
+func signalPid(pid int, sig syscall.Signal, stillOurs func() bool) error {
+	h := openPidHandle(pid)
+	defer h.Close()
+
+	if h.UsesPidfd() && !stillOurs() {
+		return syscall.ESRCH
+	}
+	return h.Signal(sig)
+}
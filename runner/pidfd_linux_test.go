@@ -0,0 +1,159 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// withoutPidfd makes openPidHandle behave as if the kernel had no
+// pidfd_open, for the duration of the test.
+func withoutPidfd(t *testing.T) {
+	t.Helper()
+	original := pidfdOpen
+	pidfdOpen = func(pid int, flags int) (int, error) {
+		return -1, syscall.ENOSYS
+	}
+	t.Cleanup(func() {
+		pidfdOpen = original
+	})
+}
+
+func startSleep(t *testing.T) *exec.Cmd {
+	t.Helper()
+	cmd := exec.Command("sleep", "30")
+	require.NoError(t, cmd.Start())
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+	return cmd
+}
+
+func TestPidHandleOutlivesReap(t *testing.T) {
+	cmd := startSleep(t)
+
+	h := openPidHandle(cmd.Process.Pid)
+	defer h.Close()
+	if !h.UsesPidfd() {
+		t.Skip("pidfd_open is not supported")
+	}
+	assert.False(t, h.Exited())
+
+	require.NoError(t, h.Signal(syscall.SIGKILL))
+	cmd.Wait()
+
+	// Once reaped, the pid may belong to someone else, but the pidfd
+	// still refers to the original process.
+	assert.True(t, h.Exited())
+	assert.ErrorIs(t, h.Signal(syscall.SIGKILL), syscall.ESRCH)
+}
+
+func TestPidHandleFallback(t *testing.T) {
+	withoutPidfd(t)
+	cmd := startSleep(t)
+
+	h := openPidHandle(cmd.Process.Pid)
+	defer h.Close()
+	require.False(t, h.UsesPidfd())
+	assert.False(t, h.Exited())
+
+	require.NoError(t, h.Signal(syscall.SIGKILL))
+	cmd.Wait()
+	assert.True(t, h.Exited())
+}
+
+func TestSignalPidChecksOwnership(t *testing.T) {
+	cmd := startSleep(t)
+	if !openPidHandle(cmd.Process.Pid).UsesPidfd() {
+		t.Skip("pidfd_open is not supported")
+	}
+
+	err := signalPid(cmd.Process.Pid, syscall.SIGKILL, func() bool { return false })
+	assert.ErrorIs(t, err, syscall.ESRCH)
+	assert.True(t, processRunning(cmd.Process.Pid))
+
+	err = signalPid(cmd.Process.Pid, syscall.SIGKILL, func() bool { return true })
+	assert.NoError(t, err)
+	cmd.Wait()
+	assert.False(t, processRunning(cmd.Process.Pid))
+}
+
+func TestShutdownWithoutPidfd(t *testing.T) {
+	withoutPidfd(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx: ctx,
+	}
+
+	h, err := startCommand(params, exec.Command("sleep", "30"), BackendSimple)
+	require.NoError(t, err)
+	require.False(t, h.pg.main.UsesPidfd())
+
+	// Exercise the single-process path, which signals by pid.
+	h.pg.pgid = 0
+	cancel()
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return within 5 seconds after context cancellation")
+	}
+
+	res, _ := h.Wait()
+	assert.True(t, res.Cancelled)
+	assert.Equal(t, syscall.SIGTERM, res.Signal)
+	assert.False(t, res.Escalated)
+}
+
+func TestShutdownPollsMainPidfd(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx: ctx,
+		ShutdownPolicy: ShutdownPolicy{
+			GracePeriod: 500 * time.Millisecond,
+			KillTimeout: 500 * time.Millisecond,
+		},
+	}
+
+	// The background sleep keeps stdout open, so cmd.Wait only returns
+	// once it exits.
+	cmd := exec.Command("sh", "-c", "sleep 3 & exec sleep 30")
+	cmd.Stdout = &bytes.Buffer{}
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	if !h.pg.main.UsesPidfd() {
+		t.Skip("pidfd_open is not supported")
+	}
+
+	// Only the main process is signalled.
+	h.pg.pgid = 0
+	time.Sleep(100 * time.Millisecond)
+	cancel()
+
+	res, _ := h.Wait()
+	assert.True(t, res.Cancelled)
+	assert.False(t, res.Escalated)
+}
@@ -0,0 +1,34 @@
+//go:build !linux && !windows
+
+package runner
+
+import (
+	"errors"
+	"syscall"
+)
+
+// pidHandle refers to a process by its raw pid, since pidfds are
+// Linux-only.
+type pidHandle struct {
+	pid int
+}
+
+func openPidHandle(pid int) *pidHandle {
+	return &pidHandle{pid: pid}
+}
+
+func (h *pidHandle) UsesPidfd() bool {
+	return false
+}
+
+func (h *pidHandle) Signal(sig syscall.Signal) error {
+	return syscall.Kill(h.pid, sig)
+}
+
+func (h *pidHandle) Exited() bool {
+	return errors.Is(syscall.Kill(h.pid, 0), syscall.ESRCH)
+}
+
+func (h *pidHandle) Close() error {
+	return nil
+}
@@ -2,7 +2,9 @@ package policies
 
 // This templates generates a sandbox policy file suitable for
 // running relatively-untrusted apps via itch.
-
+//
+// Deprecated: the firejail runner generates its profile from a
+// runner.SandboxPolicy instead. This template is no longer used.
 const FirejailTemplate = `
 include /etc/firejail/itch_game_{{.Name}}.local
 include /etc/firejail/itch_games_globals.local
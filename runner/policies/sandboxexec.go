@@ -5,6 +5,9 @@ package policies
 //
 // Reference:
 // https://reverse.put.as/wp-content/uploads/2011/09/Apple-Sandbox-Guide-v1.0.pdf
+//
+// Deprecated: the sandbox-exec runner generates its profile from a
+// runner.SandboxPolicy instead. This template is no longer used.
 const SandboxExecTemplate = `
 (version 1)
 (deny default)
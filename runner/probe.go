@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SandboxReport is what ProbeSandboxes found out about the sandboxes
+// available on this system.
+type SandboxReport struct {
+	// Whether unprivileged user namespaces, which all Linux sandboxes but
+	// setuid ones rely on, can be created.
+	UserNamespaces UserNamespaceProbe
+
+	// Landlock ABI version supported by the kernel, or 0 if Landlock is
+	// unsupported or disabled.
+	LandlockABI int
+
+	// Sandboxes that SandboxTypeAuto chooses from, in order of preference.
+	Sandboxes []SandboxProbe
+}
+
+// UserNamespaceProbe describes the kernel and LSM settings that decide
+// whether unprivileged user namespaces can be created.
+type UserNamespaceProbe struct {
+	// True if unprivileged user namespaces look usable, according to the
+	// settings below. The trial runs of SandboxProbe have the last word.
+	Available bool
+
+	// kernel.unprivileged_userns_clone, a Debian and Ubuntu patch, or -1
+	// if the kernel doesn't have it.
+	UnprivilegedClone int
+
+	// user.max_user_namespaces, or -1 if it could not be read.
+	MaxUserNamespaces int
+
+	// kernel.apparmor_restrict_unprivileged_userns, set by default on
+	// Ubuntu 24.04 and later. User namespaces can then still be created,
+	// but without the capabilities needed to set up a sandbox in them,
+	// unless the AppArmor profile of the process allows it.
+	AppArmorRestricted bool
+
+	// AppArmor profile confining the launcher, e.g. "unconfined", or ""
+	// if AppArmor is disabled.
+	AppArmorProfile string
+
+	// Why user namespaces are usable or not.
+	Reasons []string
+}
+
+// SandboxProbe is what ProbeSandboxes found out about one sandbox type.
+type SandboxProbe struct {
+	Type SandboxType
+
+	// True if the sandbox is expected to work.
+	Available bool
+
+	// Binary the sandbox runs, if it has one.
+	BinaryPath string
+
+	// Version the binary reports, e.g. "bubblewrap 0.9.0".
+	Version string
+
+	// True if the binary is setuid root, in which case it doesn't need
+	// unprivileged user namespaces.
+	Setuid bool
+
+	// Why the sandbox is available or not, most important first.
+	Reasons []string
+}
+
+// Best returns the first available sandbox, in order of preference, or
+// false if none is.
+func (r *SandboxReport) Best() (SandboxProbe, bool) {
+	for _, probe := range r.Sandboxes {
+		if probe.Available {
+			return probe, true
+		}
+	}
+	return SandboxProbe{}, false
+}
+
+// String formats the report for logs and bug reports, one line per item.
+func (r *SandboxReport) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "user namespaces: %s", availability(r.UserNamespaces.Available, r.UserNamespaces.Reasons))
+	fmt.Fprintf(&b, "\nlandlock: ABI %d", r.LandlockABI)
+	for _, probe := range r.Sandboxes {
+		fmt.Fprintf(&b, "\n%s: ", probe.Type)
+		if probe.Version != "" {
+			fmt.Fprintf(&b, "(%s) ", probe.Version)
+		}
+		b.WriteString(availability(probe.Available, probe.Reasons))
+	}
+	return b.String()
+}
+
+func availability(available bool, reasons []string) string {
+	s := "unavailable"
+	if available {
+		s = "available"
+	}
+	if len(reasons) > 0 {
+		s += ": " + strings.Join(reasons, "; ")
+	}
+	return s
+}
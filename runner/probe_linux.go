@@ -0,0 +1,265 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// probeTimeout bounds each command ProbeSandboxes runs, so that a hung
+// binary can't hold up a launch.
+const probeTimeout = 5 * time.Second
+
+var probeCommand = exec.CommandContext
+
+// ProbeSandboxes finds out which sandboxes work on this system, and why
+// the others don't. Besides looking at binaries and kernel settings, it
+// runs `true` in a minimal bubblewrap and native sandbox, since that's the
+// only reliable way to tell, e.g. with AppArmor profiles in the mix. It
+// takes a few milliseconds when sandboxes work.
+func ProbeSandboxes(params RunnerParams) *SandboxReport {
+	report := &SandboxReport{
+		UserNamespaces: probeUserNamespaces(os.Geteuid()),
+		LandlockABI:    landlockABI(),
+	}
+	report.Sandboxes = []SandboxProbe{
+		probeFlatpak(params),
+		probeBubblewrap(params, report.UserNamespaces),
+		probeFirejail(params),
+		probeNative(report.UserNamespaces),
+	}
+	return report
+}
+
+// probeUserNamespaces reads the sysctls that decide whether unprivileged
+// user namespaces can be created by a user with the given euid.
+func probeUserNamespaces(euid int) UserNamespaceProbe {
+	probe := UserNamespaceProbe{
+		Available:         true,
+		UnprivilegedClone: readSysctlInt("kernel/unprivileged_userns_clone"),
+		MaxUserNamespaces: readSysctlInt("user/max_user_namespaces"),
+	}
+	probe.AppArmorRestricted = readSysctlInt("kernel/apparmor_restrict_unprivileged_userns") == 1
+	probe.AppArmorProfile = readAppArmorProfile()
+
+	if euid == 0 {
+		// None of the restrictions apply to root.
+		probe.Reasons = append(probe.Reasons, "running as root")
+		return probe
+	}
+	if probe.UnprivilegedClone == 0 {
+		probe.Available = false
+		probe.Reasons = append(probe.Reasons, "kernel.unprivileged_userns_clone is 0")
+	}
+	if probe.MaxUserNamespaces == 0 {
+		probe.Available = false
+		probe.Reasons = append(probe.Reasons, "user.max_user_namespaces is 0")
+	}
+	if probe.AppArmorRestricted {
+		if probe.AppArmorProfile == "unconfined" {
+			probe.Available = false
+			probe.Reasons = append(probe.Reasons, "kernel.apparmor_restrict_unprivileged_userns is 1, and the launcher has no AppArmor profile allowing user namespaces")
+		} else {
+			probe.Reasons = append(probe.Reasons, fmt.Sprintf("kernel.apparmor_restrict_unprivileged_userns is 1, the launcher's AppArmor profile (%s) must allow user namespaces", probe.AppArmorProfile))
+		}
+	}
+	if probe.Available && len(probe.Reasons) == 0 {
+		probe.Reasons = append(probe.Reasons, "not restricted by sysctls")
+	}
+	return probe
+}
+
+// readSysctlInt returns the value of the sysctl at path under
+// /proc/sys, or -1 if it doesn't exist or isn't a number.
+func readSysctlInt(path string) int {
+	contents, err := os.ReadFile(filepath.Join(procRoot, "sys", path))
+	if err != nil {
+		return -1
+	}
+	value, err := strconv.Atoi(strings.TrimSpace(string(contents)))
+	if err != nil {
+		return -1
+	}
+	return value
+}
+
+// readAppArmorProfile returns the AppArmor profile confining the launcher,
+// without its mode, or "" if AppArmor is disabled.
+func readAppArmorProfile() string {
+	for _, path := range []string{"self/attr/apparmor/current", "self/attr/current"} {
+		contents, err := os.ReadFile(filepath.Join(procRoot, path))
+		if err != nil {
+			continue
+		}
+		// e.g. "unconfined" or "/usr/bin/itch (enforce)"
+		profile, _, _ := strings.Cut(strings.TrimSpace(string(contents)), " (")
+		return profile
+	}
+	return ""
+}
+
+func probeFlatpak(params RunnerParams) SandboxProbe {
+	probe := SandboxProbe{Type: SandboxTypeFlatpak}
+	if !runningInFlatpak() {
+		probe.Reasons = append(probe.Reasons, "not running inside Flatpak")
+		return probe
+	}
+
+	probe.BinaryPath = params.FlatpakParams.BinaryPath
+	if probe.BinaryPath == "" {
+		probe.BinaryPath = flatpakSpawnPath
+	}
+	_, err := os.Stat(probe.BinaryPath)
+	if err != nil {
+		probe.Reasons = append(probe.Reasons, fmt.Sprintf("running inside Flatpak, but flatpak-spawn is missing: %s", err.Error()))
+		return probe
+	}
+	probe.Available = true
+	probe.Reasons = append(probe.Reasons, "running inside Flatpak, where other sandboxes can't nest")
+	return probe
+}
+
+func probeBubblewrap(params RunnerParams, userns UserNamespaceProbe) SandboxProbe {
+	probe := SandboxProbe{
+		Type:       SandboxTypeBubblewrap,
+		BinaryPath: params.BubblewrapParams.BinaryPath,
+	}
+	if probe.BinaryPath == "" {
+		probe.Reasons = append(probe.Reasons, "BubblewrapParams.BinaryPath is not set")
+		return probe
+	}
+	if !probeBinary(&probe) {
+		return probe
+	}
+
+	_, err := runProbe(nil, probe.BinaryPath, probeTrialArgs()...)
+	if err != nil {
+		probe.Reasons = append(probe.Reasons, fmt.Sprintf("trial run failed: %s", err.Error()))
+		if !probe.Setuid && !userns.Available {
+			probe.Reasons = append(probe.Reasons, userns.Reasons...)
+		}
+		return probe
+	}
+	probe.Available = true
+	probe.Reasons = append(probe.Reasons, "trial run succeeded")
+	return probe
+}
+
+func probeFirejail(params RunnerParams) SandboxProbe {
+	probe := SandboxProbe{
+		Type:       SandboxTypeFirejail,
+		BinaryPath: params.FirejailParams.BinaryPath,
+	}
+	if probe.BinaryPath == "" {
+		probe.Reasons = append(probe.Reasons, "FirejailParams.BinaryPath is not set")
+		return probe
+	}
+	if !probeBinary(&probe) {
+		return probe
+	}
+
+	// Not given a trial run, firejail sets up /run/firejail and more on
+	// its first one, and takes a while.
+	if !probe.Setuid && os.Geteuid() != 0 {
+		probe.Reasons = append(probe.Reasons, "firejail isn't setuid root")
+		return probe
+	}
+	probe.Available = true
+	if probe.Setuid {
+		probe.Reasons = append(probe.Reasons, "firejail is setuid root")
+	} else {
+		probe.Reasons = append(probe.Reasons, "running as root")
+	}
+	return probe
+}
+
+func probeNative(userns UserNamespaceProbe) SandboxProbe {
+	probe := SandboxProbe{Type: SandboxTypeNative}
+
+	_, err := runProbe(func(cmd *exec.Cmd) {
+		cmd.Args[0] = "smaug-sandbox"
+		cmd.Env = []string{nativeSandboxEnv + "=1"}
+		cmd.SysProcAttr = &syscall.SysProcAttr{
+			Cloneflags:  syscall.CLONE_NEWUSER | syscall.CLONE_NEWNS,
+			UidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}},
+			GidMappings: []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}},
+		}
+	}, launcherPath, probeTrialArgs()...)
+	if err != nil {
+		probe.Reasons = append(probe.Reasons, fmt.Sprintf("trial run failed: %s", err.Error()))
+		if !userns.Available {
+			probe.Reasons = append(probe.Reasons, userns.Reasons...)
+		}
+		return probe
+	}
+	probe.Available = true
+	probe.Reasons = append(probe.Reasons, "trial run succeeded")
+	return probe
+}
+
+// probeBinary checks that probe.BinaryPath exists, and fills in its
+// version and whether it's setuid root. It returns false, with a reason, if
+// the binary can't be used.
+func probeBinary(probe *SandboxProbe) bool {
+	info, err := os.Stat(probe.BinaryPath)
+	if err != nil {
+		probe.Reasons = append(probe.Reasons, fmt.Sprintf("binary not found: %s", err.Error()))
+		return false
+	}
+	if info.IsDir() || info.Mode().Perm()&0o111 == 0 {
+		probe.Reasons = append(probe.Reasons, fmt.Sprintf("%s is not executable", probe.BinaryPath))
+		return false
+	}
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		probe.Setuid = info.Mode()&os.ModeSetuid != 0 && stat.Uid == 0
+	}
+
+	output, err := runProbe(nil, probe.BinaryPath, "--version")
+	if err != nil {
+		probe.Reasons = append(probe.Reasons, fmt.Sprintf("could not get version: %s", err.Error()))
+		return false
+	}
+	// firejail follows its version with a list of features.
+	probe.Version, _, _ = strings.Cut(output, "\n")
+	return true
+}
+
+// runProbe runs a probe command, after setup if it isn't nil, and returns
+// its output. Errors are the output if there is any, e.g. "bwrap: setting
+// up uid map: Permission denied".
+func runProbe(setup func(cmd *exec.Cmd), name string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), probeTimeout)
+	defer cancel()
+	cmd := probeCommand(ctx, name, args...)
+	if setup != nil {
+		setup(cmd)
+	}
+	output, err := cmd.CombinedOutput()
+	msg := strings.TrimSpace(string(output))
+	if ctx.Err() != nil {
+		return msg, fmt.Errorf("timed out after %s", probeTimeout)
+	}
+	if err != nil && msg != "" {
+		return msg, errors.New(msg)
+	}
+	return msg, err
+}
+
+// probeTrialArgs are bubblewrap options, also understood by the native
+// sandbox, for a sandbox that's as simple as it gets.
+func probeTrialArgs() []string {
+	truePath, err := exec.LookPath("true")
+	if err != nil {
+		truePath = "/bin/true"
+	}
+	return []string{"--unshare-user", "--ro-bind", "/", "/", "--", truePath}
+}
@@ -0,0 +1,159 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeProcSys makes the probe read sysctls and the AppArmor profile from a
+// fake /proc.
+func fakeProcSys(t *testing.T, files map[string]string) {
+	t.Helper()
+	origRoot := procRoot
+	t.Cleanup(func() {
+		procRoot = origRoot
+	})
+
+	procRoot = t.TempDir()
+	for path, contents := range files {
+		path = filepath.Join(procRoot, path)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(contents+"\n"), 0o644))
+	}
+}
+
+// stubProbeCommand makes bwrap, as /bin/sh, report a version and fail its
+// trial run like it does on Ubuntu 24.04. The native sandbox's trial run
+// is real, unless nativeWorks is false.
+func stubProbeCommand(t *testing.T, nativeWorks bool) {
+	t.Helper()
+	origCommand := probeCommand
+	t.Cleanup(func() {
+		probeCommand = origCommand
+	})
+
+	probeCommand = func(ctx context.Context, name string, args ...string) *exec.Cmd {
+		switch {
+		case name == launcherPath && nativeWorks:
+			return exec.CommandContext(ctx, name, args...)
+		case name == launcherPath:
+			return exec.CommandContext(ctx, "sh", "-c", "echo 'mount: operation not permitted' >&2; exit 1")
+		case slices.Contains(args, "--version"):
+			return exec.CommandContext(ctx, "sh", "-c", "echo 'bubblewrap 0.9.0'")
+		default:
+			return exec.CommandContext(ctx, "sh", "-c", "echo 'bwrap: setting up uid map: Permission denied' >&2; exit 1")
+		}
+	}
+}
+
+func TestProbeUserNamespaces(t *testing.T) {
+	fakeProcSys(t, map[string]string{
+		"sys/user/max_user_namespaces": "63704",
+	})
+	probe := probeUserNamespaces(1000)
+	assert.True(t, probe.Available)
+	assert.Equal(t, -1, probe.UnprivilegedClone)
+	assert.Equal(t, 63704, probe.MaxUserNamespaces)
+	assert.Equal(t, []string{"not restricted by sysctls"}, probe.Reasons)
+}
+
+func TestProbeUserNamespacesRestricted(t *testing.T) {
+	fakeProcSys(t, map[string]string{
+		"sys/kernel/unprivileged_userns_clone":             "0",
+		"sys/user/max_user_namespaces":                     "0",
+		"sys/kernel/apparmor_restrict_unprivileged_userns": "1",
+		"self/attr/apparmor/current":                       "unconfined",
+	})
+	probe := probeUserNamespaces(1000)
+	assert.False(t, probe.Available)
+	assert.True(t, probe.AppArmorRestricted)
+	assert.Equal(t, "unconfined", probe.AppArmorProfile)
+	require.Len(t, probe.Reasons, 3)
+	assert.Contains(t, probe.Reasons[0], "kernel.unprivileged_userns_clone")
+	assert.Contains(t, probe.Reasons[1], "user.max_user_namespaces")
+	assert.Contains(t, probe.Reasons[2], "apparmor_restrict_unprivileged_userns")
+
+	// Root isn't restricted.
+	probe = probeUserNamespaces(0)
+	assert.True(t, probe.Available)
+	assert.Equal(t, []string{"running as root"}, probe.Reasons)
+}
+
+func TestProbeUserNamespacesAppArmorProfile(t *testing.T) {
+	fakeProcSys(t, map[string]string{
+		"sys/kernel/apparmor_restrict_unprivileged_userns": "1",
+		"self/attr/current": "/opt/itch/itch (enforce)",
+	})
+	probe := probeUserNamespaces(1000)
+	assert.True(t, probe.Available)
+	assert.Equal(t, "/opt/itch/itch", probe.AppArmorProfile)
+	assert.Contains(t, probe.Reasons[0], "/opt/itch/itch")
+}
+
+func TestProbeBubblewrapTrialFailure(t *testing.T) {
+	stubProbeCommand(t, true)
+
+	params := RunnerParams{BubblewrapParams: BubblewrapParams{BinaryPath: "/bin/sh"}}
+	userns := UserNamespaceProbe{Reasons: []string{"user.max_user_namespaces is 0"}}
+	probe := probeBubblewrap(params, userns)
+	assert.False(t, probe.Available)
+	assert.Equal(t, "bubblewrap 0.9.0", probe.Version)
+	assert.Equal(t, []string{
+		"trial run failed: bwrap: setting up uid map: Permission denied",
+		"user.max_user_namespaces is 0",
+	}, probe.Reasons)
+
+	params.BubblewrapParams.BinaryPath = "/nonexistent/bwrap"
+	probe = probeBubblewrap(params, userns)
+	assert.False(t, probe.Available)
+	assert.Contains(t, probe.Reasons[0], "binary not found")
+}
+
+func TestAutoSandboxSkipsBrokenBubblewrap(t *testing.T) {
+	requireUserNamespaces(t)
+	fakeFlatpak(t, false)
+	stubProbeCommand(t, true)
+
+	params, _ := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.BubblewrapParams.BinaryPath = "/bin/sh"
+
+	report := ProbeSandboxes(params)
+	best, ok := report.Best()
+	require.True(t, ok, "%s", report)
+	assert.Equal(t, SandboxTypeNative, best.Type)
+	assert.Contains(t, report.String(), "bubblewrap: (bubblewrap 0.9.0) unavailable: trial run failed")
+
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &nativeRunner{}, r)
+}
+
+func TestAutoSandboxFailsWithoutWorkingSandbox(t *testing.T) {
+	fakeFlatpak(t, false)
+	stubProbeCommand(t, false)
+
+	params, _ := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.BubblewrapParams.BinaryPath = "/bin/sh"
+
+	_, err := GetRunner(params)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "setting up uid map: Permission denied")
+	assert.Contains(t, err.Error(), "mount: operation not permitted")
+
+	// Explicit types aren't probed.
+	params.SandboxConfig.Type = SandboxTypeNative
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &nativeRunner{}, r)
+}
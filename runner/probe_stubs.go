@@ -0,0 +1,9 @@
+//go:build !linux
+
+package runner
+
+// ProbeSandboxes finds out which sandboxes work on this system. Only Linux
+// has several to choose from, so the report is empty elsewhere.
+func ProbeSandboxes(params RunnerParams) *SandboxReport {
+	return &SandboxReport{}
+}
@@ -4,21 +4,84 @@ package runner
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"os"
 	"os/exec"
+	"sync"
 	"syscall"
+	"time"
 
 	"github.com/itchio/headway/state"
 )
 
+const shutdownPollInterval = 50 * time.Millisecond
+
+// processTracker follows every process belonging to a launch, including
+// ones that left the process group (via setsid, double forks, etc.)
+type processTracker interface {
+	// BeforeStart is called after NewProcessGroup but before cmd.Start.
+	BeforeStart(cmd *exec.Cmd) error
+	AfterStart(cmd *exec.Cmd) error
+
+	// Processes lists tracked processes other than the main one.
+	Processes() []ProcessInfo
+
+	// Signal sends sig to every tracked process.
+	Signal(sig syscall.Signal) error
+	// Kill kills every tracked process.
+	Kill() error
+	// Empty returns true once no tracked process is left.
+	Empty() bool
+
+	// Report adds what the tracker knows about the launch to res, once
+	// every process has exited.
+	Report(res *ExitResult)
+
+	// Close releases the tracker once every process has exited.
+	Close() error
+}
+
 type processGroup struct {
-	consumer *state.Consumer
-	cmd      *exec.Cmd
-	ctx      context.Context
+	consumer       *state.Consumer
+	cmd            *exec.Cmd
+	ctx            context.Context
+	shutdownPolicy ShutdownPolicy
+	timeLimits     TimeLimits
+	limits         *timeLimitWatcher
+	tracker        processTracker
+	console        *Console
+	stdin          *os.File
+	log            *sessionLog
+	lines          *outputLines
+	crash          *crashCollector
+	startTime      time.Time
+	pgid           int
+	main           *pidHandle
+
+	waitDone   chan error
+	mainExited bool
+
+	pauseMu     sync.Mutex
+	pausedAt    time.Time
+	pausedTotal time.Duration
+	frozen      bool
+}
+
+// freezer is implemented by process trackers that can suspend every
+// tracked process atomically.
+type freezer interface {
+	Freeze() error
+	Thaw() error
 }
 
 func NewProcessGroup(consumer *state.Consumer, cmd *exec.Cmd, ctx context.Context) (*processGroup, error) {
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	// A new session gets a new process group of its own, and setpgid fails
+	// on session leaders.
+	cmd.SysProcAttr.Setpgid = !cmd.SysProcAttr.Setsid
 
 	pg := &processGroup{
 		consumer: consumer,
@@ -29,46 +92,339 @@ func NewProcessGroup(consumer *state.Consumer, cmd *exec.Cmd, ctx context.Contex
 }
 
 func (pg *processGroup) AfterStart() error {
+	pg.startTime = time.Now()
+
+	pid := pg.cmd.Process.Pid
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		pg.consumer.Infof("Could not get group of process %d: %s", pid, err.Error())
+	} else if pgid == 0 {
+		pg.consumer.Infof("Process %d had no group", pid)
+	}
+	pg.pgid = pgid
+
+	// Open the handle before anything can reap the main process, so it
+	// keeps referring to it even once its pid is recycled.
+	pg.main = openPidHandle(pid)
+	if !pg.main.UsesPidfd() {
+		pg.consumer.Debugf("No pidfd support, signalling process %d by pid", pid)
+	}
+
+	if pg.tracker != nil {
+		err = pg.tracker.AfterStart(pg.cmd)
+		if err != nil {
+			return fmt.Errorf("%w", err)
+		}
+	}
+
+	pg.limits = watchTimeLimits(pg.ctx, pg.consumer, pg.timeLimits, pg.cpuTimeFunc())
+	pg.ctx = pg.limits.Context()
 	return nil
 }
 
-func (pg *processGroup) Wait() error {
-	waitDone := make(chan error)
-	go func() {
-		waitDone <- pg.cmd.Wait()
+// abortStart kills and reaps the process group, and releases what was set
+// up for it, when the launch can't go on after cmd.Start. The tracker may
+// not be usable then, so only the group is killed.
+func (pg *processGroup) abortStart() {
+	if pg.pgid != 0 {
+		syscall.Kill(-pg.pgid, syscall.SIGKILL)
+	} else {
+		pg.cmd.Process.Kill()
+	}
+	pg.cmd.Wait()
+
+	if pg.main != nil {
+		pg.main.Close()
+	}
+	if pg.tracker != nil {
+		pg.tracker.Close()
+	}
+	if pg.console != nil {
+		pg.console.Close()
+	}
+	if pg.stdin != nil {
+		pg.stdin.Close()
+	}
+	pg.log.abort()
+}
+
+// Wait blocks until the main process exits or the context is cancelled.
+// With a tracker, it also waits for every tracked process to exit.
+// The returned ExitResult is always non-nil, even when an error is returned.
+func (pg *processGroup) Wait() (*ExitResult, error) {
+	res := newExitResult()
+	defer func() {
+		res.Duration, res.Paused = pg.durations()
+		pg.limits.Stop()
+		if pg.console != nil {
+			pg.console.finish()
+		}
+		if pg.stdin != nil {
+			pg.stdin.Close()
+		}
+		pg.main.Close()
+		if pg.tracker != nil {
+			pg.tracker.Report(res)
+			err := pg.tracker.Close()
+			if err != nil {
+				pg.consumer.Warnf("Could not clean up process tracking: %s", err.Error())
+			}
+		}
 	}()
 
-	pid := pg.cmd.Process.Pid
+	pg.waitDone = make(chan error, 1)
+	go func() {
+		pg.waitDone <- pg.cmd.Wait()
+	}()
 
 	select {
 	case <-pg.ctx.Done():
-		pg.consumer.Infof("Force closing...")
-		pgid, err := syscall.Getpgid(pid)
-		if err == nil && pgid != 0 {
-			pg.consumer.Infof("Killing all processes in group %d", pgid)
-			err = syscall.Kill(-pgid, syscall.SIGTERM)
-			if err != nil {
-				return fmt.Errorf("%w", err)
+		res.setShutdownReason(pg.ctx)
+		err := pg.shutdown(res)
+		if err != nil {
+			return res, fmt.Errorf("%w", err)
+		}
+	case err := <-pg.waitDone:
+		pg.mainExited = true
+		res.setProcessState(pg.cmd.ProcessState)
+		if pg.tracker != nil {
+			res.Survivors = pg.tracker.Processes()
+		}
+		if pg.tracker != nil && !pg.waitTracked(res.Survivors) {
+			res.setShutdownReason(pg.ctx)
+			serr := pg.shutdown(res)
+			if serr != nil {
+				return res, fmt.Errorf("%w", serr)
 			}
+		}
+		if err != nil {
+			return res, fmt.Errorf("%w", err)
+		}
+	}
 
-			return nil
-		} else {
-			if err != nil {
-				pg.consumer.Infof("Could not get group of process %d: %s", pid, err.Error())
-			} else {
-				pg.consumer.Infof("Process %d had no group", pid)
+	return res, nil
+}
+
+// waitTracked blocks until every tracked process has exited. It returns
+// false if the context is cancelled first.
+func (pg *processGroup) waitTracked(survivors []ProcessInfo) bool {
+	if pg.tracker.Empty() {
+		return true
+	}
+
+	pg.consumer.Infof("Main process exited, waiting for %d remaining processes...", len(survivors))
+	for _, p := range survivors {
+		pg.consumer.Infof("- PID %d (%s)", p.Pid, p.Command)
+	}
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-pg.ctx.Done():
+			return false
+		case <-ticker.C:
+			if pg.tracker.Empty() {
+				return true
 			}
-			pg.consumer.Infof("Killing single process %d", pid)
-			err = syscall.Kill(pid, syscall.SIGTERM)
-			if err != nil {
-				return fmt.Errorf("%w", err)
+		}
+	}
+}
+
+// shutdown signals the process group according to the shutdown policy,
+// escalating to SIGKILL if it doesn't exit within the grace period.
+func (pg *processGroup) shutdown(res *ExitResult) error {
+	policy := pg.shutdownPolicy.withDefaults()
+
+	pg.consumer.Infof("Force closing...")
+
+	// Stopped processes would only get the signal once continued.
+	err := pg.resume()
+	if err != nil {
+		pg.consumer.Warnf("Could not resume processes before shutting down: %s", err.Error())
+	}
+
+	err = pg.signal(policy.Signal)
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("%w", err)
+	}
+
+	if pg.waitGone(res, policy.GracePeriod) {
+		pg.consumer.Infof("All processes exited within grace period")
+		return nil
+	}
+
+	pg.consumer.Warnf("Still running after %s, escalating to SIGKILL", policy.GracePeriod)
+	res.Escalated = true
+	err = pg.kill()
+	if err != nil && !errors.Is(err, syscall.ESRCH) {
+		return fmt.Errorf("%w", err)
+	}
+
+	if pg.waitGone(res, policy.KillTimeout) {
+		pg.consumer.Infof("All processes exited after SIGKILL")
+		return nil
+	}
+
+	pg.consumer.Warnf("Some processes are still running %s after SIGKILL, giving up", policy.KillTimeout)
+	return nil
+}
+
+// signal sends sig to every process in the group. A process group id
+// can't be recycled while any member is left, so only the main process
+// needs a pidfd to be signalled safely.
+func (pg *processGroup) signal(sig syscall.Signal) error {
+	if pg.tracker != nil {
+		pg.consumer.Infof("Sending %s to all tracked processes", signalName(sig))
+		return pg.tracker.Signal(sig)
+	}
+
+	var err error
+	if pg.pgid != 0 {
+		pg.consumer.Infof("Sending %s to all processes in group %d", signalName(sig), pg.pgid)
+		err = syscall.Kill(-pg.pgid, sig)
+	} else {
+		pg.consumer.Infof("Sending %s to process %d", signalName(sig), pg.main.pid)
+		err = pg.main.Signal(sig)
+	}
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// kill kills every process in the group.
+func (pg *processGroup) kill() error {
+	if pg.tracker != nil {
+		pg.consumer.Infof("Killing all tracked processes")
+		return pg.tracker.Kill()
+	}
+	return pg.signal(syscall.SIGKILL)
+}
+
+// gone returns true once the main process has been reaped and no other
+// member of the group is left. A zombie still counts as a group member,
+// which is why the main process has to be reaped first. When only the main
+// process was being signalled, its pidfd tells once it has exited, even if
+// it can't be reaped yet because cmd.Wait is still copying its output.
+func (pg *processGroup) gone() bool {
+	if !pg.mainExited {
+		if pg.tracker == nil && pg.pgid == 0 && pg.main.UsesPidfd() {
+			return pg.main.Exited()
+		}
+		return false
+	}
+	if pg.tracker != nil {
+		return pg.tracker.Empty()
+	}
+	if pg.pgid == 0 {
+		// Only the main process was being signalled, and it's gone.
+		return true
+	}
+	return errors.Is(syscall.Kill(-pg.pgid, 0), syscall.ESRCH)
+}
+
+// waitGone reports whether the whole group has exited within timeout.
+func (pg *processGroup) waitGone(res *ExitResult, timeout time.Duration) bool {
+	deadline := time.After(timeout)
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+
+	for {
+		if pg.gone() {
+			if !pg.mainExited {
+				// Its exit status is still wanted, if it comes in time.
+				select {
+				case <-pg.waitDone:
+					pg.mainExited = true
+					res.setProcessState(pg.cmd.ProcessState)
+				case <-deadline:
+				}
 			}
+			return true
+		}
+
+		select {
+		case <-pg.waitDone:
+			pg.mainExited = true
+			res.setProcessState(pg.cmd.ProcessState)
+		case <-ticker.C:
+		case <-deadline:
+			return false
+		}
+	}
+}
+
+// pause suspends every process in the group, with the cgroup freezer if
+// the tracker supports it, or with SIGSTOP otherwise.
+func (pg *processGroup) pause() error {
+	pg.pauseMu.Lock()
+	defer pg.pauseMu.Unlock()
+
+	if !pg.pausedAt.IsZero() {
+		return nil
+	}
+
+	pg.frozen = false
+	if f, ok := pg.tracker.(freezer); ok {
+		err := f.Freeze()
+		if err == nil {
+			pg.frozen = true
+		} else {
+			pg.consumer.Warnf("Could not freeze cgroup, falling back to SIGSTOP: %s", err.Error())
+			f.Thaw()
+		}
+	}
+	if !pg.frozen {
+		err := pg.signal(syscall.SIGSTOP)
+		if err != nil {
+			return fmt.Errorf("%w", err)
 		}
-	case err := <-waitDone:
+	}
+
+	pg.consumer.Infof("Paused")
+	pg.pausedAt = time.Now()
+	pg.limits.SetPaused(true)
+	return nil
+}
+
+// resume undoes pause, with the same mechanism.
+func (pg *processGroup) resume() error {
+	pg.pauseMu.Lock()
+	defer pg.pauseMu.Unlock()
+
+	if pg.pausedAt.IsZero() {
+		return nil
+	}
+
+	if pg.frozen {
+		err := pg.tracker.(freezer).Thaw()
 		if err != nil {
 			return fmt.Errorf("%w", err)
 		}
+	} else {
+		err := pg.signal(syscall.SIGCONT)
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			return fmt.Errorf("%w", err)
+		}
 	}
 
+	pg.consumer.Infof("Resumed after %s", time.Since(pg.pausedAt))
+	pg.pausedTotal += time.Since(pg.pausedAt)
+	pg.pausedAt = time.Time{}
+	pg.limits.SetPaused(false)
 	return nil
 }
+
+// durations returns how long the group has been running, not counting
+// time spent paused, and how long it has been paused.
+func (pg *processGroup) durations() (active time.Duration, paused time.Duration) {
+	pg.pauseMu.Lock()
+	defer pg.pauseMu.Unlock()
+
+	paused = pg.pausedTotal
+	if !pg.pausedAt.IsZero() {
+		paused += time.Since(pg.pausedAt)
+	}
+	return time.Since(pg.startTime) - paused, paused
+}
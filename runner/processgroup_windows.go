@@ -6,6 +6,7 @@ import (
 	"context"
 	"fmt"
 	"syscall"
+	"time"
 	"unsafe"
 
 	"github.com/itchio/headway/state"
@@ -21,6 +22,18 @@ type processGroup struct {
 	ctx       context.Context
 	jobObject syscall.Handle
 	ioPort    syscall.Handle
+	startTime time.Time
+
+	timeLimits TimeLimits
+	limits     *timeLimitWatcher
+
+	// Console mode uses a new console window on Windows, not a Console.
+	console *Console
+
+	log   *sessionLog
+	lines *outputLines
+	// Crash bundles aren't supported on Windows.
+	crash *crashCollector
 }
 
 func NewProcessGroup(consumer *state.Consumer, cmd *execas.Cmd, ctx context.Context) (*processGroup, error) {
@@ -34,6 +47,10 @@ func NewProcessGroup(consumer *state.Consumer, cmd *execas.Cmd, ctx context.Cont
 }
 
 func (pg *processGroup) AfterStart() error {
+	pg.startTime = time.Now()
+	pg.limits = watchTimeLimits(pg.ctx, pg.consumer, pg.timeLimits, nil)
+	pg.ctx = pg.limits.Context()
+
 	err := pg.tryAssignJobObject()
 	if err != nil {
 		pg.consumer.Warnf("No job object support (%s)", err.Error())
@@ -107,8 +124,17 @@ func (pg *processGroup) tryAssignJobObject() error {
 	return err
 }
 
-func (pg *processGroup) Wait() error {
-	waitDone := make(chan error)
+// Wait blocks until all processes in the group exit or the context is
+// cancelled. The returned ExitResult is always non-nil, even when an error
+// is returned.
+func (pg *processGroup) Wait() (*ExitResult, error) {
+	res := newExitResult()
+	defer func() {
+		res.Duration = time.Since(pg.startTime)
+		pg.limits.Stop()
+	}()
+
+	waitDone := make(chan error, 1)
 	go func() {
 		if pg.jobObject == syscall.InvalidHandle {
 			pg.consumer.Infof("Waiting on single process...")
@@ -136,56 +162,25 @@ func (pg *processGroup) Wait() error {
 
 	select {
 	case <-pg.ctx.Done():
-		if pg.jobObject == syscall.InvalidHandle {
-			pid := uint32(pg.cmd.Process.Pid)
-			pg.consumer.Infof("Killing single process %d", pid)
-			// Use terminateProcess instead of cmd.Process.Kill() because
-			// the os.Process handle from os.FindProcess lacks PROCESS_TERMINATE.
-			terminateProcess(pid, 1)
-		} else {
-			pg.consumer.Infof("Attempting to kill entire job object...")
-			var processIdList syscallex.JobObjectBasicProcessIdList
-			processIdListPtr := uintptr(unsafe.Pointer(&processIdList))
-			processIdListSize := unsafe.Sizeof(processIdList)
-
-			pg.consumer.Infof("Querying job object...")
-			err := syscallex.QueryInformationJobObject(
-				pg.jobObject,
-				syscallex.JobObjectInfoClass_JobObjectBasicProcessIdList,
-				processIdListPtr,
-				processIdListSize,
-				0,
-			)
-			if err != nil {
-				pg.consumer.Infof("Querying job object error (!)")
-				ignoreError := false
-				if en, ok := err.(syscall.Errno); ok {
-					if en == syscall.ERROR_MORE_DATA {
-						// that's expected, the struct we pass has only room for 1 process
-						ignoreError = true
-					}
-				}
-
-				if !ignoreError {
-					return fmt.Errorf("%w", err)
-				}
-			}
-
-			pg.consumer.Infof("%d processes still in job object", processIdList.NumberOfAssignedProcesses)
-			pg.consumer.Infof("%d processes in our list", processIdList.NumberOfProcessIdsInList)
-			for i := uint32(0); i < processIdList.NumberOfProcessIdsInList; i++ {
-				pid := uint32(processIdList.ProcessIdList[i])
-				pg.consumer.Infof("- PID %d", pid)
-				err := terminateProcess(pid, 0)
-				if err != nil {
-					pg.consumer.Warnf("Could not kill pid %d: %s", pid, err.Error())
-				}
-			}
+		res.setShutdownReason(pg.ctx)
+		err := pg.terminateAll()
+		if err != nil {
+			return res, fmt.Errorf("%w", err)
 		}
 	case err := <-waitDone:
 		pg.consumer.Infof("Wait done")
+		if pg.cmd.ProcessState != nil {
+			res.setProcessState(pg.cmd.ProcessState)
+		} else {
+			// Waiting on the job object doesn't go through cmd.Wait, so
+			// ask for the main process' exit code directly.
+			var exitCode uint32
+			if err := syscall.GetExitCodeProcess(pg.cmd.SysProcAttr.ProcessHandle, &exitCode); err == nil {
+				res.ExitCode = int(exitCode)
+			}
+		}
 		if err != nil {
-			return fmt.Errorf("%w", err)
+			return res, fmt.Errorf("%w", err)
 		}
 	}
 
@@ -193,9 +188,70 @@ func (pg *processGroup) Wait() error {
 	// handle from the one managed by os.Process and must be closed explicitly.
 	syscall.CloseHandle(pg.cmd.SysProcAttr.ProcessHandle)
 
+	return res, nil
+}
+
+// terminateAll kills every process in the job object, or the main process
+// if it couldn't be assigned to one.
+func (pg *processGroup) terminateAll() error {
+	if pg.jobObject == syscall.InvalidHandle {
+		pid := uint32(pg.cmd.Process.Pid)
+		pg.consumer.Infof("Killing single process %d", pid)
+		// Use terminateProcess instead of cmd.Process.Kill() because
+		// the os.Process handle from os.FindProcess lacks PROCESS_TERMINATE.
+		terminateProcess(pid, 1)
+	} else {
+		pg.consumer.Infof("Attempting to kill entire job object...")
+		var processIdList syscallex.JobObjectBasicProcessIdList
+		processIdListPtr := uintptr(unsafe.Pointer(&processIdList))
+		processIdListSize := unsafe.Sizeof(processIdList)
+
+		pg.consumer.Infof("Querying job object...")
+		err := syscallex.QueryInformationJobObject(
+			pg.jobObject,
+			syscallex.JobObjectInfoClass_JobObjectBasicProcessIdList,
+			processIdListPtr,
+			processIdListSize,
+			0,
+		)
+		if err != nil {
+			pg.consumer.Infof("Querying job object error (!)")
+			ignoreError := false
+			if en, ok := err.(syscall.Errno); ok {
+				if en == syscall.ERROR_MORE_DATA {
+					// that's expected, the struct we pass has only room for 1 process
+					ignoreError = true
+				}
+			}
+
+			if !ignoreError {
+				return fmt.Errorf("%w", err)
+			}
+		}
+
+		pg.consumer.Infof("%d processes still in job object", processIdList.NumberOfAssignedProcesses)
+		pg.consumer.Infof("%d processes in our list", processIdList.NumberOfProcessIdsInList)
+		for i := uint32(0); i < processIdList.NumberOfProcessIdsInList; i++ {
+			pid := uint32(processIdList.ProcessIdList[i])
+			pg.consumer.Infof("- PID %d", pid)
+			err := terminateProcess(pid, 0)
+			if err != nil {
+				pg.consumer.Warnf("Could not kill pid %d: %s", pid, err.Error())
+			}
+		}
+	}
 	return nil
 }
 
+// signal delivers sig to the group. Windows has no signals, so only
+// SIGKILL (terminate every process) is supported.
+func (pg *processGroup) signal(sig syscall.Signal) error {
+	if sig != syscall.SIGKILL {
+		return fmt.Errorf("signal %s is not supported on windows", sig)
+	}
+	return pg.terminateAll()
+}
+
 func terminateProcess(pid uint32, exitcode int) error {
 	h, err := syscall.OpenProcess(syscall.PROCESS_TERMINATE, false, pid)
 	if err != nil {
@@ -208,3 +264,13 @@ func terminateProcess(pid uint32, exitcode int) error {
 	}
 	return nil
 }
+
+// pause is not supported on Windows.
+func (pg *processGroup) pause() error {
+	return fmt.Errorf("pausing is not supported on windows")
+}
+
+// resume is not supported on Windows.
+func (pg *processGroup) resume() error {
+	return fmt.Errorf("resuming is not supported on windows")
+}
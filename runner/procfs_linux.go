@@ -0,0 +1,129 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var procRoot = "/proc"
+
+// userHZ is the unit of CPU times in /proc/<pid>/stat. It is fixed at 100
+// on every architecture Linux games run on.
+const userHZ = 100
+
+// procStat holds the fields of /proc/<pid>/stat that process tracking
+// cares about.
+type procStat struct {
+	pid     int
+	comm    string
+	state   byte
+	ppid    int
+	pgrp    int
+	session int
+
+	// CPU time used by the process itself (utime + stime), and by its
+	// children that it has waited for (cutime + cstime), in clock ticks.
+	cpuTicks      int64
+	childCPUTicks int64
+}
+
+// cpuTime returns the CPU time used by the process and its waited-for
+// children.
+func (st procStat) cpuTime() time.Duration {
+	return time.Duration(st.cpuTicks+st.childCPUTicks) * time.Second / userHZ
+}
+
+func parseProcStat(contents string) (procStat, error) {
+	var st procStat
+
+	// comm may contain spaces and parentheses, so split on the last ')'
+	lparen := strings.IndexByte(contents, '(')
+	rparen := strings.LastIndexByte(contents, ')')
+	if lparen < 0 || rparen < lparen {
+		return st, fmt.Errorf("malformed stat: %q", contents)
+	}
+
+	pid, err := strconv.Atoi(strings.TrimSpace(contents[:lparen]))
+	if err != nil {
+		return st, fmt.Errorf("malformed stat pid: %w", err)
+	}
+	st.pid = pid
+	st.comm = contents[lparen+1 : rparen]
+
+	fields := strings.Fields(contents[rparen+1:])
+	if len(fields) < 4 || len(fields[0]) != 1 {
+		return st, fmt.Errorf("malformed stat: %q", contents)
+	}
+	st.state = fields[0][0]
+	for i, dst := range []*int{&st.ppid, &st.pgrp, &st.session} {
+		*dst, err = strconv.Atoi(fields[i+1])
+		if err != nil {
+			return st, fmt.Errorf("malformed stat field %d: %w", i+1, err)
+		}
+	}
+
+	// utime, stime, cutime and cstime are fields 14 to 17 of the whole line.
+	if len(fields) >= 15 {
+		var ticks [4]int64
+		for i := range ticks {
+			ticks[i], err = strconv.ParseInt(fields[11+i], 10, 64)
+			if err != nil {
+				return st, fmt.Errorf("malformed stat field %d: %w", 11+i, err)
+			}
+		}
+		st.cpuTicks = ticks[0] + ticks[1]
+		st.childCPUTicks = ticks[2] + ticks[3]
+	}
+	return st, nil
+}
+
+func readProcStat(pid int) (procStat, error) {
+	contents, err := os.ReadFile(filepath.Join(procRoot, strconv.Itoa(pid), "stat"))
+	if err != nil {
+		return procStat{}, err
+	}
+	return parseProcStat(string(contents))
+}
+
+// listProcStats returns the stat of every process visible in /proc.
+// Processes that exit while the list is being built are skipped.
+func listProcStats() ([]procStat, error) {
+	entries, err := os.ReadDir(procRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	var stats []procStat
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		st, err := readProcStat(pid)
+		if err != nil {
+			continue
+		}
+		stats = append(stats, st)
+	}
+	return stats, nil
+}
+
+// describeProcesses returns the pid and command name of each running process
+// in pids. Processes that have already exited are left out.
+func describeProcesses(pids []int) []ProcessInfo {
+	var out []ProcessInfo
+	for _, pid := range pids {
+		st, err := readProcStat(pid)
+		if err != nil || st.state == 'Z' {
+			continue
+		}
+		out = append(out, ProcessInfo{Pid: pid, Command: st.comm})
+	}
+	return out
+}
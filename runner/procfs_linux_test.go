@@ -0,0 +1,41 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseProcStat(t *testing.T) {
+	st, err := parseProcStat("1234 (my (weird) game) S 1000 1234 999 34817 1234 4194560 ...")
+	require.NoError(t, err)
+	assert.Equal(t, 1234, st.pid)
+	assert.Equal(t, "my (weird) game", st.comm)
+	assert.Equal(t, byte('S'), st.state)
+	assert.Equal(t, 1000, st.ppid)
+	assert.Equal(t, 1234, st.pgrp)
+	assert.Equal(t, 999, st.session)
+
+	_, err = parseProcStat("garbage")
+	assert.Error(t, err)
+}
+
+func TestParseProcStatCPUTime(t *testing.T) {
+	st, err := parseProcStat("42 (game) R 1 42 42 0 -1 4194304 100 0 0 0 250 50 30 20 20 0 1 0 100 0 0")
+	require.NoError(t, err)
+	assert.Equal(t, int64(300), st.cpuTicks)
+	assert.Equal(t, int64(50), st.childCPUTicks)
+	assert.Equal(t, 3500*time.Millisecond, st.cpuTime())
+}
+
+func TestReadProcStatSelf(t *testing.T) {
+	st, err := readProcStat(os.Getpid())
+	require.NoError(t, err)
+	assert.Equal(t, os.Getpid(), st.pid)
+	assert.Equal(t, os.Getppid(), st.ppid)
+}
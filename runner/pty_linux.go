@@ -0,0 +1,92 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	defaultConsoleCols = 80
+	defaultConsoleRows = 24
+)
+
+// openPTY allocates a pseudo-terminal, and returns its master and slave
+// (tty) sides.
+func openPTY() (*os.File, *os.File, error) {
+	masterFd, err := unix.Open("/dev/ptmx", unix.O_RDWR|unix.O_NOCTTY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("opening /dev/ptmx: %w", err)
+	}
+	master := os.NewFile(uintptr(masterFd), "/dev/ptmx")
+
+	err = unix.IoctlSetPointerInt(masterFd, unix.TIOCSPTLCK, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("unlocking pty: %w", err)
+	}
+	n, err := unix.IoctlGetInt(masterFd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("getting pty number: %w", err)
+	}
+
+	ttyPath := "/dev/pts/" + strconv.Itoa(n)
+	tty, err := os.OpenFile(ttyPath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, fmt.Errorf("opening (%s): %w", ttyPath, err)
+	}
+	return master, tty, nil
+}
+
+func resizePTY(master *os.File, cols, rows uint16) error {
+	ws := &unix.Winsize{Col: cols, Row: rows}
+	err := unix.IoctlSetWinsize(int(master.Fd()), unix.TIOCSWINSZ, ws)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// attachConsole makes cmd run in a new session with a fresh pseudo-terminal
+// as its controlling terminal and standard streams. It must be called
+// after NewProcessGroup, since a session leader can't also call setpgid:
+// the new session gets its own process group anyway.
+func attachConsole(params RunnerParams, cmd *exec.Cmd) (*Console, error) {
+	master, tty, err := openPTY()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	err = resizePTY(master, defaultConsoleCols, defaultConsoleRows)
+	if err != nil {
+		master.Close()
+		tty.Close()
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	cmd.SysProcAttr.Setpgid = false
+	cmd.SysProcAttr.Setsid = true
+	cmd.SysProcAttr.Setctty = true
+	// Ctty is a file descriptor number in the child, where stdin is the tty.
+	cmd.SysProcAttr.Ctty = 0
+
+	params.Consumer.Infof("Running in a pseudo-terminal (%s)", tty.Name())
+	c := &Console{
+		f:      master,
+		tty:    tty,
+		resize: resizePTY,
+	}
+	c.copyFrom = params.Stdin
+	c.copyTo = params.Stdout
+	return c, nil
+}
@@ -0,0 +1,152 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newConsoleTestParams(t *testing.T) RunnerParams {
+	t.Helper()
+	return RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx:     context.Background(),
+		Console: true,
+	}
+}
+
+func waitConsoleTest(t *testing.T, h *Handle) *ExitResult {
+	t.Helper()
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		h.Kill()
+		t.Fatal("Wait() did not return within 5 seconds")
+	}
+	res, err := h.Wait()
+	require.NoError(t, err)
+	return res
+}
+
+func TestConsoleIsControllingTerminal(t *testing.T) {
+	var stdout bytes.Buffer
+	params := newConsoleTestParams(t)
+	params.Stdout = &stdout
+
+	cmd := exec.Command("sh", "-c", "test -t 0 && test -t 1 && echo is-a-tty; echo via-ctty > /dev/tty; stty size")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	res := waitConsoleTest(t, h)
+	assert.Equal(t, 0, res.ExitCode)
+
+	output := strings.ReplaceAll(stdout.String(), "\r\n", "\n")
+	assert.Contains(t, output, "is-a-tty\n")
+	assert.Contains(t, output, "via-ctty\n")
+	assert.Contains(t, output, "24 80\n")
+}
+
+func TestConsoleReadWriteAndResize(t *testing.T) {
+	params := newConsoleTestParams(t)
+
+	cmd := exec.Command("sh", "-c", `read line; echo "got $line"; stty size`)
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+
+	console := h.Console()
+	require.NotNil(t, console)
+	defer console.Close()
+
+	require.NoError(t, console.Resize(100, 40))
+	_, err = io.WriteString(console, "hello\n")
+	require.NoError(t, err)
+
+	output, err := io.ReadAll(console)
+	require.NoError(t, err)
+	waitConsoleTest(t, h)
+
+	text := strings.ReplaceAll(string(output), "\r\n", "\n")
+	assert.Contains(t, text, "got hello\n")
+	assert.Contains(t, text, "40 100\n")
+}
+
+func TestBubblewrapConsoleKeepsSession(t *testing.T) {
+	origCommand := bubblewrapCommand
+	t.Cleanup(func() {
+		bubblewrapCommand = origCommand
+	})
+
+	var gotArgs []string
+	script := "echo via-ctty > /dev/tty"
+	bubblewrapCommand = func(name string, args ...string) *exec.Cmd {
+		gotArgs = append([]string{}, args...)
+		return exec.Command("sh", "-c", script)
+	}
+
+	var stdout bytes.Buffer
+	params := newConsoleTestParams(t)
+	params.Stdout = &stdout
+	params.FullTargetPath = "/bin/true"
+	params.BubblewrapParams.BinaryPath = "/fake/bwrap"
+
+	br := &bubblewrapRunner{params: params}
+	res, err := br.Run()
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.NotContains(t, gotArgs, "--new-session")
+	assert.Contains(t, stdout.String(), "via-ctty")
+
+	gotArgs = nil
+	script = "true"
+	params.Console = false
+	br = &bubblewrapRunner{params: params}
+	_, err = br.Run()
+	require.NoError(t, err)
+	assert.Contains(t, gotArgs, "--new-session")
+}
+
+func TestConsoleCopiesStdin(t *testing.T) {
+	var stdout bytes.Buffer
+	params := newConsoleTestParams(t)
+	params.Stdin = strings.NewReader("hello\n")
+	params.Stdout = &stdout
+
+	cmd := exec.Command("sh", "-c", `read line; echo "got $line"`)
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	waitConsoleTest(t, h)
+
+	assert.Contains(t, stdout.String(), "got hello")
+}
+
+func TestConsoleOutputIsCaptured(t *testing.T) {
+	var stdout bytes.Buffer
+	params := newConsoleTestParams(t)
+	params.Stdout = &stdout
+	params.InstallFolder = t.TempDir()
+	params.LogParams.Enabled = true
+
+	cmd := exec.Command("sh", "-c", "echo from the terminal")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	res := waitConsoleTest(t, h)
+
+	logBytes, err := os.ReadFile(res.LogPath)
+	require.NoError(t, err)
+	assert.Contains(t, string(logBytes), "from the terminal")
+	assert.Contains(t, stdout.String(), "from the terminal")
+}
@@ -0,0 +1,149 @@
+package runner
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+	"time"
+)
+
+// Backend identifies which runner implementation launched a process.
+type Backend string
+
+const (
+	BackendSimple      Backend = "simple"
+	BackendApp         Backend = "app"
+	BackendAttach      Backend = "attach"
+	BackendBubblewrap  Backend = "bubblewrap"
+	BackendFirejail    Backend = "firejail"
+	BackendSandboxExec Backend = "sandbox-exec"
+	BackendFuji        Backend = "fuji"
+	BackendNative      Backend = "native"
+	BackendLandlock    Backend = "landlock"
+	BackendSystemd     Backend = "systemd"
+	BackendOCI         Backend = "oci"
+	BackendFlatpak     Backend = "flatpak"
+	BackendNsjail      Backend = "nsjail"
+)
+
+// ExitResult describes how a launched process ended.
+type ExitResult struct {
+	// Exit code of the main process, or -1 if it was terminated by a
+	// signal or its status could not be determined.
+	ExitCode int
+
+	// Signal that terminated the main process, or 0 if it exited normally.
+	// Sandboxes that run the game as their child report its death by a
+	// signal N as exit code 128+N, which is decoded back into a signal, so
+	// a game exiting with such a code on its own looks killed there.
+	Signal syscall.Signal
+
+	// Wall-clock time between process start and exit, not counting time
+	// spent paused.
+	Duration time.Duration
+
+	// Time spent paused with Handle.Pause.
+	Paused time.Duration
+
+	// True if the process group was shut down because RunnerParams.Ctx
+	// was cancelled (e.g. the user clicked "Force close").
+	Cancelled bool
+
+	// Session time limit that ended the launch, if any. The process group
+	// is then shut down like it is on cancellation, but Cancelled is false.
+	TimeLimit TimeLimitKind
+
+	// True if the process group outlived the ShutdownPolicy grace period
+	// and had to be killed with SIGKILL.
+	Escalated bool
+
+	// Runner implementation that launched the process.
+	Backend Backend
+
+	// Processes that were still running when the main process exited.
+	// Only reported when cgroup or subreaper tracking is enabled.
+	Survivors []ProcessInfo
+
+	// True if the kernel OOM killer killed a process of the launch, because
+	// it went over CgroupParams.MemoryMax (or a limit of a parent cgroup).
+	// Only reported when cgroup tracking is enabled.
+	OOMKilled bool
+
+	// Session log file the output was captured to, if LogParams.Enabled.
+	LogPath string
+
+	// Crash bundle directory written for the launch, if CrashParams.Enabled
+	// and it crashed.
+	CrashBundle string
+}
+
+// ProcessInfo identifies a process that belonged to a launch.
+type ProcessInfo struct {
+	Pid     int
+	Command string
+}
+
+func newExitResult() *ExitResult {
+	return &ExitResult{
+		ExitCode: -1,
+	}
+}
+
+// Success returns true if the process exited on its own with code 0.
+func (r *ExitResult) Success() bool {
+	return r.ExitCode == 0 && r.Signal == 0 && !r.Cancelled && r.TimeLimit == TimeLimitNone
+}
+
+func (r *ExitResult) String() string {
+	switch {
+	case r.Cancelled:
+		return fmt.Sprintf("closed by user after %s", r.Duration)
+	case r.TimeLimit != TimeLimitNone:
+		return fmt.Sprintf("ended by %s time limit after %s", r.TimeLimit, r.Duration)
+	case r.OOMKilled && r.Signal != 0:
+		return fmt.Sprintf("killed by the out-of-memory killer after %s", r.Duration)
+	case r.Signal != 0:
+		return fmt.Sprintf("killed by signal %s after %s", signalName(r.Signal), r.Duration)
+	case r.ExitCode >= 0:
+		return fmt.Sprintf("exited with code %d after %s", r.ExitCode, r.Duration)
+	default:
+		return fmt.Sprintf("exited with unknown status after %s", r.Duration)
+	}
+}
+
+// setShutdownReason records why ctx was cancelled: a session time limit, or
+// the caller cancelling RunnerParams.Ctx.
+func (r *ExitResult) setShutdownReason(ctx context.Context) {
+	r.TimeLimit = timeLimitCause(ctx)
+	r.Cancelled = r.TimeLimit == TimeLimitNone
+}
+
+func (r *ExitResult) setProcessState(ps *os.ProcessState) {
+	if ps == nil {
+		return
+	}
+	r.ExitCode = ps.ExitCode()
+	if ws, ok := ps.Sys().(syscall.WaitStatus); ok && ws.Signaled() {
+		r.Signal = ws.Signal()
+	}
+}
+
+// maxSignal is the highest signal number, SIGRTMAX on Linux.
+const maxSignal = 64
+
+// decodeRelayedSignal turns an exit code of 128+N into signal N, for the
+// backends whose sandbox waits for the game and exits with 128+N when it's
+// killed by signal N, like a shell does.
+func (r *ExitResult) decodeRelayedSignal() {
+	switch r.Backend {
+	case BackendBubblewrap, BackendNative, BackendFirejail, BackendNsjail:
+	default:
+		return
+	}
+	if r.Signal != 0 || r.ExitCode <= 128 || r.ExitCode > 128+maxSignal {
+		return
+	}
+	r.Signal = syscall.Signal(r.ExitCode - 128)
+	r.ExitCode = -1
+}
@@ -0,0 +1,193 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/itchio/headway/state"
+	"golang.org/x/sys/unix"
+)
+
+// rlimitShimEnv is set on a re-executed launcher to make it apply rlimits
+// and exec into its arguments instead of running normally.
+const rlimitShimEnv = "SMAUG_RLIMITS"
+
+// launcherPath is the running launcher binary. Unlike os.Executable(),
+// it keeps working if the binary is replaced on disk while running.
+const launcherPath = "/proc/self/exe"
+
+type rlimit struct {
+	name     string
+	resource int
+	value    uint64
+}
+
+var rlimitResources = map[string]int{
+	"as":     unix.RLIMIT_AS,
+	"nofile": unix.RLIMIT_NOFILE,
+	"core":   unix.RLIMIT_CORE,
+	"cpu":    unix.RLIMIT_CPU,
+	"nproc":  unix.RLIMIT_NPROC,
+}
+
+func init() {
+	spec, ok := os.LookupEnv(rlimitShimEnv)
+	if !ok {
+		return
+	}
+	os.Exit(runRlimitShim(spec, os.Args))
+}
+
+// rlimits returns the limits that are set, in a stable order.
+func (rl ResourceLimits) rlimits() []rlimit {
+	var out []rlimit
+	add := func(name string, value uint64) {
+		out = append(out, rlimit{name: name, resource: rlimitResources[name], value: value})
+	}
+
+	if rl.AddressSpace > 0 {
+		add("as", rl.AddressSpace)
+	}
+	if rl.OpenFiles > 0 {
+		add("nofile", rl.OpenFiles)
+	}
+	if rl.DisableCoreDumps {
+		add("core", 0)
+	} else if rl.CoreSize > 0 {
+		add("core", rl.CoreSize)
+	}
+	if rl.CPUSeconds > 0 {
+		add("cpu", rl.CPUSeconds)
+	}
+	if rl.MaxProcesses > 0 {
+		add("nproc", rl.MaxProcesses)
+	}
+	return out
+}
+
+// formatRlimits encodes limits for rlimitShimEnv, e.g. "as=1073741824,core=0".
+func formatRlimits(limits []rlimit) string {
+	var parts []string
+	for _, l := range limits {
+		parts = append(parts, fmt.Sprintf("%s=%d", l.name, l.value))
+	}
+	return strings.Join(parts, ",")
+}
+
+func parseRlimits(spec string) ([]rlimit, error) {
+	var limits []rlimit
+	for part := range strings.SplitSeq(spec, ",") {
+		if part == "" {
+			continue
+		}
+		name, valueString, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("malformed rlimit %q", part)
+		}
+		resource, ok := rlimitResources[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown rlimit %q", name)
+		}
+		value, err := strconv.ParseUint(valueString, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("malformed rlimit %q: %w", part, err)
+		}
+		limits = append(limits, rlimit{name: name, resource: resource, value: value})
+	}
+	return limits, nil
+}
+
+// applyRlimits sets both the soft and hard limit of each resource for the
+// current process, so that the launched game can't raise them again.
+func applyRlimits(limits []rlimit) error {
+	for _, l := range limits {
+		var current unix.Rlimit
+		err := unix.Getrlimit(l.resource, &current)
+		if err != nil {
+			return fmt.Errorf("getting rlimit %s: %w", l.name, err)
+		}
+
+		value := min(l.value, current.Max)
+		err = unix.Setrlimit(l.resource, &unix.Rlimit{Cur: value, Max: value})
+		if err != nil {
+			return fmt.Errorf("setting rlimit %s to %d: %w", l.name, value, err)
+		}
+	}
+	return nil
+}
+
+// runRlimitShim applies the limits in spec, then replaces the current
+// process with args[1], passing it args[2:] as its argv. It only returns
+// if something went wrong, with the exit code to use.
+func runRlimitShim(spec string, args []string) int {
+	fail := func(err error) int {
+		fmt.Fprintf(os.Stderr, "smaug: rlimit shim: %s\n", err.Error())
+		return 127
+	}
+
+	if len(args) < 3 {
+		return fail(fmt.Errorf("expected a target to run, got %q", args))
+	}
+
+	limits, err := parseRlimits(spec)
+	if err != nil {
+		return fail(err)
+	}
+	err = applyRlimits(limits)
+	if err != nil {
+		return fail(err)
+	}
+
+	os.Unsetenv(rlimitShimEnv)
+	err = syscall.Exec(args[1], args[2:], os.Environ())
+	return fail(fmt.Errorf("exec (%s): %w", args[1], err))
+}
+
+// wrapWithRlimitShim rewrites cmd so that it runs through the launcher
+// binary, which applies limits before exec'ing into the original command.
+// rlimits are per-process and can't be set on a child from the outside
+// before it runs, so this is the only way to have them in effect from the
+// very first instruction of the target.
+func wrapWithRlimitShim(consumer *state.Consumer, cmd *exec.Cmd, limits []rlimit) {
+	if len(limits) == 0 {
+		return
+	}
+
+	spec := formatRlimits(limits)
+	consumer.Infof("Applying resource limits (%s)", spec)
+
+	env := cmd.Env
+	if env == nil {
+		env = os.Environ()
+	}
+	cmd.Env = append(env[:len(env):len(env)], rlimitShimEnv+"="+spec)
+
+	cmd.Args = append([]string{"smaug-rlimit", cmd.Path}, cmd.Args...)
+	cmd.Path = launcherPath
+}
+
+// launchRlimits returns the rlimits of a launch: params.ResourceLimits,
+// with core dumps allowed if CrashParams asks for them and nothing else
+// says otherwise.
+func launchRlimits(params RunnerParams) []rlimit {
+	limits := params.ResourceLimits
+	crash := params.CrashParams
+	if crash.Enabled && crash.CoreDumps && !limits.DisableCoreDumps && limits.CoreSize == 0 {
+		limits.CoreSize = crash.MaxCoreSize
+		if limits.CoreSize == 0 {
+			limits.CoreSize = unix.RLIM_INFINITY
+		}
+	}
+	return limits.rlimits()
+}
+
+// applyResourceLimits makes cmd run with params.ResourceLimits in effect.
+func applyResourceLimits(params RunnerParams, cmd *exec.Cmd) {
+	wrapWithRlimitShim(params.Consumer, cmd, launchRlimits(params))
+}
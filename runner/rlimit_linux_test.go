@@ -0,0 +1,176 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// parseProcLimits maps each limit name in the contents of
+// /proc/<pid>/limits to its soft and hard values.
+func parseProcLimits(contents string) map[string][2]string {
+	out := make(map[string][2]string)
+	lines := strings.Split(strings.TrimSpace(contents), "\n")
+	for _, line := range lines[1:] {
+		if len(line) < 26 {
+			continue
+		}
+		fields := strings.Fields(line[26:])
+		if len(fields) < 2 {
+			continue
+		}
+		out[strings.TrimSpace(line[:26])] = [2]string{fields[0], fields[1]}
+	}
+	return out
+}
+
+func TestResourceLimitsRlimits(t *testing.T) {
+	assert.Empty(t, ResourceLimits{}.rlimits())
+
+	limits := ResourceLimits{
+		AddressSpace:     1 << 30,
+		OpenFiles:        256,
+		CoreSize:         4096,
+		DisableCoreDumps: true,
+		CPUSeconds:       60,
+		MaxProcesses:     512,
+	}.rlimits()
+	assert.Equal(t, "as=1073741824,nofile=256,core=0,cpu=60,nproc=512", formatRlimits(limits))
+
+	parsed, err := parseRlimits(formatRlimits(limits))
+	require.NoError(t, err)
+	assert.Equal(t, limits, parsed)
+}
+
+func TestParseRlimitsRejectsMalformedSpecs(t *testing.T) {
+	for _, spec := range []string{"as", "stack=1", "nofile=-1", "cpu=lots"} {
+		_, err := parseRlimits(spec)
+		assert.Error(t, err, "spec %q", spec)
+	}
+}
+
+func newRlimitTestParams(t *testing.T, limits ResourceLimits, stdout *bytes.Buffer) RunnerParams {
+	t.Helper()
+	return RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx:            context.Background(),
+		Stdout:         stdout,
+		Stderr:         os.Stderr,
+		ResourceLimits: limits,
+	}
+}
+
+func TestSimpleRunnerAppliesResourceLimits(t *testing.T) {
+	var stdout bytes.Buffer
+	params := newRlimitTestParams(t, ResourceLimits{
+		OpenFiles:        64,
+		DisableCoreDumps: true,
+		CPUSeconds:       30,
+	}, &stdout)
+	params.FullTargetPath = "/bin/sh"
+	params.Args = []string{"-c", "cat /proc/self/limits"}
+	params.Env = []string{"SMAUG_TEST_VAR=1"}
+
+	sr, err := newSimpleRunner(params)
+	require.NoError(t, err)
+	_, err = sr.Run()
+	require.NoError(t, err)
+
+	limits := parseProcLimits(stdout.String())
+	assert.Equal(t, [2]string{"64", "64"}, limits["Max open files"])
+	assert.Equal(t, [2]string{"0", "0"}, limits["Max core file size"])
+	assert.Equal(t, [2]string{"30", "30"}, limits["Max cpu time"])
+}
+
+func TestRlimitShimDoesNotLeakIntoEnvironment(t *testing.T) {
+	var stdout bytes.Buffer
+	params := newRlimitTestParams(t, ResourceLimits{OpenFiles: 64}, &stdout)
+	params.FullTargetPath = "/bin/sh"
+	params.Args = []string{"-c", "env"}
+	params.Env = []string{"SMAUG_TEST_VAR=1"}
+
+	sr, err := newSimpleRunner(params)
+	require.NoError(t, err)
+	_, err = sr.Run()
+	require.NoError(t, err)
+
+	gotEnv := parseEnvironmentOutput(stdout.String())
+	assert.Equal(t, "1", gotEnv["SMAUG_TEST_VAR"])
+	_, leaked := gotEnv[rlimitShimEnv]
+	assert.False(t, leaked)
+}
+
+func TestBubblewrapAppliesResourceLimits(t *testing.T) {
+	origCommand := bubblewrapCommand
+	t.Cleanup(func() {
+		bubblewrapCommand = origCommand
+	})
+
+	bubblewrapCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "cat /proc/self/limits")
+	}
+
+	var stdout bytes.Buffer
+	params := newRlimitTestParams(t, ResourceLimits{OpenFiles: 128, MaxProcesses: 4096}, &stdout)
+	params.FullTargetPath = "/bin/true"
+	params.BubblewrapParams.BinaryPath = "/fake/bwrap"
+
+	br := &bubblewrapRunner{params: params}
+	_, err := br.Run()
+	require.NoError(t, err)
+
+	limits := parseProcLimits(stdout.String())
+	assert.Equal(t, [2]string{"128", "128"}, limits["Max open files"])
+	assert.Equal(t, [2]string{"4096", "4096"}, limits["Max processes"])
+}
+
+func TestFirejailAppliesResourceLimits(t *testing.T) {
+	origCommand := firejailCommand
+	t.Cleanup(func() {
+		firejailCommand = origCommand
+	})
+
+	firejailCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "cat /proc/self/limits")
+	}
+
+	var stdout bytes.Buffer
+	fr := newFirejailTestRunner(t, false)
+	fr.params.Stdout = &stdout
+	fr.params.ResourceLimits = ResourceLimits{
+		AddressSpace:     1 << 32,
+		OpenFiles:        256,
+		DisableCoreDumps: true,
+	}
+
+	_, err := fr.Run()
+	require.NoError(t, err)
+
+	profilePath := filepath.Join(fr.params.InstallFolder, ".itch", "isolate-app.profile")
+	profileBytes, err := os.ReadFile(profilePath)
+	require.NoError(t, err)
+	profileText := string(profileBytes)
+	assert.Contains(t, profileText, "rlimit-as 4294967296\n")
+	assert.Contains(t, profileText, "rlimit-nofile 256\n")
+	assert.NotContains(t, profileText, "rlimit-core")
+
+	// firejail has no profile option for core dumps, so that one is set
+	// on firejail itself, while the rest is left to the profile.
+	limits := parseProcLimits(stdout.String())
+	assert.Equal(t, [2]string{"0", "0"}, limits["Max core file size"])
+	assert.NotEqual(t, "256", limits["Max open files"][1])
+}
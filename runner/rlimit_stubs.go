@@ -0,0 +1,14 @@
+//go:build !linux && !windows
+
+package runner
+
+import "os/exec"
+
+func applyResourceLimits(params RunnerParams, cmd *exec.Cmd) {
+	if params.ResourceLimits != (ResourceLimits{}) {
+		params.Consumer.Warnf("Resource limits are only supported on Linux, ignoring them")
+	}
+	if params.CrashParams.Enabled && params.CrashParams.CoreDumps {
+		params.Consumer.Infof("Enabling core dumps is only supported on Linux, ignoring it")
+	}
+}
@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io"
 	"runtime"
+	"strings"
+	"syscall"
+	"time"
 
 	"github.com/itchio/headway/state"
 	"github.com/itchio/ox"
@@ -24,19 +27,55 @@ type RunnerParams struct {
 	Dir    string
 	Args   []string
 	Env    []string
+	Stdin  io.Reader
 	Stdout io.Writer
 	Stderr io.Writer
 
+	// If set, called for each line the launch outputs, from both stdout and
+	// stderr, in the order they're received. Each stream's lines come in
+	// order, but stdout and stderr go through separate pipes: how lines of
+	// one interleave with the other's is arbitrary, and may not be the order
+	// the launch wrote them in. Calls are serialized, and
+	// output is held up until they return, so they must not block for
+	// long. Output is still passed to Stdout and Stderr, but through a
+	// pipe, even if those are files.
+	OnOutputLine func(line OutputLine)
+
 	InstallFolder string
 	TempDir       string
 	Runtime       ox.Runtime
 
 	SandboxConfig SandboxConfig
 
+	// How the process group is shut down when Ctx is cancelled.
+	ShutdownPolicy ShutdownPolicy
+
+	// Wall-clock and CPU time limits for the session.
+	TimeLimits TimeLimits
+
+	// Capture of the game's output into per-session log files.
+	LogParams LogParams
+
+	// Linux-only cgroup v2 process tracking.
+	CgroupParams CgroupParams
+
+	// Linux-only child subreaper process tracking.
+	SubreaperParams SubreaperParams
+
+	// Linux-only resource limits for the launched process tree.
+	ResourceLimits ResourceLimits
+
+	// Crash bundles written when the game crashes. Unix only.
+	CrashParams CrashParams
+
 	// runner-specific params
 
 	FirejailParams   FirejailParams
 	BubblewrapParams BubblewrapParams
+	SystemdParams    SystemdParams
+	OCIParams        OCIParams
+	FlatpakParams    FlatpakParams
+	NsjailParams     NsjailParams
 	FujiParams       FujiParams
 	AttachParams     AttachParams
 }
@@ -48,6 +87,26 @@ const (
 	SandboxTypeBubblewrap SandboxType = "bubblewrap"
 	SandboxTypeFirejail   SandboxType = "firejail"
 	SandboxTypeFuji       SandboxType = "fuji"
+	SandboxTypeNative     SandboxType = "native"
+	SandboxTypeLandlock   SandboxType = "landlock"
+	SandboxTypeSystemd    SandboxType = "systemd"
+	SandboxTypeOCI        SandboxType = "oci"
+	SandboxTypeFlatpak    SandboxType = "flatpak"
+	SandboxTypeNsjail     SandboxType = "nsjail"
+)
+
+// SeccompPreset is a list of syscalls denied by a seccomp filter.
+type SeccompPreset string
+
+const (
+	// No syscall is denied, unless listed in SandboxConfig.SeccompDeny.
+	SeccompPresetNone SeccompPreset = ""
+	// Denies ptrace, keyrings, perf, BPF, kexec, kernel modules and other
+	// system administration syscalls.
+	SeccompPresetDefault SeccompPreset = "default"
+	// Also denies namespaces, mounts, io_uring and personality. Breaks games
+	// that embed Chromium with its sandbox enabled.
+	SeccompPresetStrict SeccompPreset = "strict"
 )
 
 type SandboxPolicyMode string
@@ -68,11 +127,159 @@ type SandboxConfig struct {
 	// Environment variable names to allow through from the host into the sandbox.
 	AllowEnv []string
 
+	// If true, also restrict filesystem access with Landlock inside the
+	// bubblewrap, firejail or native sandbox, as defence in depth. Linux
+	// only. The "landlock" sandbox type uses it on its own instead.
+	Landlock bool
+
+	// Syscalls denied with EPERM by a seccomp filter, in the bubblewrap,
+	// firejail and native sandboxes. Linux only.
+	Seccomp SeccompPreset
+
+	// Syscalls denied in addition to the Seccomp preset's, by name (e.g.
+	// "ptrace").
+	SeccompDeny []string
+
 	// Sandbox policy mode for backends that support multiple policy variants.
 	// On macOS sandbox-exec:
 	// - "balanced" (default): hardened profile with compatibility safeguards
 	// - "legacy": broader compatibility-focused profile
 	PolicyMode SandboxPolicyMode
+
+	// What the sandbox may access, in place of DefaultSandboxPolicy(params),
+	// e.g. a modified copy of it. NoNetwork and AllowEnv still apply on top
+	// of it. Used by the bubblewrap, firejail, native, systemd, OCI, nsjail,
+	// flatpak and sandbox-exec sandboxes.
+	Policy *SandboxPolicy
+}
+
+// ShutdownPolicy controls how a process group is shut down when the run
+// context is cancelled. It is honored on Unix; on Windows, job object
+// members are terminated immediately.
+type ShutdownPolicy struct {
+	// Signal sent to the whole group first. Defaults to SIGTERM.
+	Signal syscall.Signal
+
+	// How long to wait for the group to exit after the first signal before
+	// escalating to SIGKILL. Defaults to 5 seconds.
+	GracePeriod time.Duration
+
+	// How long to wait for the group to be empty after SIGKILL before giving
+	// up. Defaults to 5 seconds.
+	KillTimeout time.Duration
+}
+
+const (
+	defaultShutdownGracePeriod = 5 * time.Second
+	defaultShutdownKillTimeout = 5 * time.Second
+)
+
+func (sp ShutdownPolicy) withDefaults() ShutdownPolicy {
+	if sp.Signal == 0 {
+		sp.Signal = syscall.SIGTERM
+	}
+	if sp.GracePeriod <= 0 {
+		sp.GracePeriod = defaultShutdownGracePeriod
+	}
+	if sp.KillTimeout <= 0 {
+		sp.KillTimeout = defaultShutdownKillTimeout
+	}
+	return sp
+}
+
+// CgroupParams configures cgroup v2 tracking of launched processes on Linux.
+// Each launch gets its own leaf cgroup, so processes that leave the process
+// group (by calling setsid or double-forking) are still waited for and killed.
+type CgroupParams struct {
+	// If true, track launches in a cgroup. If the cgroup can't be created,
+	// or the process can't be started in it, a warning is logged and only
+	// the process group is tracked.
+	Enabled bool
+
+	// cgroupfs directory under which per-launch cgroups are created, e.g.
+	// "/sys/fs/cgroup/user.slice/user-1000.slice/user@1000.service/app.slice".
+	// Empty means the parent of the current process' cgroup, if it's
+	// writable. That's a heuristic, which holds when systemd delegates the
+	// user session and the launcher runs in a scope or service of it.
+	ParentPath string
+
+	// Maximum memory used by the whole launch, in bytes (memory.max). Past
+	// it, the kernel OOM killer kills processes of the launch, which is
+	// reported in ExitResult.OOMKilled. Zero means no limit.
+	MemoryMax uint64
+
+	// Maximum CPU time used by the whole launch, as a number of CPUs
+	// (cpu.max), e.g. 1.5 for one and a half CPUs. Zero means no limit.
+	CPUQuota float64
+
+	// Maximum number of processes and threads in the launch (pids.max).
+	// Zero means no limit.
+	PidsMax uint64
+}
+
+// SubreaperParams configures child subreaper tracking on Linux. The
+// launcher process becomes a subreaper (PR_SET_CHILD_SUBREAPER), so
+// descendants orphaned by the main process (e.g. a game backgrounded by a
+// shell wrapper) are reparented to it. Waiting then only ends once the
+// whole tree has exited. This changes a process-wide attribute and stays
+// in effect after the launch.
+type SubreaperParams struct {
+	Enabled bool
+}
+
+// ResourceLimits caps the resources a launch may use, via rlimits (see
+// setrlimit(2)). Limits are inherited by every process the game starts, and
+// are enforced the same way by the simple, bubblewrap and firejail runners.
+// A zero field leaves the corresponding limit unchanged. Limits can only be
+// lowered: values above the launcher's own hard limit are clamped to it.
+type ResourceLimits struct {
+	// Maximum size of each process' virtual memory, in bytes (RLIMIT_AS).
+	AddressSpace uint64
+
+	// Maximum number of open file descriptors per process (RLIMIT_NOFILE).
+	OpenFiles uint64
+
+	// Maximum size of core dumps, in bytes (RLIMIT_CORE).
+	CoreSize uint64
+
+	// If true, prevent core dumps entirely. Takes precedence over CoreSize.
+	DisableCoreDumps bool
+
+	// Maximum CPU time of each process, in seconds (RLIMIT_CPU).
+	CPUSeconds uint64
+
+	// Maximum number of processes (RLIMIT_NPROC). Note that the kernel
+	// counts every process of the user, not just those of the launch.
+	MaxProcesses uint64
+}
+
+// CrashParams configures crash bundles. When the main process dies from
+// SIGSEGV, SIGABRT, SIGBUS or SIGILL, a directory with what a bug report
+// needs is written: exit details, the last lines of stderr, the launch
+// parameters with secrets redacted, the sandbox backend and its generated
+// policy, the session log if any, and optionally a core dump. Its path is
+// reported in ExitResult.CrashBundle.
+type CrashParams struct {
+	Enabled bool
+
+	// Directory crash bundles are written to. Defaults to
+	// {InstallFolder}/.itch/crashes.
+	Dir string
+
+	// Number of last stderr lines included. Defaults to 100.
+	StderrLines int
+
+	// If true, RLIMIT_CORE is raised so that the crashing process dumps
+	// core, and the core dump is moved from the working directory into the
+	// bundle. Linux only, and ignored if ResourceLimits sets a core size or
+	// disables core dumps. Whether cores are written to the working
+	// directory depends on the kernel's core_pattern: many distributions
+	// pipe them to systemd-coredump or apport instead.
+	CoreDumps bool
+
+	// Maximum size of core dumps, in bytes. Zero means as large as the
+	// launcher's hard limit allows.
+	MaxCoreSize uint64
 }
 
 type FirejailParams struct {
@@ -83,6 +290,43 @@ type BubblewrapParams struct {
 	BinaryPath string
 }
 
+// SystemdParams configures launching through systemd-run, in a transient
+// unit of the user's service manager. The unit gets the limits of
+// CgroupParams, whether or not it is enabled, and systemd tracks and
+// cleans up every process of the launch instead of the launcher.
+type SystemdParams struct {
+	// If true, launches that aren't sandboxed go through systemd-run too.
+	// Sandboxed launches only do with SandboxTypeSystemd.
+	Enabled bool
+
+	// Path to systemd-run. systemctl is expected next to it.
+	BinaryPath string
+
+	// If true, run the game in a transient scope instead of a service. It
+	// then stays a child of the launcher, with its environment and
+	// working directory, but none of the sandboxing properties apply.
+	// Sandboxed launches always use a service.
+	Scope bool
+}
+
+// OCIParams configures the oci sandbox, which runs launches as rootless
+// containers from a generated runtime bundle.
+type OCIParams struct {
+	// Path to an OCI runtime: crun or runc.
+	BinaryPath string
+}
+
+// FlatpakParams configures the flatpak sandbox, used when the launcher
+// itself runs as a Flatpak.
+type FlatpakParams struct {
+	// Path to flatpak-spawn. Defaults to /usr/bin/flatpak-spawn.
+	BinaryPath string
+}
+
+type NsjailParams struct {
+	BinaryPath string
+}
+
 type FujiParams struct {
 	Settings             *fuji.Settings
 	PerformElevatedSetup func() error
@@ -94,7 +338,11 @@ type AttachParams struct {
 
 type Runner interface {
 	Prepare() error
-	Run() error
+
+	// Run launches the process and blocks until it exits or RunnerParams.Ctx
+	// is cancelled. The returned ExitResult is non-nil whenever the process
+	// was started, even if err is also non-nil (e.g. on a non-zero exit code).
+	Run() (*ExitResult, error)
 }
 
 func GetRunner(params RunnerParams) (Runner, error) {
@@ -121,20 +369,37 @@ func GetRunner(params RunnerParams) (Runner, error) {
 		return newSimpleRunner(params)
 	case "linux":
 		if params.Sandbox {
-			switch params.SandboxConfig.Type {
-			case SandboxTypeAuto:
-				if params.BubblewrapParams.BinaryPath != "" {
-					return newBubblewrapRunner(params)
+			sandboxType := params.SandboxConfig.Type
+			if sandboxType == SandboxTypeAuto {
+				sandboxType, err = chooseSandbox(params)
+				if err != nil {
+					return nil, err
 				}
-				return newFirejailRunner(params)
+			}
+			switch sandboxType {
 			case SandboxTypeBubblewrap:
 				return newBubblewrapRunner(params)
 			case SandboxTypeFirejail:
 				return newFirejailRunner(params)
+			case SandboxTypeNative:
+				return newNativeRunner(params)
+			case SandboxTypeLandlock:
+				return newLandlockRunner(params)
+			case SandboxTypeSystemd:
+				return newSystemdRunner(params)
+			case SandboxTypeOCI:
+				return newOCIRunner(params)
+			case SandboxTypeFlatpak:
+				return newFlatpakRunner(params)
+			case SandboxTypeNsjail:
+				return newNsjailRunner(params)
 			default:
 				return nil, fmt.Errorf("sandbox type %q is not supported on linux", params.SandboxConfig.Type)
 			}
 		}
+		if params.SystemdParams.Enabled {
+			return newSystemdRunner(params)
+		}
 		return newSimpleRunner(params)
 	case "darwin":
 		if params.Sandbox {
@@ -150,3 +415,28 @@ func GetRunner(params RunnerParams) (Runner, error) {
 
 	return nil, fmt.Errorf("unsupported OS: %s", runtime.GOOS)
 }
+
+// chooseSandbox picks the sandbox for SandboxTypeAuto, the first that
+// works of Flatpak's (inside Flatpak, where namespaces can't be nested),
+// bubblewrap, firejail and the native sandbox. Configured sandboxes that
+// are passed over are warned about, with the reasons ProbeSandboxes found.
+func chooseSandbox(params RunnerParams) (SandboxType, error) {
+	consumer := params.Consumer
+
+	report := ProbeSandboxes(params)
+	consumer.Debugf("Sandbox probe:\n%s", report)
+	best, ok := report.Best()
+	if !ok {
+		return "", fmt.Errorf("no sandbox works on this system:\n%s", report)
+	}
+	for _, probe := range report.Sandboxes {
+		if probe.Type == best.Type {
+			break
+		}
+		if probe.BinaryPath != "" {
+			consumer.Warnf("Not using the %s sandbox: %s", probe.Type, strings.Join(probe.Reasons, "; "))
+		}
+	}
+	consumer.Infof("Using the %s sandbox (%s)", best.Type, strings.Join(best.Reasons, "; "))
+	return best.Type, nil
+}
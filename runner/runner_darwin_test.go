@@ -50,5 +50,6 @@ func TestAppBundle(t *testing.T) {
 
 	// open -W does not relay stdout/stderr, so we can only verify
 	// that the bundle launches and exits without error.
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 }
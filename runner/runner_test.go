@@ -5,11 +5,14 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync"
+	"syscall"
 	"testing"
 	"time"
 
@@ -72,7 +75,8 @@ func TestBasicExecution(t *testing.T) {
 
 	require.NoError(t, r.Prepare())
 
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	assert.Equal(t, "hello\n", stdout.String())
 }
@@ -85,7 +89,8 @@ func TestArgumentPassing(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
 	require.Len(t, lines, 3)
@@ -106,7 +111,8 @@ func TestEnvironmentVariables(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
 	require.Len(t, lines, 2)
@@ -123,12 +129,88 @@ func TestStdoutStderr(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	assert.Equal(t, "out-msg\n", stdout.String())
 	assert.Equal(t, "err-msg\n", stderr.String())
 }
 
+func TestOutputLines(t *testing.T) {
+	var stdout bytes.Buffer
+	var lines []runner.OutputLine
+	params := newTestParams(t, "output", "stdout", "out-msg", "stderr", "err-msg")
+	params.Stdout = &stdout
+	params.OnOutputLine = func(line runner.OutputLine) {
+		lines = append(lines, line)
+	}
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+	_, err = r.Run()
+	require.NoError(t, err)
+
+	assert.Equal(t, "out-msg\n", strings.ReplaceAll(stdout.String(), "\r\n", "\n"))
+
+	// Each stream is read by its own goroutine, so only the per-stream
+	// order of lines is guaranteed.
+	require.Len(t, lines, 2)
+	texts := map[runner.OutputStream]string{}
+	for i, line := range lines {
+		assert.Equal(t, uint64(i+1), line.Seq)
+		texts[line.Stream] = line.Text
+	}
+	assert.Equal(t, map[runner.OutputStream]string{
+		runner.OutputStdout: "out-msg",
+		runner.OutputStderr: "err-msg",
+	}, texts)
+}
+
+func TestStdin(t *testing.T) {
+	var stdout bytes.Buffer
+	params := newTestParams(t, "cat")
+	params.Stdin = strings.NewReader("go north\nlook\n")
+	params.Stdout = &stdout
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	_, err = r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, "go north\nlook\n", stdout.String())
+}
+
+func TestStdinThatNeverEnds(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("stdin copying can't be cut short on Windows")
+	}
+
+	pr, pw := io.Pipe()
+	defer pw.Close()
+
+	params := newTestParams(t, "exit", "0")
+	params.Stdin = pr
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.Run()
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return within 5 seconds of the process exiting")
+	}
+}
+
 func TestWorkingDirectory(t *testing.T) {
 	var stdout bytes.Buffer
 	dir := t.TempDir()
@@ -139,7 +221,8 @@ func TestWorkingDirectory(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	// On macOS, /tmp may resolve to /private/tmp via symlink
 	got, err := filepath.EvalSymlinks(strings.TrimSpace(stdout.String()))
@@ -156,7 +239,14 @@ func TestExitCodeZero(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
 
-	assert.NoError(t, r.Run())
+	res, err := r.Run()
+	assert.NoError(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.True(t, res.Success())
+	assert.False(t, res.Cancelled)
+	assert.Equal(t, runner.BackendSimple, res.Backend)
+	assert.Greater(t, res.Duration, time.Duration(0))
 }
 
 func TestExitCodeNonZero(t *testing.T) {
@@ -166,7 +256,10 @@ func TestExitCodeNonZero(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
 
-	err = r.Run()
+	res, err := r.Run()
+	require.NotNil(t, res)
+	assert.Equal(t, 42, res.ExitCode)
+	assert.False(t, res.Success())
 
 	if runtime.GOOS == "windows" {
 		// On Windows, the job object completion port reports success
@@ -181,6 +274,67 @@ func TestExitCodeNonZero(t *testing.T) {
 	}
 }
 
+func TestLogCapture(t *testing.T) {
+	var stderr bytes.Buffer
+	params := newTestParams(t, "fail", "3", "loading level", "out of cheese", "giving up")
+	params.Stderr = &stderr
+	params.InstallFolder = t.TempDir()
+	params.LogParams = runner.LogParams{
+		Enabled:   true,
+		TailLines: 2,
+	}
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	res, err := r.Run()
+	require.NotNil(t, res)
+	assert.Equal(t, 3, res.ExitCode)
+
+	output := "loading level\nout of cheese\ngiving up\n"
+	assert.Equal(t, output, strings.ReplaceAll(stderr.String(), "\r\n", "\n"))
+
+	assert.Equal(t, filepath.Join(params.InstallFolder, ".itch", "logs"), filepath.Dir(res.LogPath))
+	logBytes, lerr := os.ReadFile(res.LogPath)
+	require.NoError(t, lerr)
+	assert.Equal(t, output, strings.ReplaceAll(string(logBytes), "\r\n", "\n"))
+
+	if runtime.GOOS == "windows" {
+		// Non-zero exit codes aren't reported as errors on Windows, see
+		// TestExitCodeNonZero.
+		return
+	}
+	var tailErr *runner.LogTailError
+	require.True(t, errors.As(err, &tailErr), "expected *runner.LogTailError, got %T: %v", err, err)
+	assert.Equal(t, res.LogPath, tailErr.LogPath)
+	assert.Equal(t, []string{"out of cheese", "giving up"}, tailErr.Tail)
+
+	var exitErr *exec.ExitError
+	require.True(t, errors.As(err, &exitErr))
+	assert.Equal(t, 3, exitErr.ExitCode())
+}
+
+func TestExitResultReportsSignal(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("signals are not reported on Windows")
+	}
+
+	params := newTestParams(t, "kill-self")
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	res, err := r.Run()
+	require.Error(t, err)
+	require.NotNil(t, res)
+	assert.Equal(t, -1, res.ExitCode)
+	assert.Equal(t, syscall.SIGKILL, res.Signal)
+	assert.False(t, res.Cancelled)
+	assert.Contains(t, res.String(), "SIGKILL")
+}
+
 func TestContextCancellation(t *testing.T) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
@@ -192,9 +346,10 @@ func TestContextCancellation(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
 
-	done := make(chan error, 1)
+	done := make(chan *runner.ExitResult, 1)
 	go func() {
-		done <- r.Run()
+		res, _ := r.Run()
+		done <- res
 	}()
 
 	// Give the process time to start
@@ -202,13 +357,180 @@ func TestContextCancellation(t *testing.T) {
 	cancel()
 
 	select {
-	case <-done:
+	case res := <-done:
 		// Run returned promptly after cancellation
+		require.NotNil(t, res)
+		assert.True(t, res.Cancelled)
+		assert.False(t, res.Success())
+		assert.False(t, res.Escalated)
+		if runtime.GOOS != "windows" {
+			assert.Equal(t, syscall.SIGTERM, res.Signal)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return within 5 seconds after context cancellation")
+	}
+}
+
+func TestShutdownEscalatesToSIGKILL(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("shutdown policy is not used on Windows")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	params := newTestParams(t, "ignore-term")
+	params.Ctx = ctx
+	params.ShutdownPolicy = runner.ShutdownPolicy{
+		GracePeriod: 300 * time.Millisecond,
+		KillTimeout: 2 * time.Second,
+	}
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	done := make(chan *runner.ExitResult, 1)
+	go func() {
+		res, _ := r.Run()
+		done <- res
+	}()
+
+	time.Sleep(200 * time.Millisecond)
+	cancel()
+
+	select {
+	case res := <-done:
+		require.NotNil(t, res)
+		assert.True(t, res.Cancelled)
+		assert.True(t, res.Escalated)
+		assert.Equal(t, syscall.SIGKILL, res.Signal)
 	case <-time.After(5 * time.Second):
 		t.Fatal("Run() did not return within 5 seconds after context cancellation")
 	}
 }
 
+func TestWallClockTimeLimit(t *testing.T) {
+	var mu sync.Mutex
+	var warnings []runner.TimeLimitKind
+
+	params := newTestParams(t, "sleep", "30000")
+	params.TimeLimits = runner.TimeLimits{
+		WallClock:  600 * time.Millisecond,
+		WarnBefore: 400 * time.Millisecond,
+		OnWarning: func(kind runner.TimeLimitKind, remaining time.Duration) {
+			mu.Lock()
+			defer mu.Unlock()
+			warnings = append(warnings, kind)
+			assert.Equal(t, 400*time.Millisecond, remaining)
+		},
+	}
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	done := make(chan *runner.ExitResult, 1)
+	go func() {
+		res, _ := r.Run()
+		done <- res
+	}()
+
+	select {
+	case res := <-done:
+		require.NotNil(t, res)
+		assert.Equal(t, runner.TimeLimitWallClock, res.TimeLimit)
+		assert.False(t, res.Cancelled)
+		assert.False(t, res.Success())
+		assert.GreaterOrEqual(t, res.Duration, 600*time.Millisecond)
+		assert.Contains(t, res.String(), "time limit")
+	case <-time.After(5 * time.Second):
+		t.Fatal("Run() did not return within 5 seconds after the time limit")
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, []runner.TimeLimitKind{runner.TimeLimitWallClock}, warnings)
+}
+
+func TestStartHandle(t *testing.T) {
+	params := newTestParams(t, "sleep", "30000")
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	starter, ok := r.(runner.Starter)
+	require.True(t, ok, "expected %T to implement runner.Starter", r)
+
+	h, err := starter.Start()
+	require.NoError(t, err)
+	assert.Greater(t, h.Pid(), 0)
+
+	select {
+	case <-h.Done():
+		t.Fatal("Done() closed before the process exited")
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	require.NoError(t, h.Kill())
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit within 5 seconds after Kill()")
+	}
+
+	res, _ := h.Wait()
+	require.NotNil(t, res)
+	assert.False(t, res.Cancelled)
+	assert.False(t, res.Success())
+	if runtime.GOOS != "windows" {
+		assert.Equal(t, syscall.SIGKILL, res.Signal)
+	}
+
+	assert.ErrorIs(t, h.Signal(syscall.SIGTERM), os.ErrProcessDone)
+}
+
+func TestPauseResume(t *testing.T) {
+	params := newTestParams(t, "sleep", "30000")
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+	require.NoError(t, r.Prepare())
+
+	starter, ok := r.(runner.Starter)
+	require.True(t, ok, "expected %T to implement runner.Starter", r)
+
+	h, err := starter.Start()
+	require.NoError(t, err)
+
+	if runtime.GOOS == "windows" {
+		assert.Error(t, h.Pause())
+		require.NoError(t, h.Kill())
+		h.Wait()
+		return
+	}
+
+	require.NoError(t, h.Pause())
+	time.Sleep(400 * time.Millisecond)
+	require.NoError(t, h.Resume())
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, h.Kill())
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("process did not exit within 5 seconds after Kill()")
+	}
+
+	res, _ := h.Wait()
+	require.NotNil(t, res)
+	assert.GreaterOrEqual(t, res.Paused, 400*time.Millisecond)
+	assert.Less(t, res.Duration, 400*time.Millisecond)
+	assert.ErrorIs(t, h.Pause(), os.ErrProcessDone)
+}
+
 func skipIfNoBubblewrap(t *testing.T) string {
 	t.Helper()
 	if runtime.GOOS != "linux" {
@@ -255,7 +577,8 @@ func TestBubblewrapBasicExecution(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	assert.Equal(t, "hello\n", stdout.String())
 }
@@ -270,7 +593,8 @@ func TestBubblewrapArgumentPassing(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
 	require.Len(t, lines, 3)
@@ -290,7 +614,8 @@ func TestBubblewrapStdoutStderr(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	assert.Equal(t, "out-msg\n", stdout.String())
 	assert.Equal(t, "err-msg\n", stderr.String())
@@ -310,7 +635,8 @@ func TestBubblewrapClearsUnlistedEnvironmentVariables(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
 	require.Len(t, lines, 2)
@@ -348,7 +674,8 @@ func TestBubblewrapForwardsItchioAndTempEnvironmentVariables(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	_, err = r.Run()
+	require.NoError(t, err)
 
 	lines := strings.Split(strings.TrimSuffix(stdout.String(), "\n"), "\n")
 	require.Len(t, lines, 7)
@@ -374,18 +701,25 @@ func TestBubblewrapContextCancellation(t *testing.T) {
 	require.NoError(t, err)
 	require.NoError(t, r.Prepare())
 
-	done := make(chan error, 1)
+	type runOutcome struct {
+		res *runner.ExitResult
+		err error
+	}
+	done := make(chan runOutcome, 1)
 	go func() {
-		done <- r.Run()
+		res, err := r.Run()
+		done <- runOutcome{res, err}
 	}()
 
 	time.Sleep(200 * time.Millisecond)
 	cancel()
 
 	select {
-	case err := <-done:
+	case outcome := <-done:
 		// Run returned promptly after cancellation and did not fail before cancellation.
-		require.NoError(t, err)
+		require.NoError(t, outcome.err)
+		assert.True(t, outcome.res.Cancelled)
+		assert.Equal(t, runner.BackendBubblewrap, outcome.res.Backend)
 	case <-time.After(5 * time.Second):
 		t.Fatal("Run() did not return within 5 seconds after context cancellation")
 	}
@@ -396,13 +730,22 @@ func TestBubblewrapSelectionPriority(t *testing.T) {
 		t.Skip("runner selection test only relevant on Linux")
 	}
 
+	// Auto selection only picks sandboxes that pass a trial run, which
+	// these do.
+	fakeBinary := func(name string, version string) string {
+		path := filepath.Join(t.TempDir(), name)
+		script := fmt.Sprintf("#!/bin/sh\nif [ \"$1\" = --version ]; then echo %q; fi\n", version)
+		require.NoError(t, os.WriteFile(path, []byte(script), 0o755))
+		return path
+	}
+
 	params := newTestParams(t)
 	params.Sandbox = true
 	params.BubblewrapParams = runner.BubblewrapParams{
-		BinaryPath: "/usr/bin/bwrap",
+		BinaryPath: fakeBinary("bwrap", "bubblewrap 0.9.0"),
 	}
 	params.FirejailParams = runner.FirejailParams{
-		BinaryPath: "/usr/bin/firejail",
+		BinaryPath: fakeBinary("firejail", "firejail version 0.9.72"),
 	}
 
 	r, err := runner.GetRunner(params)
@@ -413,6 +756,22 @@ func TestBubblewrapSelectionPriority(t *testing.T) {
 	assert.Contains(t, typeName, "bubblewrap", "expected bubblewrap runner when both are configured")
 }
 
+func TestNativeSelectionWithoutBinaries(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("runner selection test only relevant on Linux")
+	}
+
+	params := newTestParams(t)
+	params.Sandbox = true
+
+	r, err := runner.GetRunner(params)
+	require.NoError(t, err)
+
+	// Without bwrap or firejail, the native sandbox should be chosen
+	typeName := fmt.Sprintf("%T", r)
+	assert.Contains(t, typeName, "native", "expected native runner when no binary is configured")
+}
+
 func TestUnsupportedSandboxTypeOnLinuxReturnsError(t *testing.T) {
 	if runtime.GOOS != "linux" {
 		t.Skip("runner selection test only relevant on Linux")
@@ -439,7 +798,7 @@ func TestInvalidExecutable(t *testing.T) {
 	r, err := runner.GetRunner(params)
 	if err == nil {
 		if err = r.Prepare(); err == nil {
-			err = r.Run()
+			_, err = r.Run()
 		}
 	}
 	require.Error(t, err, "expected an error for nonexistent executable")
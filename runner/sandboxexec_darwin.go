@@ -3,18 +3,15 @@
 package runner
 
 import (
-	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
-	"text/template"
 	"time"
 
 	"github.com/itchio/ox/macox"
-	"github.com/itchio/smaug/runner/policies"
 )
 
 var investigateSandbox = os.Getenv("INVESTIGATE_SANDBOX") == "1"
@@ -30,13 +27,6 @@ const (
 	sandboxExecPolicyModeLegacy   sandboxExecPolicyMode = "legacy"
 )
 
-type sandboxExecPolicyData struct {
-	UserLibrary         string
-	InstallLocation     string
-	AllowNetwork        bool
-	LegacyCompatibility bool
-}
-
 type sandboxExecRunner struct {
 	params          RunnerParams
 	target          *MacLaunchTarget
@@ -117,36 +107,18 @@ func (ser *sandboxExecRunner) WriteSandboxProfile() error {
 		return fmt.Errorf("%w", err)
 	}
 
-	userLibrary, err := macox.GetLibraryPath()
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
 	mode, rawMode, validMode := sandboxExecPolicyModeFromConfig(params.SandboxConfig.PolicyMode)
 	if !validMode {
 		consumer.Warnf("Unknown SandboxConfig.PolicyMode value (%s), defaulting to (%s)", rawMode, sandboxExecPolicyModeBalanced)
 	}
 	ser.policyMode = mode
 
-	sandboxTemplate, err := template.New("sandboxexec-profile").Parse(policies.SandboxExecTemplate)
+	policy, err := launchSandboxPolicy(params)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
 
-	sandboxData := sandboxExecPolicyData{
-		UserLibrary:         escapeSBPLString(userLibrary),
-		InstallLocation:     escapeSBPLString(params.InstallFolder),
-		AllowNetwork:        !params.SandboxConfig.NoNetwork,
-		LegacyCompatibility: mode == sandboxExecPolicyModeLegacy,
-	}
-
-	var sandboxBuf bytes.Buffer
-	err = sandboxTemplate.Execute(&sandboxBuf, sandboxData)
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	err = os.WriteFile(sandboxProfilePath, sandboxBuf.Bytes(), 0644)
+	err = os.WriteFile(sandboxProfilePath, []byte(sandboxExecProfile(policy)), 0644)
 	if err != nil {
 		return fmt.Errorf("%w", err)
 	}
@@ -163,23 +135,27 @@ func (ser *sandboxExecRunner) logSandboxFailure(err error) {
 	}
 }
 
-func (ser *sandboxExecRunner) Run() error {
+func (ser *sandboxExecRunner) Run() (*ExitResult, error) {
 	params := ser.params
 	consumer := params.Consumer
 
 	if ser.sandboxExecPath == "" {
 		err := ser.Prepare()
 		if err != nil {
-			return fmt.Errorf("%w", err)
+			return nil, fmt.Errorf("%w", err)
 		}
 	}
 
 	err := ser.WriteSandboxProfile()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	sandboxEnv := collectAllowedEnvDarwin(params.Env, os.Environ(), params.SandboxConfig.AllowEnv)
+	policy, err := launchSandboxPolicy(params)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	sandboxEnv := policy.Env.environ(params.Env, os.Environ())
 
 	if !ser.target.IsAppBundle {
 		consumer.Infof("Dealing with naked executable, launching via sandbox-exec directly")
@@ -197,20 +173,23 @@ func (ser *sandboxExecRunner) Run() error {
 		simpleParams.Env = sandboxEnv
 		simpleRunner, err := newSimpleRunnerForSandboxExec(simpleParams)
 		if err != nil {
-			return fmt.Errorf("%w", err)
+			return nil, fmt.Errorf("%w", err)
 		}
 
 		err = simpleRunner.Prepare()
 		if err != nil {
-			return fmt.Errorf("%w", err)
+			return nil, fmt.Errorf("%w", err)
 		}
 
-		err = simpleRunner.Run()
+		res, err := simpleRunner.Run()
+		if res != nil {
+			res.Backend = BackendSandboxExec
+		}
 		if err != nil {
 			ser.logSandboxFailure(err)
-			return fmt.Errorf("%w", err)
+			return res, fmt.Errorf("%w", err)
 		}
-		return nil
+		return res, nil
 	}
 
 	consumer.Infof("Creating shim app bundle to enable sandboxing")
@@ -218,13 +197,13 @@ func (ser *sandboxExecRunner) Run() error {
 
 	binaryPath, err := macox.GetExecutablePath(realBundlePath)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 	binaryName := filepath.Base(binaryPath)
 
 	workDir, err := os.MkdirTemp("", "butler-shim-bundle")
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 	defer os.RemoveAll(workDir)
 
@@ -242,7 +221,7 @@ func (ser *sandboxExecRunner) Run() error {
 	)
 	err = os.MkdirAll(filepath.Dir(shimBinaryPath), 0755)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	var shimLines []string
@@ -259,7 +238,7 @@ func (ser *sandboxExecRunner) Run() error {
 
 	err = os.WriteFile(shimBinaryPath, []byte(shimBinaryContents), 0744)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	err = os.Symlink(
@@ -267,7 +246,7 @@ func (ser *sandboxExecRunner) Run() error {
 		filepath.Join(shimBundlePath, "Contents", "Resources"),
 	)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	err = os.Symlink(
@@ -275,7 +254,7 @@ func (ser *sandboxExecRunner) Run() error {
 		filepath.Join(shimBundlePath, "Contents", "Info.plist"),
 	)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	if investigateSandbox {
@@ -290,13 +269,16 @@ func (ser *sandboxExecRunner) Run() error {
 	sandboxParams := params
 	sandboxParams.Env = sandboxEnv
 
-	err = runAppBundleForSandboxExec(
+	res, err := runAppBundleForSandboxExec(
 		sandboxParams,
 		shimBundlePath,
 	)
+	if res != nil {
+		res.Backend = BackendSandboxExec
+	}
 	if err != nil {
 		ser.logSandboxFailure(err)
-		return fmt.Errorf("%w", err)
+		return res, fmt.Errorf("%w", err)
 	}
-	return nil
+	return res, nil
 }
@@ -25,9 +25,12 @@ func (cr *capturingRunner) Prepare() error {
 	return nil
 }
 
-func (cr *capturingRunner) Run() error {
+func (cr *capturingRunner) Run() (*ExitResult, error) {
 	cr.runCalled = true
-	return nil
+	res := newExitResult()
+	res.ExitCode = 0
+	res.Backend = BackendSimple
+	return res, nil
 }
 
 func parseEnvironmentOutput(output []string) map[string]string {
@@ -105,7 +108,9 @@ func TestSandboxExecRunNakedExecutableUsesSandboxExecBinaryAndFilteredEnv(t *tes
 	require.NoError(t, err)
 
 	require.NoError(t, r.Prepare())
-	require.NoError(t, r.Run())
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, BackendSandboxExec, res.Backend)
 
 	require.True(t, fakeRunner.prepareCalled)
 	require.True(t, fakeRunner.runCalled)
@@ -234,3 +239,31 @@ func TestCollectAllowedEnvDarwinParamsEmptyOverridesHostExtraKey(t *testing.T) {
 	assert.Contains(t, got, "SMAUG_EXTRA_ENV=")
 	assert.NotContains(t, got, "SMAUG_EXTRA_ENV=host")
 }
+
+func TestSandboxExecProfileFromPolicy(t *testing.T) {
+	installFolder := t.TempDir()
+	saveFile := filepath.Join(installFolder, "save.dat")
+	require.NoError(t, os.WriteFile(saveFile, nil, 0644))
+
+	policy := &SandboxPolicy{
+		Filesystem: []FilesystemRule{
+			{Path: "/usr/lib", Access: FilesystemReadOnly},
+			{Path: installFolder, Access: FilesystemReadWrite},
+			{Path: saveFile, Access: FilesystemReadWrite},
+			{Path: filepath.Join(installFolder, ".itch"), Access: FilesystemDeny},
+			{Path: "/scratch", Access: FilesystemTmpfs},
+		},
+		Network: NetworkNone,
+	}
+
+	profileText := sandboxExecProfile(policy)
+	assert.True(t, strings.HasPrefix(profileText, "(version 1)\n(deny default)\n"))
+	assert.Contains(t, profileText, "(allow file-read*\n  (subpath \"/usr/lib\")\n)")
+	assert.Contains(t, profileText, "(allow file*\n  (subpath \""+installFolder+"\")\n  (literal \""+saveFile+"\")\n)")
+	assert.Contains(t, profileText, "(deny file*\n  (subpath \""+filepath.Join(installFolder, ".itch")+"\")\n)")
+	assert.NotContains(t, profileText, "/scratch")
+	assert.NotContains(t, profileText, "(allow network-outbound)")
+
+	policy.Network = NetworkHost
+	assert.Contains(t, sandboxExecProfile(policy), "(allow network-outbound)")
+}
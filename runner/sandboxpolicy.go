@@ -0,0 +1,139 @@
+package runner
+
+import "slices"
+
+// SandboxPolicy describes what a sandboxed launch may access, whichever
+// backend sandboxes it. Each backend compiles it to its own command line or
+// profile (bwrap options, a firejail profile, a sandbox-exec profile...),
+// enforcing what it can of it. DefaultSandboxPolicy returns the policy
+// launches get unless SandboxConfig.Policy is set.
+type SandboxPolicy struct {
+	// Paths the sandbox may access. Rules apply in order: a later rule
+	// overrides earlier ones for the paths it covers, so that e.g. a folder
+	// can be exposed inside a denied one. Backends that mount paths skip
+	// read-only rules for paths that don't exist.
+	Filesystem []FilesystemRule
+
+	// Device nodes exposed besides the basic ones (null, zero, random,
+	// urandom, tty...). Linux only.
+	Devices []DeviceClass
+
+	// Display, audio and session bus sockets exposed. Linux only.
+	Sockets []IPCSocket
+
+	// Environment of the sandbox.
+	Env EnvPolicy
+
+	Network NetworkMode
+
+	// Namespaces the sandbox gets, besides a user and mount namespace,
+	// and a network namespace for NetworkNone. Linux only.
+	Namespaces NamespacePolicy
+}
+
+// withConfig returns a copy of policy that also disables the network if
+// config.NoNetwork is set, and lets config.AllowEnv through.
+func (policy *SandboxPolicy) withConfig(config SandboxConfig) *SandboxPolicy {
+	out := *policy
+	if config.NoNetwork {
+		out.Network = NetworkNone
+	}
+	if len(config.AllowEnv) > 0 {
+		out.Env.Allow = append(slices.Clip(policy.Env.Allow), config.AllowEnv...)
+	}
+	return &out
+}
+
+// FilesystemAccess is what a FilesystemRule allows.
+type FilesystemAccess string
+
+const (
+	FilesystemReadOnly  FilesystemAccess = "ro"
+	FilesystemReadWrite FilesystemAccess = "rw"
+	// An empty, writable tmpfs, discarded with the sandbox. Only backends
+	// that mount paths support it.
+	FilesystemTmpfs FilesystemAccess = "tmpfs"
+	// Hidden, even inside an exposed folder.
+	FilesystemDeny FilesystemAccess = "deny"
+)
+
+// FilesystemRule gives a path an access mode in the sandbox.
+type FilesystemRule struct {
+	// Absolute path, as seen from the sandbox.
+	Path string
+
+	// Host path mounted at Path, if it isn't Path itself, e.g. for the
+	// per-game home. Backends that can't mount a path elsewhere expose
+	// Source as is instead, or skip the rule. Only for FilesystemReadOnly
+	// and FilesystemReadWrite.
+	Source string
+
+	Access FilesystemAccess
+}
+
+// hostPath returns the path on the host the rule is about.
+func (rule FilesystemRule) hostPath() string {
+	if rule.Source != "" {
+		return rule.Source
+	}
+	return rule.Path
+}
+
+// DeviceClass is a group of device nodes.
+type DeviceClass string
+
+const (
+	// /dev/dri and /dev/nvidia*
+	DeviceGPU DeviceClass = "gpu"
+	// /dev/input, for controllers
+	DeviceInput DeviceClass = "input"
+	// /dev/snd, for ALSA
+	DeviceSound DeviceClass = "sound"
+)
+
+// IPCSocket is a session service reached through a socket.
+type IPCSocket string
+
+const (
+	// /tmp/.X11-unix and the X authority file.
+	SocketX11 IPCSocket = "x11"
+	// $XDG_RUNTIME_DIR/$WAYLAND_DISPLAY
+	SocketWayland IPCSocket = "wayland"
+	// $XDG_RUNTIME_DIR/pulse
+	SocketPulseAudio IPCSocket = "pulseaudio"
+	// $XDG_RUNTIME_DIR/pipewire-0
+	SocketPipeWire IPCSocket = "pipewire"
+	// The socket of $DBUS_SESSION_BUS_ADDRESS, or $XDG_RUNTIME_DIR/bus.
+	SocketDBusSession IPCSocket = "dbus-session"
+)
+
+// EnvPolicy decides which environment variables reach the sandbox.
+type EnvPolicy struct {
+	// Variables passed through, from RunnerParams.Env or else from the
+	// launcher's environment. Others are cleared.
+	Allow []string
+}
+
+// NetworkMode is the network access of the sandbox.
+type NetworkMode string
+
+const (
+	// The host's network is shared.
+	NetworkHost NetworkMode = ""
+	// Only loopback is available.
+	NetworkNone NetworkMode = "none"
+)
+
+// NamespacePolicy lists the optional namespaces of the sandbox.
+type NamespacePolicy struct {
+	// Processes outside the sandbox are invisible, and /proc is the
+	// sandbox's own.
+	PID bool
+
+	// The hostname is the sandbox's own.
+	UTS bool
+
+	// System V IPC and POSIX message queues are the sandbox's own. Breaks
+	// X11's MIT-SHM extension, which many games use.
+	IPC bool
+}
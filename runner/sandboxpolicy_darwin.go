@@ -0,0 +1,233 @@
+//go:build darwin
+
+package runner
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/itchio/ox/macox"
+)
+
+// DefaultSandboxPolicy returns the policy of a sandboxed launch with
+// params: read-only system folders and resources, read-write install folder
+// and ~/Library folders apps keep their data in, and the environment
+// allowed by SandboxConfig.AllowEnv. The itch app's data, browser data and
+// {InstallFolder}/.itch are denied. In the "legacy" SandboxConfig.PolicyMode,
+// all of /dev and /private are exposed too.
+func DefaultSandboxPolicy(params RunnerParams) (*SandboxPolicy, error) {
+	userLibrary, err := macox.GetLibraryPath()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	mode, _, _ := sandboxExecPolicyModeFromConfig(params.SandboxConfig.PolicyMode)
+	legacy := mode == sandboxExecPolicyModeLegacy
+
+	policy := &SandboxPolicy{
+		Env: EnvPolicy{
+			Allow: append(darwinSandboxEnvAllowlist(), params.SandboxConfig.AllowEnv...),
+		},
+	}
+	if params.SandboxConfig.NoNetwork {
+		policy.Network = NetworkNone
+	}
+
+	rule := func(path string, access FilesystemAccess) {
+		policy.Filesystem = append(policy.Filesystem, FilesystemRule{Path: path, Access: access})
+	}
+
+	for _, dir := range []string{
+		"Application Support",
+		"Preferences",
+		"Logs",
+		"Caches",
+		"KeyBindings",
+		"Saved Application State",
+		// Ren'Py games save to ~/Library/RenPy/<game_name> by default
+		// https://github.com/itchio/smaug/issues/7
+		"RenPy",
+	} {
+		rule(filepath.Join(userLibrary, dir), FilesystemReadWrite)
+	}
+
+	if legacy {
+		// Legacy mode keeps broad device access for compatibility.
+		rule("/dev", FilesystemReadWrite)
+	} else {
+		// Balanced mode limits device access to common low-risk nodes.
+		for _, path := range []string{"/dev/null", "/dev/random", "/dev/urandom"} {
+			rule(path, FilesystemReadWrite)
+		}
+	}
+	rule("/private/var/folders", FilesystemReadWrite)
+	rule("/var/folders", FilesystemReadWrite)
+
+	for _, dir := range []string{"itch", "kitch", "Google", "Mozilla"} {
+		rule(filepath.Join(userLibrary, "Application Support", dir), FilesystemDeny)
+	}
+
+	// Where the app is actually installed. Apps from other locations
+	// can't be scanned or accessed.
+	if params.InstallFolder != "" {
+		rule(params.InstallFolder, FilesystemReadWrite)
+		rule(filepath.Join(params.InstallFolder, ".itch"), FilesystemDeny)
+	}
+
+	for _, path := range []string{
+		// binaries & executables
+		"/usr/local",
+		"/usr/share",
+		"/usr/lib",
+		"/usr/bin",
+		"/bin",
+		"/System/Library",
+
+		// Rosetta 2 translation (required for x86_64 binaries on Apple Silicon)
+		"/usr/libexec/rosetta",
+		"/Library/Apple/usr/libexec/oah",
+		"/Library/Java/JavaVirtualMachines",
+	} {
+		rule(path, FilesystemReadOnly)
+	}
+	if legacy {
+		// Legacy mode keeps a very broad /private read for compatibility.
+		rule("/private", FilesystemReadOnly)
+	}
+	for _, path := range []string{
+		// preferences
+		"/etc",
+		"/private/etc",
+		"/Library/Preferences",
+
+		// resources
+		"/Library/Audio",
+		"/Library/Fonts",
+		filepath.Join(userLibrary, "Keyboard Layouts"),
+		filepath.Join(userLibrary, "Input Methods"),
+		filepath.Join(userLibrary, "Fonts"),
+
+		// FIXME that's a bit excessive, why are some apps
+		// trying to read 'PkgInfo' files or 'rsrc' ?
+		"/Applications",
+
+		// Chrome Helper
+		"/Library/Application Support/CrashReporter/SubmitDiagInfo.domains",
+	} {
+		rule(path, FilesystemReadOnly)
+	}
+
+	return policy, nil
+}
+
+// launchSandboxPolicy returns the policy a launch with params is sandboxed
+// with. SandboxConfig.NoNetwork and AllowEnv apply to custom policies too.
+func launchSandboxPolicy(params RunnerParams) (*SandboxPolicy, error) {
+	if params.SandboxConfig.Policy != nil {
+		return params.SandboxConfig.Policy.withConfig(params.SandboxConfig), nil
+	}
+	return DefaultSandboxPolicy(params)
+}
+
+// environ returns the allowed variables of paramsEnv, or of hostEnv for
+// those missing from paramsEnv, as "KEY=value".
+func (env EnvPolicy) environ(paramsEnv []string, hostEnv []string) []string {
+	out := make([]string, 0, len(env.Allow))
+	for _, key := range env.Allow {
+		if val, found := envLookupWithPresenceDarwin(paramsEnv, key); found {
+			out = append(out, key+"="+val)
+			continue
+		}
+		if val, found := envLookupWithPresenceDarwin(hostEnv, key); found {
+			out = append(out, key+"="+val)
+		}
+	}
+	return out
+}
+
+// sandboxExecProfile returns the SBPL profile enforcing policy. In SBPL,
+// the last matching rule wins, like in policies. Folders are matched with
+// subpath, and so are missing paths, since they may be created later. Only
+// the filesystem, network and environment parts of policies apply on
+// macOS, and tmpfs rules are skipped.
+//
+// Reference:
+// https://reverse.put.as/wp-content/uploads/2011/09/Apple-Sandbox-Guide-v1.0.pdf
+func sandboxExecProfile(policy *SandboxPolicy) string {
+	var b strings.Builder
+	b.WriteString("(version 1)\n(deny default)\n")
+
+	// Consecutive rules of the same kind are grouped.
+	var action string
+	for _, rule := range policy.Filesystem {
+		var next string
+		switch rule.Access {
+		case FilesystemReadOnly:
+			next = "allow file-read*"
+		case FilesystemReadWrite:
+			next = "allow file*"
+		case FilesystemDeny:
+			next = "deny file*"
+		default:
+			continue
+		}
+		if next != action {
+			if action != "" {
+				b.WriteString(")\n")
+			}
+			fmt.Fprintf(&b, "\n(%s\n", next)
+			action = next
+		}
+
+		filter := "subpath"
+		if info, err := os.Stat(rule.hostPath()); err == nil && !info.IsDir() {
+			filter = "literal"
+		}
+		fmt.Fprintf(&b, "  (%s \"%s\")\n", filter, escapeSBPLString(rule.hostPath()))
+	}
+	if action != "" {
+		b.WriteString(")\n")
+	}
+
+	b.WriteString(`
+(allow file-read*
+  (literal "/")
+)
+
+;; You'd be surprised what some apps scan for some reason
+(allow file-read-metadata)
+
+;; threads + launching other binaries
+(allow process-fork)
+(allow process-exec)
+
+;; probe hardware/OS limits? e.g. hw.pagesize_compat
+(allow sysctl-read)
+`)
+
+	if policy.Network != NetworkNone {
+		b.WriteString(`
+;; network
+(allow network-bind)
+(allow network-outbound)
+`)
+	}
+
+	b.WriteString(`
+;; (required by Electron/Chromium to load images, for example)
+(allow system-socket)
+
+;; (required by SDL2 app, was asking for 'com.apple.cfprefsd.daemon')
+(allow mach-lookup)
+(allow mach-register) ;; 'axserver, portname, CFPasteboardClient'
+
+;; Shared memory read-writes
+(allow ipc-posix*)
+
+;; ?? (required by SDL2 app)
+(allow iokit-open)
+`)
+
+	return b.String()
+}
@@ -0,0 +1,265 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// sandboxSystemPaths are mounted read-only in the sandbox.
+var sandboxSystemPaths = []string{"/usr", "/lib", "/lib64", "/bin", "/sbin", "/etc", "/sys"}
+
+// sandboxDeniedHomePaths are hidden from the sandbox, relative to the
+// user's home: the itch app's own data, browser profiles and credentials.
+var sandboxDeniedHomePaths = []string{
+	".config/itch",
+	".config/kitch",
+	".config/chromium",
+	".config/chrome",
+	".config/google-chrome",
+	".config/BraveSoftware",
+	".config/vivaldi",
+	".config/microsoft-edge",
+	".mozilla",
+	".ssh",
+	".gnupg",
+	".aws",
+	".kube",
+	".pki",
+	".git-credentials",
+	".netrc",
+	".password-store",
+	".local/share/keyrings",
+}
+
+// DefaultSandboxPolicy returns the policy of a sandboxed launch with
+// params: read-only system folders, a per-game home in
+// {InstallFolder}/.itch/home, read-write install folder, temp directory
+// and working directory, display, audio, GPU and controller access, and
+// the environment allowed by SandboxEnvAllowlist and SandboxConfig.AllowEnv.
+// Credentials, browser profiles, the itch app's data and
+// {InstallFolder}/.itch are denied.
+func DefaultSandboxPolicy(params RunnerParams) (*SandboxPolicy, error) {
+	policy := &SandboxPolicy{
+		Devices: []DeviceClass{DeviceGPU, DeviceInput, DeviceSound},
+		Sockets: []IPCSocket{SocketX11, SocketWayland, SocketPulseAudio, SocketPipeWire, SocketDBusSession},
+		Env: EnvPolicy{
+			Allow: append(SandboxEnvAllowlist(), params.SandboxConfig.AllowEnv...),
+		},
+		// IPC stays shared for X11's MIT-SHM.
+		Namespaces: NamespacePolicy{PID: true, UTS: true},
+	}
+	if params.SandboxConfig.NoNetwork {
+		policy.Network = NetworkNone
+	}
+
+	rule := func(path string, access FilesystemAccess) {
+		policy.Filesystem = append(policy.Filesystem, FilesystemRule{Path: path, Access: access})
+	}
+
+	for _, path := range sandboxSystemPaths {
+		rule(path, FilesystemReadOnly)
+	}
+	rule("/tmp", FilesystemTmpfs)
+
+	// Denied before the install folder is exposed, which is usually in
+	// ~/.config/itch.
+	if hostHome := os.Getenv("HOME"); filepath.IsAbs(hostHome) {
+		for _, path := range sandboxDeniedHomePaths {
+			rule(filepath.Join(hostHome, path), FilesystemDeny)
+		}
+	}
+
+	// Give sandboxed apps a persistent per-game home directory.
+	homeTarget, hasHome := envLookupWithPresence(params.Env, "HOME")
+	if !hasHome {
+		homeTarget = os.Getenv("HOME")
+	}
+	if params.InstallFolder != "" && filepath.IsAbs(homeTarget) {
+		policy.Filesystem = append(policy.Filesystem, FilesystemRule{
+			Path:   homeTarget,
+			Source: filepath.Join(params.InstallFolder, ".itch", "home"),
+			Access: FilesystemReadWrite,
+		})
+	}
+
+	for _, path := range []string{params.InstallFolder, params.TempDir, params.Dir} {
+		if path != "" {
+			rule(path, FilesystemReadWrite)
+		}
+	}
+	// The game couldn't be started otherwise. Usually already exposed.
+	if targetDir := filepath.Dir(params.FullTargetPath); filepath.IsAbs(targetDir) {
+		rule(targetDir, FilesystemReadOnly)
+	}
+	if params.InstallFolder != "" {
+		// Receipts, sandbox profiles and the per-game home.
+		rule(filepath.Join(params.InstallFolder, ".itch"), FilesystemDeny)
+	}
+
+	return policy, nil
+}
+
+// launchSandboxPolicy returns the policy a launch with params is sandboxed
+// with. SandboxConfig.NoNetwork and AllowEnv apply to custom policies too.
+func launchSandboxPolicy(params RunnerParams) *SandboxPolicy {
+	if params.SandboxConfig.Policy != nil {
+		return params.SandboxConfig.Policy.withConfig(params.SandboxConfig)
+	}
+	// It never fails on Linux.
+	policy, _ := DefaultSandboxPolicy(params)
+	return policy
+}
+
+// environ returns the allowed variables of paramsEnv, or of hostEnv for
+// those missing from paramsEnv, as "KEY=value".
+func (env EnvPolicy) environ(paramsEnv []string, hostEnv []string) []string {
+	out := make([]string, 0, len(env.Allow))
+	for _, key := range env.Allow {
+		if val, found := envLookupWithPresence(paramsEnv, key); found {
+			out = append(out, key+"="+val)
+			continue
+		}
+		if val, found := envLookupWithPresence(hostEnv, key); found {
+			out = append(out, key+"="+val)
+		}
+	}
+	return out
+}
+
+// isExposedBy returns true if the last rule of rules that covers path (a
+// sandbox path) exposes it as is, with at least access.
+func isExposedBy(rules []FilesystemRule, path string, access FilesystemAccess) bool {
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		if !isPathWithin(path, rule.Path) {
+			continue
+		}
+		if rule.Source != "" {
+			return false
+		}
+		switch rule.Access {
+		case FilesystemReadWrite:
+			return true
+		case FilesystemReadOnly:
+			return access == FilesystemReadOnly
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+// exposedAt returns where the host path is visible in the sandbox given
+// rules, if it is.
+func exposedAt(rules []FilesystemRule, hostPath string) (string, bool) {
+	for i := len(rules) - 1; i >= 0; i-- {
+		rule := rules[i]
+		switch rule.Access {
+		case FilesystemReadOnly, FilesystemReadWrite:
+			source := rule.hostPath()
+			if isPathWithin(hostPath, source) {
+				rel, err := filepath.Rel(source, hostPath)
+				if err != nil {
+					return "", false
+				}
+				return filepath.Join(rule.Path, rel), true
+			}
+		default:
+			if isPathWithin(hostPath, rule.Path) {
+				return "", false
+			}
+		}
+	}
+	return "", false
+}
+
+// devicePaths returns the device nodes of class present on the host.
+func devicePaths(class DeviceClass) []string {
+	var candidates []string
+	switch class {
+	case DeviceGPU:
+		candidates = []string{"/dev/dri"}
+		if nvidiaPaths, err := filepath.Glob("/dev/nvidia*"); err == nil {
+			candidates = append(candidates, nvidiaPaths...)
+		}
+	case DeviceInput:
+		candidates = []string{"/dev/input"}
+	case DeviceSound:
+		candidates = []string{"/dev/snd"}
+	}
+
+	var paths []string
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
+
+// socketPaths returns the host paths the socket is reached through, if they
+// exist. The session's environment is looked up in params.Env, and in the
+// launcher's environment if missing there.
+func socketPaths(params RunnerParams, socket IPCSocket) []string {
+	getenv := func(key string) string {
+		if val := envLookup(params.Env, key); val != "" {
+			return val
+		}
+		return os.Getenv(key)
+	}
+	xdgRuntimeDir := getenv("XDG_RUNTIME_DIR")
+
+	var candidates []string
+	switch socket {
+	case SocketX11:
+		candidates = append(candidates, "/tmp/.X11-unix")
+
+		// X11 authentication
+		xauthority := getenv("XAUTHORITY")
+		if xauthority == "" {
+			// Default location if XAUTHORITY is not set
+			if home := os.Getenv("HOME"); home != "" {
+				xauthority = home + "/.Xauthority"
+			}
+		}
+		if xauthority != "" {
+			candidates = append(candidates, xauthority)
+		}
+	case SocketWayland:
+		if waylandDisplay := getenv("WAYLAND_DISPLAY"); xdgRuntimeDir != "" && waylandDisplay != "" {
+			candidates = append(candidates, xdgRuntimeDir+"/"+waylandDisplay)
+		}
+	case SocketPulseAudio:
+		if xdgRuntimeDir != "" {
+			candidates = append(candidates, xdgRuntimeDir+"/pulse")
+		}
+	case SocketPipeWire:
+		if xdgRuntimeDir != "" {
+			candidates = append(candidates, xdgRuntimeDir+"/pipewire-0")
+		}
+	case SocketDBusSession:
+		dbusAddress := getenv("DBUS_SESSION_BUS_ADDRESS")
+		dbusSocketPath := parseDbusSocketPath(dbusAddress)
+		if dbusSocketPath == "" && dbusAddress != "" && strings.Contains(dbusAddress, "unix:abstract=") {
+			// Abstract sockets do not map to filesystem paths and do not need mounts.
+			break
+		}
+		if dbusSocketPath == "" && xdgRuntimeDir != "" {
+			dbusSocketPath = filepath.Join(xdgRuntimeDir, "bus")
+		}
+		if dbusSocketPath != "" {
+			candidates = append(candidates, dbusSocketPath)
+		}
+	}
+
+	var paths []string
+	for _, path := range candidates {
+		if _, err := os.Stat(path); err == nil {
+			paths = append(paths, path)
+		}
+	}
+	return paths
+}
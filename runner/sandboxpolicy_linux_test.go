@@ -0,0 +1,157 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// argsIndex returns where seq starts in args, or -1.
+func argsIndex(args []string, seq ...string) int {
+	for i := 0; i+len(seq) <= len(args); i++ {
+		if slices.Equal(args[i:i+len(seq)], seq) {
+			return i
+		}
+	}
+	return -1
+}
+
+func TestBubblewrapArgsFromPolicy(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	data := filepath.Join(params.InstallFolder, "data")
+	secrets := filepath.Join(data, "secrets")
+	token := filepath.Join(data, "token")
+	require.NoError(t, os.MkdirAll(secrets, 0o755))
+	require.NoError(t, os.WriteFile(token, []byte("hunter2"), 0o600))
+
+	params.SandboxConfig.Policy = &SandboxPolicy{
+		Filesystem: []FilesystemRule{
+			{Path: "/usr", Access: FilesystemReadOnly},
+			{Path: "/usr/share", Access: FilesystemReadOnly},
+			{Path: "/nonexistent", Access: FilesystemReadOnly},
+			{Path: "/game", Source: data, Access: FilesystemReadWrite},
+			{Path: secrets, Access: FilesystemDeny},
+			{Path: token, Access: FilesystemDeny},
+			{Path: "/scratch", Access: FilesystemTmpfs},
+		},
+		Env:        EnvPolicy{Allow: []string{"PATH"}},
+		Network:    NetworkNone,
+		Namespaces: NamespacePolicy{IPC: true},
+	}
+
+	args := bubblewrapArgs(params)
+	assert.Equal(t, 0, argsIndex(args, "--ro-bind", "/proc", "/proc"))
+	assert.NotEqual(t, -1, argsIndex(args, "--ro-bind", "/usr", "/usr"))
+	// Already exposed, or missing.
+	assert.Equal(t, -1, argsIndex(args, "--ro-bind", "/usr/share", "/usr/share"))
+	assert.Equal(t, -1, argsIndex(args, "--ro-bind", "/nonexistent", "/nonexistent"))
+
+	bind := argsIndex(args, "--bind", data, "/game")
+	require.NotEqual(t, -1, bind)
+	// Hidden where they're exposed.
+	assert.Greater(t, argsIndex(args, "--tmpfs", "/game/secrets"), bind)
+	assert.Greater(t, argsIndex(args, "--ro-bind", "/dev/null", "/game/token"), bind)
+	assert.NotEqual(t, -1, argsIndex(args, "--tmpfs", "/scratch"))
+
+	for _, option := range []string{"--unshare-net", "--unshare-ipc"} {
+		assert.Contains(t, args, option)
+	}
+	for _, option := range []string{"--proc", "--unshare-pid", "--unshare-uts", "--tmpfs /tmp"} {
+		assert.NotContains(t, strings.Join(args, " "), option)
+	}
+	assert.NotEqual(t, -1, argsIndex(args, "--setenv", "PATH", "/usr/bin:/bin"))
+	assert.Equal(t, -1, argsIndex(args, "--setenv", "HOME", "/home/player"))
+
+	// No devices or sockets unless asked for.
+	assert.NotContains(t, args, "--dev-bind")
+	assert.Equal(t, -1, argsIndex(args, "--ro-bind", "/tmp/.X11-unix", "/tmp/.X11-unix"))
+}
+
+func TestDefaultSandboxPolicyHidesItchFolder(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	itchDir := filepath.Join(params.InstallFolder, ".itch")
+	require.NoError(t, os.MkdirAll(itchDir, 0o755))
+
+	args := bubblewrapArgs(params)
+	installBind := argsIndex(args, "--bind", params.InstallFolder, params.InstallFolder)
+	require.NotEqual(t, -1, installBind)
+	assert.Greater(t, argsIndex(args, "--tmpfs", itchDir), installBind)
+	// Still the per-game home.
+	assert.NotEqual(t, -1, argsIndex(args, "--bind", filepath.Join(itchDir, "home"), "/home/player"))
+	// /bin/sh's folder is already exposed.
+	assert.Equal(t, 1, strings.Count(strings.Join(args, "\x00"), "--ro-bind\x00/bin\x00/bin"))
+}
+
+func TestFirejailProfileFromPolicy(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	installFolder := filepath.Join(home, ".config", "itch", "apps", "tetris")
+	params := RunnerParams{
+		Name:           "tetris",
+		InstallFolder:  installFolder,
+		FullTargetPath: filepath.Join(installFolder, "tetris"),
+	}
+	policy, err := DefaultSandboxPolicy(params)
+	require.NoError(t, err)
+
+	profile := firejailProfile(params, policy)
+	assert.True(t, strings.HasPrefix(profile, "include /etc/firejail/itch_game_tetris.local\n"))
+	// The install folder stays visible inside the denied ~/.config/itch.
+	assert.Contains(t, profile, strings.Join([]string{
+		"noblacklist ${HOME}/.config/itch/apps",
+		"blacklist ${HOME}/.config/itch/*",
+		"noblacklist ${HOME}/.config/itch/apps/tetris",
+		"blacklist ${HOME}/.config/itch/apps/*",
+	}, "\n"))
+	assert.NotContains(t, profile, "\nblacklist ${HOME}/.config/itch\n")
+	assert.Contains(t, profile, "blacklist ${HOME}/.config/kitch\n")
+	assert.Contains(t, profile, "noblacklist ${HOME}/.config/itch/apps/tetris\n")
+	assert.Contains(t, profile, "\nblacklist ${HOME}/.config/itch/apps/tetris/.itch\n")
+
+	// A denied folder exposed again as a whole isn't blacklisted.
+	policy.Filesystem = append(policy.Filesystem, FilesystemRule{Path: filepath.Join(installFolder, ".itch"), Access: FilesystemReadOnly})
+	assert.NotContains(t, firejailProfile(params, policy), "\nblacklist ${HOME}/.config/itch/apps/tetris/.itch\n")
+}
+
+func TestNativeSandboxEnforcesPolicy(t *testing.T) {
+	requireUserNamespaces(t)
+
+	params, stdout := newNativeTestParams(t, "cat .itch/secret 2>/dev/null || echo hidden; ls .itch; cat saves/ok")
+	params.Dir = params.InstallFolder
+	require.NoError(t, os.MkdirAll(filepath.Join(params.InstallFolder, ".itch"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(params.InstallFolder, ".itch", "secret"), []byte("secret\n"), 0o600))
+	require.NoError(t, os.MkdirAll(filepath.Join(params.InstallFolder, "saves"), 0o755))
+	require.NoError(t, os.WriteFile(filepath.Join(params.InstallFolder, "saves", "ok"), []byte("ok\n"), 0o644))
+
+	res, err := runNative(t, params)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "hidden\nok\n", stdout.String())
+}
+
+func TestCustomPolicyKeepsSandboxConfig(t *testing.T) {
+	params, _ := newNativeTestParams(t, "true")
+	params.Env = append(params.Env, "EXTRA=1")
+	policy := &SandboxPolicy{
+		Filesystem: []FilesystemRule{{Path: "/usr", Access: FilesystemReadOnly}},
+		Env:        EnvPolicy{Allow: []string{"PATH"}},
+	}
+	params.SandboxConfig.Policy = policy
+	params.SandboxConfig.NoNetwork = true
+	params.SandboxConfig.AllowEnv = []string{"EXTRA"}
+
+	args := bubblewrapArgs(params)
+	assert.Contains(t, args, "--unshare-net")
+	assert.NotEqual(t, -1, argsIndex(args, "--setenv", "EXTRA", "1"))
+	// The caller's policy is left alone.
+	assert.Equal(t, NetworkHost, policy.Network)
+	assert.Equal(t, []string{"PATH"}, policy.Env.Allow)
+}
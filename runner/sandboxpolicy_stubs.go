@@ -0,0 +1,14 @@
+//go:build !linux && !darwin
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// DefaultSandboxPolicy returns the policy of a sandboxed launch with params.
+// There's no sandbox to apply it on this platform.
+func DefaultSandboxPolicy(params RunnerParams) (*SandboxPolicy, error) {
+	return nil, fmt.Errorf("sandbox policies are not implemented on %s", runtime.GOOS)
+}
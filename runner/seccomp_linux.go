@@ -0,0 +1,269 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// seccompPresets are the syscalls each preset denies.
+var seccompPresets = map[SeccompPreset][]string{
+	SeccompPresetDefault: seccompDefaultDenyList,
+	SeccompPresetStrict:  append(append([]string{}, seccompDefaultDenyList...), seccompStrictDenyList...),
+}
+
+// seccompDefaultDenyList is what no game has any business doing: debugging
+// or reading other processes, kernel keyrings, perf, BPF, loading kernels
+// or modules, and system administration.
+var seccompDefaultDenyList = []string{
+	"ptrace", "process_vm_readv", "process_vm_writev",
+	"keyctl", "add_key", "request_key",
+	"perf_event_open", "bpf", "userfaultfd",
+	"kexec_load", "kexec_file_load", "init_module", "finit_module", "delete_module",
+	"reboot", "swapon", "swapoff", "acct", "syslog", "quotactl", "lookup_dcookie",
+	"open_by_handle_at", "iopl", "ioperm", "uselib", "nfsservctl", "vhangup",
+	"settimeofday", "clock_settime", "clock_settime64", "clock_adjtime", "clock_adjtime64",
+}
+
+// seccompStrictDenyList also keeps games from making namespaces or mounts
+// of their own, which breaks embedded Chromium's sandbox, as well as from
+// using io_uring and personality.
+var seccompStrictDenyList = []string{
+	"unshare", "setns",
+	"mount", "umount", "umount2", "pivot_root", "chroot",
+	"fsopen", "fsconfig", "fsmount", "fspick", "move_mount", "open_tree", "mount_setattr",
+	"name_to_handle_at",
+	"io_uring_setup", "io_uring_enter", "io_uring_register",
+	"personality",
+}
+
+// seccompArchitectures are the syscall ABIs a process may use on each
+// GOARCH. 32-bit x86 binaries can run on amd64, and must be filtered too.
+var seccompArchitectures = map[string][]uint32{
+	"amd64": {unix.AUDIT_ARCH_X86_64, unix.AUDIT_ARCH_I386},
+	"386":   {unix.AUDIT_ARCH_I386},
+	"arm64": {unix.AUDIT_ARCH_AARCH64},
+}
+
+// seccompX32SyscallBit is set on syscall numbers of the x32 ABI, which use
+// AUDIT_ARCH_X86_64 as well.
+const seccompX32SyscallBit = 0x40000000
+
+// Offsets in struct seccomp_data.
+const (
+	seccompDataNr   = 0
+	seccompDataArch = 4
+)
+
+// seccompDenyList returns the syscalls config denies: those of its preset,
+// then its additions, without duplicates.
+func seccompDenyList(config SandboxConfig) ([]string, error) {
+	var names []string
+	if config.Seccomp != SeccompPresetNone {
+		preset, ok := seccompPresets[config.Seccomp]
+		if !ok {
+			return nil, fmt.Errorf("unknown seccomp preset %q", config.Seccomp)
+		}
+		names = append(names, preset...)
+	}
+	names = append(names, config.SeccompDeny...)
+
+	seen := make(map[string]struct{})
+	var out []string
+	for _, name := range names {
+		if _, ok := seen[name]; ok {
+			continue
+		}
+		seen[name] = struct{}{}
+		out = append(out, name)
+	}
+	return out, nil
+}
+
+// seccompProgram compiles a BPF program that makes the given syscalls fail
+// with EPERM, on every architecture of archs. Names missing from an
+// architecture are skipped, but each must exist on at least one. Syscalls
+// of any other architecture kill the process.
+func seccompProgram(archs []uint32, names []string) ([]unix.SockFilter, error) {
+	for _, name := range names {
+		found := false
+		for _, arch := range archs {
+			if _, ok := seccompSyscallTables[arch][name]; ok {
+				found = true
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("unknown syscall %q", name)
+		}
+	}
+
+	stmt := func(code uint16, k uint32) unix.SockFilter {
+		return unix.SockFilter{Code: code, K: k}
+	}
+	jump := func(code uint16, k uint32, jt, jf int) unix.SockFilter {
+		return unix.SockFilter{Code: code, Jt: uint8(jt), Jf: uint8(jf), K: k}
+	}
+	const (
+		load = unix.BPF_LD | unix.BPF_W | unix.BPF_ABS
+		jeq  = unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K
+		jge  = unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K
+		ret  = unix.BPF_RET | unix.BPF_K
+	)
+
+	prog := []unix.SockFilter{stmt(load, seccompDataArch)}
+	for _, arch := range archs {
+		var nrs []uint32
+		for _, name := range names {
+			if nr, ok := seccompSyscallTables[arch][name]; ok {
+				nrs = append(nrs, nr)
+			}
+		}
+		sort.Slice(nrs, func(i, j int) bool { return nrs[i] < nrs[j] })
+
+		// Each architecture's block: load the syscall number, compare it to
+		// every denied one, then allow or deny.
+		var block []unix.SockFilter
+		block = append(block, stmt(load, seccompDataNr))
+		if arch == unix.AUDIT_ARCH_X86_64 {
+			block = append(block, jump(jge, seccompX32SyscallBit, len(nrs)+1, 0))
+		}
+		for i, nr := range nrs {
+			block = append(block, jump(jeq, nr, len(nrs)-i, 0))
+		}
+		block = append(block,
+			stmt(ret, unix.SECCOMP_RET_ALLOW),
+			stmt(ret, unix.SECCOMP_RET_ERRNO|uint32(unix.EPERM)),
+		)
+		if len(block) > 255 {
+			return nil, fmt.Errorf("too many syscalls to deny (%d)", len(nrs))
+		}
+
+		prog = append(prog, jump(jeq, arch, 0, len(block)))
+		prog = append(prog, block...)
+	}
+	prog = append(prog, stmt(ret, unix.SECCOMP_RET_KILL_PROCESS))
+	return prog, nil
+}
+
+// seccompFilter returns the filter config asks for on this machine, or nil
+// if it asks for none.
+func seccompFilter(config SandboxConfig) ([]unix.SockFilter, error) {
+	names, err := seccompDenyList(config)
+	if err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, nil
+	}
+	archs, ok := seccompArchitectures[runtime.GOARCH]
+	if !ok {
+		return nil, fmt.Errorf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+	return seccompProgram(archs, names)
+}
+
+// marshalSeccompProgram encodes prog as bwrap's --seccomp expects it: an
+// array of struct sock_filter, as written by seccomp_export_bpf.
+func marshalSeccompProgram(prog []unix.SockFilter) []byte {
+	var buf bytes.Buffer
+	// Only fixed-size fields, this can't fail.
+	binary.Write(&buf, binary.NativeEndian, prog)
+	return buf.Bytes()
+}
+
+func unmarshalSeccompProgram(data []byte) ([]unix.SockFilter, error) {
+	size := int(unsafe.Sizeof(unix.SockFilter{}))
+	if len(data) == 0 || len(data)%size != 0 {
+		return nil, fmt.Errorf("malformed seccomp program (%d bytes)", len(data))
+	}
+	prog := make([]unix.SockFilter, len(data)/size)
+	err := binary.Read(bytes.NewReader(data), binary.NativeEndian, prog)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return prog, nil
+}
+
+// seccompProgramFile returns a file to read the filter config asks for
+// from, to be passed to the sandbox. It returns nil if config asks for no
+// filtering.
+func seccompProgramFile(params RunnerParams) (*os.File, error) {
+	prog, err := seccompFilter(params.SandboxConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if prog == nil {
+		return nil, nil
+	}
+	params.Consumer.Infof("Filtering syscalls with seccomp (%d instructions)", len(prog))
+
+	// The program is a few kilobytes at most, which fits in a pipe's
+	// buffer, so it can be written before the sandbox reads it.
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer w.Close()
+	_, err = w.Write(marshalSeccompProgram(prog))
+	if err != nil {
+		r.Close()
+		return nil, fmt.Errorf("%w", err)
+	}
+	return r, nil
+}
+
+// installSeccompProgram applies prog to the calling thread, and whatever
+// it executes. no_new_privs must be set already.
+func installSeccompProgram(prog []unix.SockFilter) error {
+	fprog := unix.SockFprog{Len: uint16(len(prog)), Filter: &prog[0]}
+	err := unix.Prctl(unix.PR_SET_SECCOMP, unix.SECCOMP_MODE_FILTER, uintptr(unsafe.Pointer(&fprog)), 0, 0)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+// seccompNativeDenyList returns the syscalls config denies that exist on
+// the native architecture, for sandboxes that compile their own filter
+// from names and complain about unknown ones. It returns nil if config asks
+// for no filtering.
+func seccompNativeDenyList(config SandboxConfig) ([]string, error) {
+	// Also validates names.
+	prog, err := seccompFilter(config)
+	if err != nil || prog == nil {
+		return nil, err
+	}
+	names, err := seccompDenyList(config)
+	if err != nil {
+		return nil, err
+	}
+
+	native := seccompSyscallTables[seccompArchitectures[runtime.GOARCH][0]]
+	var known []string
+	for _, name := range names {
+		if _, ok := native[name]; ok {
+			known = append(known, name)
+		}
+	}
+	return known, nil
+}
+
+// firejailSeccompOption returns the firejail profile option that denies
+// the syscalls config asks for, or "" if it asks for none. firejail
+// compiles its own filter.
+func firejailSeccompOption(config SandboxConfig) (string, error) {
+	names, err := seccompNativeDenyList(config)
+	if err != nil || names == nil {
+		return "", err
+	}
+	return "seccomp.drop " + strings.Join(names, ","), nil
+}
@@ -0,0 +1,210 @@
+//go:build linux
+
+package runner
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/sys/unix"
+)
+
+// evalSeccompProgram runs the subset of classic BPF seccompProgram emits,
+// and returns what the kernel would do with a syscall.
+func evalSeccompProgram(t *testing.T, prog []unix.SockFilter, arch uint32, nr uint32) uint32 {
+	t.Helper()
+	var acc uint32
+	for pc := 0; pc < len(prog); pc++ {
+		ins := prog[pc]
+		switch ins.Code {
+		case unix.BPF_LD | unix.BPF_W | unix.BPF_ABS:
+			switch ins.K {
+			case seccompDataNr:
+				acc = nr
+			case seccompDataArch:
+				acc = arch
+			default:
+				t.Fatalf("unexpected load offset %d", ins.K)
+			}
+		case unix.BPF_JMP | unix.BPF_JEQ | unix.BPF_K:
+			if acc == ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_JMP | unix.BPF_JGE | unix.BPF_K:
+			if acc >= ins.K {
+				pc += int(ins.Jt)
+			} else {
+				pc += int(ins.Jf)
+			}
+		case unix.BPF_RET | unix.BPF_K:
+			return ins.K
+		default:
+			t.Fatalf("unexpected instruction %#x", ins.Code)
+		}
+	}
+	t.Fatal("program fell through")
+	return 0
+}
+
+func TestSeccompDenyList(t *testing.T) {
+	names, err := seccompDenyList(SandboxConfig{})
+	require.NoError(t, err)
+	assert.Empty(t, names)
+
+	names, err = seccompDenyList(SandboxConfig{Seccomp: SeccompPresetDefault, SeccompDeny: []string{"ptrace", "chroot"}})
+	require.NoError(t, err)
+	for _, name := range []string{"ptrace", "keyctl", "add_key", "perf_event_open", "kexec_load", "chroot"} {
+		assert.Contains(t, names, name)
+	}
+	assert.NotContains(t, names, "unshare")
+	assert.Equal(t, 1, strings.Count(strings.Join(names, " "), "ptrace"))
+
+	names, err = seccompDenyList(SandboxConfig{Seccomp: SeccompPresetStrict})
+	require.NoError(t, err)
+	assert.Contains(t, names, "ptrace")
+	assert.Contains(t, names, "unshare")
+
+	_, err = seccompDenyList(SandboxConfig{Seccomp: "paranoid"})
+	assert.Error(t, err)
+}
+
+func TestSeccompProgram(t *testing.T) {
+	archs := []uint32{unix.AUDIT_ARCH_X86_64, unix.AUDIT_ARCH_I386}
+	names, err := seccompDenyList(SandboxConfig{Seccomp: SeccompPresetDefault, SeccompDeny: []string{"uname"}})
+	require.NoError(t, err)
+	prog, err := seccompProgram(archs, names)
+	require.NoError(t, err)
+
+	deny := uint32(unix.SECCOMP_RET_ERRNO | uint32(unix.EPERM))
+	x86_64 := seccompSyscallTables[unix.AUDIT_ARCH_X86_64]
+	i386 := seccompSyscallTables[unix.AUDIT_ARCH_I386]
+
+	assert.Equal(t, deny, evalSeccompProgram(t, prog, unix.AUDIT_ARCH_X86_64, x86_64["ptrace"]))
+	assert.Equal(t, deny, evalSeccompProgram(t, prog, unix.AUDIT_ARCH_X86_64, x86_64["uname"]))
+	assert.Equal(t, deny, evalSeccompProgram(t, prog, unix.AUDIT_ARCH_X86_64, x86_64["clock_settime"]))
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), evalSeccompProgram(t, prog, unix.AUDIT_ARCH_X86_64, x86_64["read"]))
+	// x32 syscalls can't be used to get around the filter.
+	assert.Equal(t, deny, evalSeccompProgram(t, prog, unix.AUDIT_ARCH_X86_64, seccompX32SyscallBit|x86_64["read"]))
+
+	assert.Equal(t, deny, evalSeccompProgram(t, prog, unix.AUDIT_ARCH_I386, i386["ptrace"]))
+	assert.Equal(t, deny, evalSeccompProgram(t, prog, unix.AUDIT_ARCH_I386, i386["clock_settime64"]))
+	assert.Equal(t, uint32(unix.SECCOMP_RET_ALLOW), evalSeccompProgram(t, prog, unix.AUDIT_ARCH_I386, i386["read"]))
+
+	assert.Equal(t, uint32(unix.SECCOMP_RET_KILL_PROCESS), evalSeccompProgram(t, prog, unix.AUDIT_ARCH_AARCH64, 0))
+
+	decoded, err := unmarshalSeccompProgram(marshalSeccompProgram(prog))
+	require.NoError(t, err)
+	assert.Equal(t, prog, decoded)
+}
+
+func TestSeccompProgramRejectsUnknownSyscalls(t *testing.T) {
+	_, err := seccompProgram([]uint32{unix.AUDIT_ARCH_X86_64}, []string{"ptrace", "frobnicate"})
+	assert.ErrorContains(t, err, "frobnicate")
+}
+
+func TestBubblewrapSeccompPassesProgram(t *testing.T) {
+	if _, ok := seccompArchitectures[runtime.GOARCH]; !ok {
+		t.Skipf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+
+	origCommand := bubblewrapCommand
+	t.Cleanup(func() {
+		bubblewrapCommand = origCommand
+	})
+
+	var gotArgs []string
+	bubblewrapCommand = func(name string, args ...string) *exec.Cmd {
+		gotArgs = append([]string{}, args...)
+		return exec.Command("sh", "-c", "wc -c <&3")
+	}
+
+	var stdout bytes.Buffer
+	br := &bubblewrapRunner{
+		params: RunnerParams{
+			Consumer: &state.Consumer{OnMessage: func(string, string) {}},
+			Ctx:      context.Background(),
+			BubblewrapParams: BubblewrapParams{
+				BinaryPath: "/fake/bwrap",
+			},
+			FullTargetPath: "/bin/true",
+			Stdout:         &stdout,
+			SandboxConfig:  SandboxConfig{Seccomp: SeccompPresetDefault},
+		},
+	}
+
+	_, err := br.Run()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"--seccomp", "3"}, gotArgs[:2])
+
+	prog, err := seccompFilter(br.params.SandboxConfig)
+	require.NoError(t, err)
+	assert.Equal(t, strconv.Itoa(len(marshalSeccompProgram(prog))), strings.TrimSpace(stdout.String()))
+}
+
+func TestFirejailSeccompProfileOption(t *testing.T) {
+	if runtime.GOARCH != "amd64" {
+		t.Skip("syscall names checked are amd64's")
+	}
+
+	origCommand := firejailCommand
+	t.Cleanup(func() {
+		firejailCommand = origCommand
+	})
+	firejailCommand = func(name string, args ...string) *exec.Cmd {
+		return exec.Command("sh", "-c", "true")
+	}
+
+	fr := newFirejailTestRunner(t, false)
+	fr.params.SandboxConfig.Seccomp = SeccompPresetDefault
+	fr.params.SandboxConfig.SeccompDeny = []string{"uname"}
+	_, err := fr.Run()
+	require.NoError(t, err)
+
+	profile, err := os.ReadFile(filepath.Join(fr.params.InstallFolder, ".itch", "isolate-app.profile"))
+	require.NoError(t, err)
+	var option string
+	for line := range strings.SplitSeq(string(profile), "\n") {
+		if strings.HasPrefix(line, "seccomp.drop ") {
+			option = line
+		}
+	}
+	require.NotEmpty(t, option)
+	dropped := strings.Split(strings.TrimPrefix(option, "seccomp.drop "), ",")
+	assert.Contains(t, dropped, "ptrace")
+	assert.Contains(t, dropped, "uname")
+	// i386 only.
+	assert.NotContains(t, dropped, "clock_settime64")
+}
+
+func TestNativeSandboxWithSeccomp(t *testing.T) {
+	requireUserNamespaces(t)
+	if _, ok := seccompArchitectures[runtime.GOARCH]; !ok {
+		t.Skipf("seccomp filtering is not supported on %s", runtime.GOARCH)
+	}
+	if _, err := exec.LookPath("unshare"); err != nil {
+		t.Skip("unshare is not installed")
+	}
+
+	params, stdout := newNativeTestParams(t, strings.Join([]string{
+		"grep '^Seccomp:' /proc/self/status | tr -d ' \\t'",
+		"unshare -U true 2>/dev/null || echo unshare=denied",
+	}, "; "))
+	params.SandboxConfig.Seccomp = SeccompPresetStrict
+
+	res, err := runNative(t, params)
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "Seccomp:2\nunshare=denied\n", stdout.String())
+}
@@ -0,0 +1,54 @@
+package runner
+
+// SelfTestStatus is the outcome of a self-test check.
+type SelfTestStatus string
+
+const (
+	SelfTestPass SelfTestStatus = "pass"
+	SelfTestFail SelfTestStatus = "fail"
+	// The check doesn't apply, e.g. networking isn't disabled.
+	SelfTestSkip SelfTestStatus = "skip"
+)
+
+// Self-test checks, by name.
+const (
+	// ~/.ssh can't be read from the sandbox.
+	SelfTestSSHHidden = "ssh-hidden"
+	// InstallFolder can be written to from the sandbox, and the writes
+	// reach the real folder.
+	SelfTestInstallFolderWritable = "install-folder-writable"
+	// No network interface but loopback is visible, when the sandbox
+	// policy disables networking.
+	SelfTestNetworkBlocked = "network-blocked"
+	// Environment variables that aren't allowed don't reach the sandbox.
+	SelfTestEnvFiltered = "env-filtered"
+	// The sandbox has a PID namespace of its own.
+	SelfTestPidNamespace = "pid-namespace"
+)
+
+// SelfTestCheck is the outcome of one self-test check.
+type SelfTestCheck struct {
+	// One of the SelfTest* check names, e.g. SelfTestSSHHidden.
+	Name   string
+	Status SelfTestStatus
+	// What was observed, for display next to the status.
+	Detail string
+}
+
+// SelfTestReport is the outcome of SelfTest.
+type SelfTestReport struct {
+	// Runner implementation the probe was launched with.
+	Backend Backend
+
+	Checks []SelfTestCheck
+}
+
+// Passed returns true if no check failed.
+func (r *SelfTestReport) Passed() bool {
+	for _, check := range r.Checks {
+		if check.Status == SelfTestFail {
+			return false
+		}
+	}
+	return true
+}
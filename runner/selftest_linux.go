@@ -0,0 +1,328 @@
+//go:build linux
+
+package runner
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// selfTestBinaryName is what the launcher is linked or copied as for a
+// self-test. Run under that name, it reports on its surroundings instead
+// of running normally (see runSelfTestProbe).
+const selfTestBinaryName = "smaug-selftest"
+
+// selfTestCanaryEnv is passed to the probe without being allowed, so it
+// must not reach it through a sandbox.
+const selfTestCanaryEnv = "SMAUG_SELF_TEST_CANARY"
+
+// selfTestMarker prefixes the probe's report in its output, which
+// sandboxes may add their own messages to.
+const selfTestMarker = "smaug-self-test: "
+
+// selfTestTimeout bounds a self-test launch.
+const selfTestTimeout = 30 * time.Second
+
+func init() {
+	if filepath.Base(os.Args[0]) != selfTestBinaryName {
+		return
+	}
+	os.Exit(runSelfTestProbe(os.Args[1:], os.Stdout))
+}
+
+// selfTestObservation is what the probe saw from inside the sandbox.
+type selfTestObservation struct {
+	// Entries of ~/.ssh the probe could list.
+	SSHEntries int    `json:"sshEntries"`
+	SSHError   string `json:"sshError,omitempty"`
+
+	WriteError string `json:"writeError,omitempty"`
+
+	// Network interfaces other than loopback.
+	Interfaces      []string `json:"interfaces"`
+	InterfacesError string   `json:"interfacesError,omitempty"`
+
+	Canary bool `json:"canary"`
+
+	// e.g. "pid:[4026531836]"
+	PidNamespace      string `json:"pidNamespace"`
+	PidNamespaceError string `json:"pidNamespaceError,omitempty"`
+}
+
+// runSelfTestProbe runs in the sandbox being tested. It tries to list the
+// ~/.ssh directory at args[0], writes the token args[2] to the file at
+// args[1], and reports what it found out to w.
+func runSelfTestProbe(args []string, w io.Writer) int {
+	if len(args) != 3 {
+		fmt.Fprintf(os.Stderr, "usage: %s <ssh dir> <write path> <token>\n", selfTestBinaryName)
+		return 1
+	}
+	sshDir, writePath, token := args[0], args[1], args[2]
+
+	var obs selfTestObservation
+	entries, err := os.ReadDir(sshDir)
+	if err != nil {
+		obs.SSHError = err.Error()
+	}
+	obs.SSHEntries = len(entries)
+
+	err = os.WriteFile(writePath, []byte(token), 0o644)
+	if err != nil {
+		obs.WriteError = err.Error()
+	}
+
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		obs.InterfacesError = err.Error()
+	}
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			obs.Interfaces = append(obs.Interfaces, iface.Name)
+		}
+	}
+
+	_, obs.Canary = os.LookupEnv(selfTestCanaryEnv)
+
+	obs.PidNamespace, err = os.Readlink("/proc/self/ns/pid")
+	if err != nil {
+		obs.PidNamespaceError = err.Error()
+	}
+
+	out, err := json.Marshal(obs)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s\n", err.Error())
+		return 1
+	}
+	fmt.Fprintf(w, "%s%s\n", selfTestMarker, out)
+	return 0
+}
+
+// SelfTest checks that the sandbox a game would be launched in with params
+// isolates it like it should. It launches a probe, which is the launcher
+// itself linked or copied into {InstallFolder}/.itch/selftest, with the
+// runner GetRunner picks and params.SandboxConfig, and checks what the
+// probe saw from inside. params.FullTargetPath and Args are ignored. An
+// error is returned if the probe couldn't be launched or didn't report
+// back.
+func SelfTest(params RunnerParams) (*SelfTestReport, error) {
+	if params.InstallFolder == "" {
+		return nil, fmt.Errorf("InstallFolder must be set for a self-test")
+	}
+
+	dir := filepath.Join(params.InstallFolder, ".itch", "selftest")
+	err := os.RemoveAll(dir)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	probePath := filepath.Join(dir, selfTestBinaryName)
+	err = installSelfTestProbe(probePath)
+	if err != nil {
+		return nil, fmt.Errorf("installing self-test probe: %w", err)
+	}
+
+	var sshDir string
+	home, err := os.UserHomeDir()
+	if err == nil {
+		sshDir = filepath.Join(home, ".ssh")
+	}
+	token, err := newLaunchName()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	// Right in InstallFolder: the probe's folder is exposed again below,
+	// writes there say nothing about the rest of it.
+	writePath := filepath.Join(params.InstallFolder, "."+token)
+	defer os.Remove(writePath)
+
+	ctx := params.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	ctx, cancel := context.WithTimeout(ctx, selfTestTimeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	params.Ctx = ctx
+	params.Console = false
+	params.FullTargetPath = probePath
+	params.Args = []string{sshDir, writePath, token}
+	params.Env = append(slices.Clone(params.Env), selfTestCanaryEnv+"=1")
+	params.Stdin = nil
+	params.Stdout = &stdout
+	params.Stderr = &stderr
+	params.OnOutputLine = nil
+	params.TimeLimits = TimeLimits{}
+	params.LogParams = LogParams{}
+	params.CrashParams = CrashParams{}
+
+	// The probe's folder is exposed again, {InstallFolder}/.itch being
+	// denied by default.
+	policy := *launchSandboxPolicy(params)
+	policy.Filesystem = append(slices.Clone(policy.Filesystem), FilesystemRule{Path: dir, Access: FilesystemReadWrite})
+	params.SandboxConfig.Policy = &policy
+
+	r, err := GetRunner(params)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	err = r.Prepare()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	res, err := r.Run()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	obs, ok := parseSelfTestOutput(stdout.String())
+	if !ok {
+		return nil, fmt.Errorf("self-test probe didn't report back (exit code %d): %s", res.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+
+	expect := selfTestExpectations{
+		sshEntries:   -1,
+		token:        token,
+		noNetwork:    policy.Network == NetworkNone,
+		pidNamespace: "unknown",
+	}
+	if entries, err := os.ReadDir(sshDir); err == nil {
+		expect.sshEntries = len(entries)
+	}
+	if written, err := os.ReadFile(writePath); err == nil {
+		expect.written = string(written)
+	}
+	if ns, err := os.Readlink("/proc/self/ns/pid"); err == nil {
+		expect.pidNamespace = ns
+	}
+
+	report := &SelfTestReport{
+		Backend: res.Backend,
+		Checks:  selfTestChecks(obs, expect),
+	}
+	return report, nil
+}
+
+// installSelfTestProbe hard links the launcher to path, or copies it if
+// they're on different filesystems.
+func installSelfTestProbe(path string) error {
+	launcher, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	if os.Link(launcher, path) == nil {
+		return nil
+	}
+
+	src, err := os.Open(launcher)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o755)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	_, err = io.Copy(dst, src)
+	if err != nil {
+		dst.Close()
+		return fmt.Errorf("%w", err)
+	}
+	return dst.Close()
+}
+
+// parseSelfTestOutput finds the probe's report in its output.
+func parseSelfTestOutput(output string) (selfTestObservation, bool) {
+	var obs selfTestObservation
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		report, ok := strings.CutPrefix(scanner.Text(), selfTestMarker)
+		if ok && json.Unmarshal([]byte(report), &obs) == nil {
+			return obs, true
+		}
+	}
+	return obs, false
+}
+
+// selfTestExpectations is what the probe's observations are compared to,
+// as seen from outside the sandbox.
+type selfTestExpectations struct {
+	// Entries of ~/.ssh, or -1 if it can't be listed.
+	sshEntries int
+	// What the probe should have written, and what it did.
+	token   string
+	written string
+
+	noNetwork    bool
+	pidNamespace string
+}
+
+func selfTestChecks(obs selfTestObservation, expect selfTestExpectations) []SelfTestCheck {
+	var checks []SelfTestCheck
+	check := func(name string, status SelfTestStatus, detail string, args ...any) {
+		checks = append(checks, SelfTestCheck{Name: name, Status: status, Detail: fmt.Sprintf(detail, args...)})
+	}
+
+	switch {
+	case expect.sshEntries <= 0:
+		check(SelfTestSSHHidden, SelfTestSkip, "~/.ssh is missing or empty, nothing to hide")
+	case obs.SSHEntries > 0:
+		check(SelfTestSSHHidden, SelfTestFail, "%d entries of ~/.ssh were listed", obs.SSHEntries)
+	case obs.SSHError != "":
+		check(SelfTestSSHHidden, SelfTestPass, "%s", obs.SSHError)
+	default:
+		check(SelfTestSSHHidden, SelfTestPass, "~/.ssh is empty in the sandbox")
+	}
+
+	switch {
+	case obs.WriteError != "":
+		check(SelfTestInstallFolderWritable, SelfTestFail, "%s", obs.WriteError)
+	case expect.written != expect.token:
+		check(SelfTestInstallFolderWritable, SelfTestFail, "the file written in the sandbox isn't in the install folder")
+	default:
+		check(SelfTestInstallFolderWritable, SelfTestPass, "a file written in the sandbox reached the install folder")
+	}
+
+	switch {
+	case !expect.noNetwork:
+		check(SelfTestNetworkBlocked, SelfTestSkip, "networking isn't disabled")
+	case obs.InterfacesError != "":
+		check(SelfTestNetworkBlocked, SelfTestFail, "could not list network interfaces: %s", obs.InterfacesError)
+	case len(obs.Interfaces) > 0:
+		check(SelfTestNetworkBlocked, SelfTestFail, "network interfaces are visible: %s", strings.Join(obs.Interfaces, ", "))
+	default:
+		check(SelfTestNetworkBlocked, SelfTestPass, "only loopback is visible")
+	}
+
+	if obs.Canary {
+		check(SelfTestEnvFiltered, SelfTestFail, "%s reached the sandbox", selfTestCanaryEnv)
+	} else {
+		check(SelfTestEnvFiltered, SelfTestPass, "%s was filtered out", selfTestCanaryEnv)
+	}
+
+	switch {
+	case obs.PidNamespaceError != "":
+		check(SelfTestPidNamespace, SelfTestFail, "could not read the PID namespace: %s", obs.PidNamespaceError)
+	case obs.PidNamespace == expect.pidNamespace:
+		check(SelfTestPidNamespace, SelfTestFail, "the launcher's PID namespace (%s) is shared", obs.PidNamespace)
+	default:
+		check(SelfTestPidNamespace, SelfTestPass, "%s, the launcher's is %s", obs.PidNamespace, expect.pidNamespace)
+	}
+
+	return checks
+}
@@ -0,0 +1,129 @@
+//go:build linux
+
+package runner
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// selfTestHome makes ~/.ssh a directory with a key in it.
+func selfTestHome(t *testing.T) {
+	t.Helper()
+	home := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(home, ".ssh"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(home, ".ssh", "id_ed25519"), []byte("secret"), 0o600))
+	t.Setenv("HOME", home)
+}
+
+func selfTestStatuses(report *SelfTestReport) map[string]SelfTestStatus {
+	statuses := map[string]SelfTestStatus{}
+	for _, check := range report.Checks {
+		statuses[check.Name] = check.Status
+	}
+	return statuses
+}
+
+func TestSelfTestNativeSandbox(t *testing.T) {
+	requireUserNamespaces(t)
+	selfTestHome(t)
+
+	params, _ := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.SandboxConfig.Type = SandboxTypeNative
+	params.SandboxConfig.NoNetwork = true
+
+	report, err := SelfTest(params)
+	require.NoError(t, err)
+	assert.Equal(t, BackendNative, report.Backend)
+	assert.True(t, report.Passed(), "%+v", report.Checks)
+	assert.Equal(t, map[string]SelfTestStatus{
+		SelfTestSSHHidden:             SelfTestPass,
+		SelfTestInstallFolderWritable: SelfTestPass,
+		SelfTestNetworkBlocked:        SelfTestPass,
+		SelfTestEnvFiltered:           SelfTestPass,
+		SelfTestPidNamespace:          SelfTestPass,
+	}, selfTestStatuses(report))
+	assert.NoDirExists(t, filepath.Join(params.InstallFolder, ".itch", "selftest"))
+	entries, err := os.ReadDir(params.InstallFolder)
+	require.NoError(t, err)
+	for _, e := range entries {
+		assert.NotContains(t, e.Name(), "smaug-launch-")
+	}
+}
+
+func TestSelfTestReadOnlyInstallFolder(t *testing.T) {
+	requireUserNamespaces(t)
+	selfTestHome(t)
+
+	params, _ := newNativeTestParams(t, "true")
+	params.Sandbox = true
+	params.SandboxConfig.Type = SandboxTypeNative
+	policy := *launchSandboxPolicy(params)
+	policy.Filesystem = slices.Clone(policy.Filesystem)
+	for i, rule := range policy.Filesystem {
+		if rule.Path == params.InstallFolder {
+			policy.Filesystem[i].Access = FilesystemReadOnly
+		}
+	}
+	params.SandboxConfig.Policy = &policy
+
+	report, err := SelfTest(params)
+	require.NoError(t, err)
+	assert.Equal(t, SelfTestFail, selfTestStatuses(report)[SelfTestInstallFolderWritable], "%+v", report.Checks)
+}
+
+func TestSelfTestWithoutSandbox(t *testing.T) {
+	selfTestHome(t)
+
+	params, _ := newNativeTestParams(t, "true")
+
+	report, err := SelfTest(params)
+	require.NoError(t, err)
+	assert.Equal(t, BackendSimple, report.Backend)
+	assert.False(t, report.Passed())
+	assert.Equal(t, map[string]SelfTestStatus{
+		SelfTestSSHHidden:             SelfTestFail,
+		SelfTestInstallFolderWritable: SelfTestPass,
+		SelfTestNetworkBlocked:        SelfTestSkip,
+		SelfTestEnvFiltered:           SelfTestFail,
+		SelfTestPidNamespace:          SelfTestFail,
+	}, selfTestStatuses(report))
+}
+
+func TestSelfTestChecks(t *testing.T) {
+	obs := selfTestObservation{
+		SSHError:     "open /home/player/.ssh: no such file or directory",
+		Interfaces:   []string{"eth0"},
+		PidNamespace: "pid:[4026532001]",
+	}
+	expect := selfTestExpectations{
+		sshEntries:   0,
+		token:        "smaug-launch-0123456789ab",
+		written:      "",
+		noNetwork:    true,
+		pidNamespace: "pid:[4026531836]",
+	}
+	checks := selfTestChecks(obs, expect)
+	require.Len(t, checks, 5)
+	assert.Equal(t, SelfTestCheck{SelfTestSSHHidden, SelfTestSkip, "~/.ssh is missing or empty, nothing to hide"}, checks[0])
+	assert.Equal(t, SelfTestCheck{SelfTestInstallFolderWritable, SelfTestFail, "the file written in the sandbox isn't in the install folder"}, checks[1])
+	assert.Equal(t, SelfTestCheck{SelfTestNetworkBlocked, SelfTestFail, "network interfaces are visible: eth0"}, checks[2])
+	assert.Equal(t, SelfTestPass, checks[3].Status)
+	assert.Equal(t, SelfTestPass, checks[4].Status)
+}
+
+func TestParseSelfTestOutput(t *testing.T) {
+	_, ok := parseSelfTestOutput("Parent pid 1234, child pid 1235\n")
+	assert.False(t, ok)
+
+	obs, ok := parseSelfTestOutput("Parent pid 1234, child pid 1235\n" + selfTestMarker + `{"sshEntries":2,"canary":true}` + "\n")
+	require.True(t, ok)
+	assert.Equal(t, 2, obs.SSHEntries)
+	assert.True(t, obs.Canary)
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func SelfTest(params RunnerParams) (*SelfTestReport, error) {
+	return nil, fmt.Errorf("sandbox self-test is not implemented on %s", runtime.GOOS)
+}
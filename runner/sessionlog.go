@@ -0,0 +1,337 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/itchio/headway/state"
+)
+
+// LogParams configures capturing a launch's output into a log file, so that
+// what a game printed before crashing isn't lost. Each launch gets its own
+// file, named after the time it started.
+type LogParams struct {
+	// If true, stdout and stderr are also written to a new log file for each
+	// launch. They're still passed to RunnerParams.Stdout and Stderr, but
+	// through a pipe, even if those are files.
+	Enabled bool
+
+	// Directory log files are written to. Defaults to
+	// {InstallFolder}/.itch/logs.
+	Dir string
+
+	// Maximum size of a single session's log file, in bytes. Output past it
+	// is not written to the file. Defaults to 10 MiB.
+	MaxSize int64
+
+	// Number of session logs kept in Dir. Older ones are removed when a new
+	// session starts. Defaults to 10.
+	MaxSessions int
+
+	// Number of last output lines attached to the error of a launch that
+	// exits unsuccessfully, see LogTailError. Defaults to 50.
+	TailLines int
+}
+
+const (
+	defaultLogMaxSize     = 10 * 1024 * 1024
+	defaultLogMaxSessions = 10
+	defaultLogTailLines   = 50
+
+	// sessionLogTimeFormat names log files so that they sort by start time.
+	sessionLogTimeFormat = "2006-01-02_15-04-05.000000"
+	sessionLogExt        = ".log"
+
+	// maxLogLineLength bounds how much of a line without a newline is kept
+	// by a lineRing.
+	maxLogLineLength = 4096
+)
+
+func (lp LogParams) withDefaults(installFolder string) LogParams {
+	if lp.Dir == "" {
+		lp.Dir = filepath.Join(installFolder, ".itch", "logs")
+	}
+	if lp.MaxSize <= 0 {
+		lp.MaxSize = defaultLogMaxSize
+	}
+	if lp.MaxSessions <= 0 {
+		lp.MaxSessions = defaultLogMaxSessions
+	}
+	if lp.TailLines <= 0 {
+		lp.TailLines = defaultLogTailLines
+	}
+	return lp
+}
+
+// LogTailError is returned by launches with log capture enabled when the
+// game exits unsuccessfully. It wraps the original error.
+type LogTailError struct {
+	Err error
+
+	// Path of the session's log file.
+	LogPath string
+
+	// Last lines the game output, oldest first, stdout and stderr combined.
+	Tail []string
+}
+
+func (e *LogTailError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *LogTailError) Unwrap() error {
+	return e.Err
+}
+
+// sessionLog is the log file of a single launch. Everything written to it
+// is also kept in a ring buffer of the last lines.
+type sessionLog struct {
+	consumer *state.Consumer
+	path     string
+	maxSize  int64
+
+	mu        sync.Mutex
+	f         *os.File
+	written   int64
+	truncated bool
+	streams   []*sessionLogStream
+	tail      *lineRing
+}
+
+// sessionLogStream is one of the outputs of a launch, teed to a session log.
+type sessionLogStream struct {
+	log     *sessionLog
+	w       io.Writer
+	partial []byte
+}
+
+// openSessionLog creates the log file of a new launch, or returns nil if log
+// capture isn't enabled. Failing to create it isn't fatal: a warning is
+// logged and the launch goes on without it.
+func openSessionLog(params RunnerParams) *sessionLog {
+	if !params.LogParams.Enabled {
+		return nil
+	}
+	consumer := params.Consumer
+	lp := params.LogParams.withDefaults(params.InstallFolder)
+
+	sl, err := createSessionLog(consumer, lp, time.Now())
+	if err != nil {
+		consumer.Warnf("Could not set up log capture: %s", err.Error())
+		return nil
+	}
+	consumer.Infof("Capturing game output to (%s)", sl.path)
+
+	err = rotateSessionLogs(lp.Dir, lp.MaxSessions)
+	if err != nil {
+		consumer.Warnf("Could not remove old session logs: %s", err.Error())
+	}
+	return sl
+}
+
+func createSessionLog(consumer *state.Consumer, lp LogParams, startTime time.Time) (*sessionLog, error) {
+	err := os.MkdirAll(lp.Dir, 0o755)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	path := filepath.Join(lp.Dir, startTime.Format(sessionLogTimeFormat)+sessionLogExt)
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return &sessionLog{
+		consumer: consumer,
+		path:     path,
+		maxSize:  lp.MaxSize,
+		f:        f,
+		tail:     newLineRing(lp.TailLines),
+	}, nil
+}
+
+// rotateSessionLogs removes the oldest session logs in dir, so that at most
+// maxSessions are left.
+func rotateSessionLogs(dir string, maxSessions int) error {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+
+	var names []string
+	for _, e := range entries {
+		if e.Type().IsRegular() && strings.HasSuffix(e.Name(), sessionLogExt) {
+			names = append(names, e.Name())
+		}
+	}
+	if len(names) <= maxSessions {
+		return nil
+	}
+
+	sort.Strings(names)
+	var errs []error
+	for _, name := range names[:len(names)-maxSessions] {
+		err := os.Remove(filepath.Join(dir, name))
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// writer returns a writer that tees to the log, then to w, which may be nil.
+// It may be called on a nil sessionLog, in which case it returns w as-is.
+func (sl *sessionLog) writer(w io.Writer) io.Writer {
+	if sl == nil {
+		return w
+	}
+	s := &sessionLogStream{log: sl, w: w}
+	sl.mu.Lock()
+	sl.streams = append(sl.streams, s)
+	sl.mu.Unlock()
+	return s
+}
+
+func (s *sessionLogStream) Write(p []byte) (int, error) {
+	s.log.write(s, p)
+	if s.w == nil {
+		return len(p), nil
+	}
+	return s.w.Write(p)
+}
+
+func (sl *sessionLog) write(s *sessionLogStream, p []byte) {
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if sl.f != nil && !sl.truncated {
+		chunk := p
+		if remaining := sl.maxSize - sl.written; int64(len(chunk)) > remaining {
+			chunk = chunk[:remaining]
+			sl.truncated = true
+		}
+		n, _ := sl.f.Write(chunk)
+		sl.written += int64(n)
+		if sl.truncated {
+			fmt.Fprintf(sl.f, "\n[log truncated after %d bytes]\n", sl.maxSize)
+		}
+	}
+
+	sl.tail.feed(&s.partial, p)
+}
+
+// finish closes the log once the launch is over, and records it in res. If
+// the launch failed, err is wrapped in a LogTailError. It may be called on
+// a nil sessionLog, in which case it returns err as-is.
+func (sl *sessionLog) finish(res *ExitResult, err error) error {
+	if sl == nil {
+		return err
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	for _, s := range sl.streams {
+		sl.tail.flush(&s.partial)
+	}
+	if sl.f != nil {
+		cerr := sl.f.Close()
+		if cerr != nil {
+			sl.consumer.Warnf("Could not write session log: %s", cerr.Error())
+		}
+		sl.f = nil
+	}
+
+	if res != nil {
+		res.LogPath = sl.path
+	}
+	if err == nil {
+		return nil
+	}
+	return &LogTailError{
+		Err:     err,
+		LogPath: sl.path,
+		Tail:    sl.tail.contents(),
+	}
+}
+
+// abort closes and removes the log of a launch that didn't start.
+func (sl *sessionLog) abort() {
+	if sl == nil {
+		return
+	}
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+
+	if sl.f != nil {
+		sl.f.Close()
+		sl.f = nil
+	}
+	os.Remove(sl.path)
+}
+
+// lineRing keeps the last lines written to it.
+type lineRing struct {
+	lines []string
+	next  int
+	full  bool
+}
+
+func newLineRing(n int) *lineRing {
+	return &lineRing{lines: make([]string, n)}
+}
+
+// feed adds the complete lines of p to the ring. partial holds the
+// unterminated end of what a stream wrote so far, which is pushed as-is if
+// it grows past maxLogLineLength.
+func (r *lineRing) feed(partial *[]byte, p []byte) {
+	*partial = append(*partial, p...)
+	for {
+		i := bytes.IndexByte(*partial, '\n')
+		if i < 0 {
+			break
+		}
+		r.push((*partial)[:i])
+		*partial = (*partial)[i+1:]
+	}
+	if len(*partial) > maxLogLineLength {
+		r.push(*partial)
+		*partial = nil
+	}
+}
+
+// flush pushes what's left in partial once a stream is done.
+func (r *lineRing) flush(partial *[]byte) {
+	if len(*partial) > 0 {
+		r.push(*partial)
+		*partial = nil
+	}
+}
+
+func (r *lineRing) push(line []byte) {
+	if len(r.lines) == 0 {
+		return
+	}
+	r.lines[r.next] = strings.TrimSuffix(string(line), "\r")
+	r.next = (r.next + 1) % len(r.lines)
+	if r.next == 0 {
+		r.full = true
+	}
+}
+
+// contents returns the lines in the ring, oldest first.
+func (r *lineRing) contents() []string {
+	if !r.full {
+		return append([]string(nil), r.lines[:r.next]...)
+	}
+	return append(append([]string(nil), r.lines[r.next:]...), r.lines[:r.next]...)
+}
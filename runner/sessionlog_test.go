@@ -0,0 +1,107 @@
+package runner
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSessionLog(t *testing.T, lp LogParams) *sessionLog {
+	t.Helper()
+	lp = lp.withDefaults(t.TempDir())
+	sl, err := createSessionLog(&state.Consumer{}, lp, time.Now())
+	require.NoError(t, err)
+	return sl
+}
+
+func TestSessionLogKeepsLastLines(t *testing.T) {
+	sl := newTestSessionLog(t, LogParams{TailLines: 3})
+	stdout := sl.writer(nil)
+	var stderrTarget bytes.Buffer
+	stderr := sl.writer(&stderrTarget)
+
+	fmt.Fprint(stdout, "one\ntwo\nthr")
+	fmt.Fprint(stderr, "oops\r\n")
+	fmt.Fprint(stdout, "ee\nfour\nunfinished")
+
+	res := newExitResult()
+	err := sl.finish(res, errors.New("exit status 1"))
+	assert.Equal(t, sl.path, res.LogPath)
+	assert.Equal(t, "oops\r\n", stderrTarget.String())
+
+	var tailErr *LogTailError
+	require.True(t, errors.As(err, &tailErr))
+	assert.EqualError(t, tailErr, "exit status 1")
+	assert.Equal(t, []string{"three", "four", "unfinished"}, tailErr.Tail)
+
+	logBytes, err := os.ReadFile(sl.path)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nthroops\r\nee\nfour\nunfinished", string(logBytes))
+}
+
+func TestSessionLogWithoutErrorReturnsNil(t *testing.T) {
+	sl := newTestSessionLog(t, LogParams{})
+	fmt.Fprintln(sl.writer(nil), "all good")
+	assert.NoError(t, sl.finish(newExitResult(), nil))
+
+	var nilLog *sessionLog
+	err := errors.New("exit status 1")
+	assert.Equal(t, err, nilLog.finish(nil, err))
+}
+
+func TestSessionLogIsCappedInSize(t *testing.T) {
+	sl := newTestSessionLog(t, LogParams{MaxSize: 10})
+	w := sl.writer(nil)
+	fmt.Fprintln(w, "0123456")
+	fmt.Fprintln(w, "789abcdef")
+	fmt.Fprintln(w, "ghijkl")
+	err := sl.finish(nil, errors.New("exit status 1"))
+
+	logBytes, rerr := os.ReadFile(sl.path)
+	require.NoError(t, rerr)
+	assert.Equal(t, "0123456\n78\n[log truncated after 10 bytes]\n", string(logBytes))
+
+	// The tail isn't subject to the size cap.
+	var tailErr *LogTailError
+	require.True(t, errors.As(err, &tailErr))
+	assert.Equal(t, []string{"0123456", "789abcdef", "ghijkl"}, tailErr.Tail)
+}
+
+func TestSessionLogAbortRemovesFile(t *testing.T) {
+	sl := newTestSessionLog(t, LogParams{})
+	sl.abort()
+	_, err := os.Stat(sl.path)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRotateSessionLogs(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	for i := range 5 {
+		name := start.Add(time.Duration(i)*time.Hour).Format(sessionLogTimeFormat) + sessionLogExt
+		require.NoError(t, os.WriteFile(filepath.Join(dir, name), nil, 0o644))
+	}
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "notes.txt"), nil, 0o644))
+
+	require.NoError(t, rotateSessionLogs(dir, 2))
+
+	var names []string
+	entries, err := os.ReadDir(dir)
+	require.NoError(t, err)
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	assert.Equal(t, []string{
+		"2024-03-01_15-00-00.000000.log",
+		"2024-03-01_16-00-00.000000.log",
+		"notes.txt",
+	}, names)
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package runner
+
+import "syscall"
+
+// signalName returns a human-readable name for sig.
+func signalName(sig syscall.Signal) string {
+	return sig.String()
+}
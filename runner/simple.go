@@ -12,6 +12,7 @@ type simpleRunner struct {
 }
 
 var _ Runner = (*simpleRunner)(nil)
+var _ Starter = (*simpleRunner)(nil)
 
 func newSimpleRunner(params RunnerParams) (Runner, error) {
 	sr := &simpleRunner{
@@ -25,35 +26,24 @@ func (sr *simpleRunner) Prepare() error {
 	return nil
 }
 
-func (sr *simpleRunner) Run() error {
+func (sr *simpleRunner) Start() (*Handle, error) {
 	params := sr.params
-	consumer := params.Consumer
 
 	cmd := exec.Command(params.FullTargetPath, params.Args...)
 	cmd.Dir = params.Dir
 	cmd.Env = params.Env
+	cmd.Stdin = params.Stdin
 	cmd.Stdout = params.Stdout
 	cmd.Stderr = params.Stderr
+	applyResourceLimits(params, cmd)
 
-	pg, err := NewProcessGroup(consumer, cmd, params.Ctx)
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	err = cmd.Start()
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
-
-	err = pg.AfterStart()
-	if err != nil {
-		return fmt.Errorf("%w", err)
-	}
+	return startCommand(params, cmd, BackendSimple)
+}
 
-	err = pg.Wait()
+func (sr *simpleRunner) Run() (*ExitResult, error) {
+	h, err := sr.Start()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
-
-	return nil
+	return h.Wait()
 }
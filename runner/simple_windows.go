@@ -14,6 +14,7 @@ type simpleRunner struct {
 }
 
 var _ Runner = (*simpleRunner)(nil)
+var _ Starter = (*simpleRunner)(nil)
 
 func newSimpleRunner(params RunnerParams) (Runner, error) {
 	sr := &simpleRunner{
@@ -27,13 +28,14 @@ func (sr *simpleRunner) Prepare() error {
 	return nil
 }
 
-func (sr *simpleRunner) Run() error {
+func (sr *simpleRunner) Start() (*Handle, error) {
 	params := sr.params
 	consumer := params.Consumer
 
 	cmd := execas.Command(params.FullTargetPath, params.Args...)
 	cmd.Dir = params.Dir
 	cmd.Env = params.Env
+	cmd.Stdin = params.Stdin
 	cmd.Stdout = params.Stdout
 	cmd.Stderr = params.Stderr
 
@@ -48,23 +50,33 @@ func (sr *simpleRunner) Run() error {
 
 	pg, err := NewProcessGroup(consumer, cmd, params.Ctx)
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
+	pg.timeLimits = params.TimeLimits
+
+	pg.log = openSessionLog(params)
+	pg.lines = newOutputLines(params)
+	cmd.Stdout = pg.lines.writer(OutputStdout, pg.log.writer(cmd.Stdout))
+	cmd.Stderr = pg.lines.writer(OutputStderr, pg.log.writer(cmd.Stderr))
 
 	err = cmd.Start()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		pg.log.abort()
+		return nil, fmt.Errorf("%w", err)
 	}
 
 	err = pg.AfterStart()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
 
-	err = pg.Wait()
+	return newHandle(pg, cmd.Process.Pid, BackendSimple), nil
+}
+
+func (sr *simpleRunner) Run() (*ExitResult, error) {
+	h, err := sr.Start()
 	if err != nil {
-		return fmt.Errorf("%w", err)
+		return nil, fmt.Errorf("%w", err)
 	}
-
-	return nil
+	return h.Wait()
 }
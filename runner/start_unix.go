@@ -0,0 +1,187 @@
+//go:build !windows
+
+package runner
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+)
+
+// startCommand launches cmd in its own process group and returns a Handle
+// that waits for it in the background.
+func startCommand(params RunnerParams, cmd *exec.Cmd, backend Backend) (*Handle, error) {
+	return startCommandWithTracker(params, cmd, backend, func() processTracker {
+		return newProcessTracker(params)
+	})
+}
+
+// startCommandWithTracker is startCommand for runners that track processes
+// their own way. newTracker is called right before cmd is started, and may
+// return nil.
+func startCommandWithTracker(params RunnerParams, cmd *exec.Cmd, backend Backend, newTracker func() processTracker) (*Handle, error) {
+	pg, err := NewProcessGroup(params.Consumer, cmd, params.Ctx)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	pg.shutdownPolicy = params.ShutdownPolicy
+	pg.timeLimits = params.TimeLimits
+
+	pg.log = openSessionLog(params)
+	pg.lines = newOutputLines(params)
+	pg.crash = newCrashCollector(params, cmd.Args, cmd.Env, backend)
+	cmd.Stdout = pg.lines.writer(OutputStdout, pg.log.writer(cmd.Stdout))
+	cmd.Stderr = pg.crash.stderrWriter(pg.lines.writer(OutputStderr, pg.log.writer(cmd.Stderr)))
+
+	if params.Console {
+		pg.console, err = attachConsole(params, cmd)
+		if err != nil {
+			pg.log.abort()
+			return nil, fmt.Errorf("while setting up console: %w", err)
+		}
+		if pg.console != nil {
+			pg.console.output = pg.lines.writer(OutputStdout, pg.log.writer(nil))
+		}
+	}
+
+	var stdinReader *os.File
+	if _, isFile := cmd.Stdin.(*os.File); cmd.Stdin != nil && !isFile {
+		stdinReader, pg.stdin, err = pipeStdin(cmd)
+		if err != nil {
+			pg.log.abort()
+			return nil, fmt.Errorf("while setting up stdin: %w", err)
+		}
+	}
+
+	if tracker := newTracker(); tracker != nil {
+		err = tracker.BeforeStart(cmd)
+		if err != nil {
+			params.Consumer.Warnf("Could not set up process tracking, falling back to process group: %s", err.Error())
+			tracker.Close()
+		} else {
+			pg.tracker = tracker
+		}
+	}
+
+	err = cmd.Start()
+	if err != nil {
+		// CLONE_INTO_CGROUP needs Linux 5.7, and a cgroup we may move
+		// processes into.
+		if retry, ok := withoutCgroupFD(cmd); ok {
+			params.Consumer.Warnf("Could not start process in its cgroup, falling back to process group: %s", err.Error())
+			pg.tracker.Close()
+			pg.tracker = nil
+			cmd = retry
+			pg.cmd = cmd
+			err = cmd.Start()
+		}
+	}
+	if err != nil {
+		if pg.tracker != nil {
+			pg.tracker.Close()
+		}
+		if pg.console != nil {
+			pg.console.Close()
+		}
+		if pg.stdin != nil {
+			stdinReader.Close()
+			pg.stdin.Close()
+		}
+		pg.log.abort()
+		return nil, fmt.Errorf("%w", err)
+	}
+	if stdinReader != nil {
+		stdinReader.Close()
+	}
+	if pg.console != nil {
+		pg.console.started()
+	}
+
+	err = pg.AfterStart()
+	if err != nil {
+		pg.abortStart()
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	return newHandle(pg, cmd.Process.Pid, backend), nil
+}
+
+// cloneCommand returns a copy of cmd that can be started, once cmd failed
+// to start.
+func cloneCommand(cmd *exec.Cmd) *exec.Cmd {
+	clone := &exec.Cmd{
+		Path:       cmd.Path,
+		Args:       cmd.Args,
+		Env:        cmd.Env,
+		Dir:        cmd.Dir,
+		Stdin:      cmd.Stdin,
+		Stdout:     cmd.Stdout,
+		Stderr:     cmd.Stderr,
+		ExtraFiles: cmd.ExtraFiles,
+		WaitDelay:  cmd.WaitDelay,
+	}
+	if cmd.SysProcAttr != nil {
+		attr := *cmd.SysProcAttr
+		clone.SysProcAttr = &attr
+	}
+	return clone
+}
+
+// newProcessTracker returns the process tracker requested by params, or nil
+// if none was requested or none could be set up. cgroup tracking is
+// preferred over subreaper tracking when both are enabled.
+func newProcessTracker(params RunnerParams) processTracker {
+	consumer := params.Consumer
+
+	if params.CgroupParams.Enabled {
+		tracker, err := newCgroupTracker(consumer, params.CgroupParams)
+		if err == nil {
+			return tracker
+		}
+		consumer.Warnf("Could not set up cgroup tracking: %s", err.Error())
+	}
+
+	if params.SubreaperParams.Enabled {
+		tracker, err := newSubreaperTracker(consumer)
+		if err == nil {
+			return tracker
+		}
+		consumer.Warnf("Could not set up subreaper tracking: %s", err.Error())
+	}
+
+	return nil
+}
+
+// newLaunchName returns a name for the container or unit of a launch, that
+// no other launch uses.
+func newLaunchName() (string, error) {
+	var id [6]byte
+	_, err := rand.Read(id[:])
+	if err != nil {
+		return "", fmt.Errorf("%w", err)
+	}
+	return "smaug-launch-" + hex.EncodeToString(id[:]), nil
+}
+
+// pipeStdin replaces cmd.Stdin with a pipe, fed from the original reader by
+// a goroutine. os/exec would do the same, except cmd.Wait would then wait
+// for the copy to finish, which never happens if the reader blocks. It
+// returns both ends of the pipe: the read end must be closed once the
+// process has started, and the write end once it has exited.
+func pipeStdin(cmd *exec.Cmd) (*os.File, *os.File, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, nil, fmt.Errorf("%w", err)
+	}
+
+	src := cmd.Stdin
+	cmd.Stdin = r
+	go func() {
+		io.Copy(w, src)
+		w.Close()
+	}()
+	return r, w, nil
+}
@@ -0,0 +1,220 @@
+//go:build linux
+
+package runner
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"golang.org/x/sys/unix"
+)
+
+const subreaperScanInterval = 500 * time.Millisecond
+
+var setSubreaperOnce sync.Once
+var setSubreaperErr error
+
+// subreaperTracker makes the launcher a child subreaper, so descendants
+// orphaned by the main process are reparented to us instead of init.
+// They're attributed to a launch if they were seen in its process tree,
+// or if they're still in its process group.
+type subreaperTracker struct {
+	consumer *state.Consumer
+	selfPid  int
+
+	mu      sync.Mutex
+	main    *os.Process
+	mainPid int
+	pgid    int
+	known   map[int]struct{}
+
+	stopScan chan struct{}
+	scanDone chan struct{}
+}
+
+var _ processTracker = (*subreaperTracker)(nil)
+
+func newSubreaperTracker(consumer *state.Consumer) (*subreaperTracker, error) {
+	setSubreaperOnce.Do(func() {
+		setSubreaperErr = unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+	})
+	if setSubreaperErr != nil {
+		return nil, fmt.Errorf("PR_SET_CHILD_SUBREAPER: %w", setSubreaperErr)
+	}
+
+	st := &subreaperTracker{
+		consumer: consumer,
+		selfPid:  os.Getpid(),
+		known:    make(map[int]struct{}),
+		stopScan: make(chan struct{}),
+		scanDone: make(chan struct{}),
+	}
+	return st, nil
+}
+
+func (st *subreaperTracker) BeforeStart(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (st *subreaperTracker) AfterStart(cmd *exec.Cmd) error {
+	pid := cmd.Process.Pid
+	pgid, err := syscall.Getpgid(pid)
+	if err != nil {
+		pgid = 0
+	}
+
+	st.mu.Lock()
+	st.main = cmd.Process
+	st.mainPid = pid
+	st.pgid = pgid
+	st.mu.Unlock()
+
+	st.consumer.Infof("Tracking process tree of %d as child subreaper", pid)
+
+	// Periodically record the tree so that descendants which later leave
+	// the process group can still be recognized once orphaned.
+	go func() {
+		defer close(st.scanDone)
+		ticker := time.NewTicker(subreaperScanInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-st.stopScan:
+				return
+			case <-ticker.C:
+				st.members()
+			}
+		}
+	}()
+	return nil
+}
+
+// members returns the live descendants of the main process (excluding the
+// main process itself), reaping any orphan that has already exited.
+func (st *subreaperTracker) members() []procStat {
+	stats, err := listProcStats()
+	if err != nil {
+		st.consumer.Warnf("Could not list processes: %s", err.Error())
+		return nil
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	byParent := make(map[int][]procStat)
+	var roots []procStat
+	for _, ps := range stats {
+		byParent[ps.ppid] = append(byParent[ps.ppid], ps)
+		if ps.pid == st.mainPid {
+			continue
+		}
+		if ps.ppid != st.selfPid {
+			continue
+		}
+		_, wasKnown := st.known[ps.pid]
+		if wasKnown || (st.pgid != 0 && ps.pgrp == st.pgid) {
+			roots = append(roots, ps)
+		}
+	}
+
+	known := make(map[int]struct{})
+	var out []procStat
+	queue := append([]procStat{}, byParent[st.mainPid]...)
+	queue = append(queue, roots...)
+	for len(queue) > 0 {
+		ps := queue[0]
+		queue = queue[1:]
+		if _, seen := known[ps.pid]; seen {
+			continue
+		}
+		known[ps.pid] = struct{}{}
+
+		if ps.state == 'Z' {
+			if ps.ppid == st.selfPid {
+				st.reap(ps.pid)
+			}
+			continue
+		}
+		out = append(out, ps)
+		queue = append(queue, byParent[ps.pid]...)
+	}
+	st.known = known
+
+	sort.Slice(out, func(i, j int) bool { return out[i].pid < out[j].pid })
+	return out
+}
+
+// reap collects the exit status of an orphan reparented to us. Only pids
+// attributed to this launch are waited for, so that children started
+// elsewhere in the launcher (e.g. by os/exec) are left alone.
+func (st *subreaperTracker) reap(pid int) {
+	var ws syscall.WaitStatus
+	_, err := syscall.Wait4(pid, &ws, syscall.WNOHANG, nil)
+	if err != nil && !errors.Is(err, syscall.ECHILD) {
+		st.consumer.Warnf("Could not reap orphan %d: %s", pid, err.Error())
+	}
+}
+
+func (st *subreaperTracker) Processes() []ProcessInfo {
+	var out []ProcessInfo
+	for _, ps := range st.members() {
+		out = append(out, ProcessInfo{Pid: ps.pid, Command: ps.comm})
+	}
+	return out
+}
+
+func (st *subreaperTracker) Signal(sig syscall.Signal) error {
+	st.mu.Lock()
+	main := st.main
+	st.mu.Unlock()
+
+	// os.Process knows whether the main process was already reaped, so
+	// this can't hit a recycled pid.
+	err := main.Signal(sig)
+	if err != nil && !errors.Is(err, os.ErrProcessDone) {
+		st.consumer.Warnf("Could not signal pid %d: %s", main.Pid, err.Error())
+	}
+	for _, ps := range st.members() {
+		// A recycled pid would have a different parent.
+		err := signalPid(ps.pid, sig, func() bool {
+			current, err := readProcStat(ps.pid)
+			return err == nil && current.ppid == ps.ppid
+		})
+		if err != nil && !errors.Is(err, syscall.ESRCH) {
+			st.consumer.Warnf("Could not signal pid %d: %s", ps.pid, err.Error())
+		}
+	}
+	return nil
+}
+
+func (st *subreaperTracker) Kill() error {
+	return st.Signal(syscall.SIGKILL)
+}
+
+func (st *subreaperTracker) Empty() bool {
+	return len(st.members()) == 0
+}
+
+func (st *subreaperTracker) Report(res *ExitResult) {}
+
+func (st *subreaperTracker) Close() error {
+	select {
+	case <-st.stopScan:
+	default:
+		close(st.stopScan)
+	}
+	st.mu.Lock()
+	attached := st.main != nil
+	st.mu.Unlock()
+	if attached {
+		<-st.scanDone
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+//go:build linux
+
+package runner
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/itchio/headway/state"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSubreaperTestParams(t *testing.T, ctx context.Context) RunnerParams {
+	t.Helper()
+	return RunnerParams{
+		Consumer: &state.Consumer{
+			OnMessage: func(lvl string, msg string) {
+				t.Logf("[%s] %s", lvl, msg)
+			},
+		},
+		Ctx:             ctx,
+		SubreaperParams: SubreaperParams{Enabled: true},
+	}
+}
+
+func TestSubreaperWaitsForBackgroundedProcesses(t *testing.T) {
+	params := newSubreaperTestParams(t, context.Background())
+
+	cmd := exec.Command("sh", "-c", "sleep 0.5 & exit 0")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+	require.NotNil(t, h.pg.tracker, "expected subreaper tracking to be enabled")
+
+	res, err := h.Wait()
+	require.NoError(t, err)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.GreaterOrEqual(t, res.Duration, 400*time.Millisecond)
+	require.Len(t, res.Survivors, 1)
+	assert.Equal(t, "sleep", res.Survivors[0].Command)
+}
+
+func TestSubreaperKillsBackgroundedProcessesOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	params := newSubreaperTestParams(t, ctx)
+
+	cmd := exec.Command("sh", "-c", "sleep 30 & exit 0")
+	h, err := startCommand(params, cmd, BackendSimple)
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	survivors := h.pg.tracker.Processes()
+	require.Len(t, survivors, 1)
+
+	cancel()
+
+	select {
+	case <-h.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("Wait() did not return within 5 seconds after context cancellation")
+	}
+
+	res, _ := h.Wait()
+	assert.True(t, res.Cancelled)
+	assert.False(t, processRunning(survivors[0].Pid), "backgrounded process should have been killed")
+}
+
+// failingTracker fails to start tracking, after the process has started.
+type failingTracker struct {
+	processTracker
+	closed bool
+}
+
+func (ft *failingTracker) AfterStart(cmd *exec.Cmd) error {
+	return errors.New("tracking failed")
+}
+
+func (ft *failingTracker) Close() error {
+	ft.closed = true
+	return ft.processTracker.Close()
+}
+
+func TestStartReapsProcessWhenTrackingFails(t *testing.T) {
+	params := newSubreaperTestParams(t, context.Background())
+
+	var tracker *failingTracker
+	cmd := exec.Command("sleep", "30")
+	_, err := startCommandWithTracker(params, cmd, BackendSimple, func() processTracker {
+		tracker = &failingTracker{processTracker: newProcessTracker(params)}
+		return tracker
+	})
+	require.Error(t, err)
+	require.NotNil(t, cmd.ProcessState, "expected the process to be reaped")
+	assert.False(t, cmd.ProcessState.Success())
+	assert.True(t, tracker.closed)
+}
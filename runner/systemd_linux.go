@@ -0,0 +1,386 @@
+//go:build linux
+
+package runner
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/itchio/headway/state"
+	"golang.org/x/sys/unix"
+)
+
+type systemdRunner struct {
+	params RunnerParams
+}
+
+var _ Runner = (*systemdRunner)(nil)
+var _ Starter = (*systemdRunner)(nil)
+var systemdRunCommand = exec.Command
+var systemctlCommand = exec.Command
+
+// systemdRlimitProperties maps rlimit names to the unit properties that set
+// them.
+var systemdRlimitProperties = map[string]string{
+	"as":     "LimitAS",
+	"nofile": "LimitNOFILE",
+	"core":   "LimitCORE",
+	"cpu":    "LimitCPU",
+	"nproc":  "LimitNPROC",
+}
+
+// systemdSystemPaths stay visible, read-only, with ProtectSystem=strict, so
+// the bubblewrap mounts of them aren't turned into bind paths.
+var systemdSystemPaths = []string{"/usr", "/lib", "/lib64", "/bin", "/sbin", "/etc", "/sys"}
+
+func newSystemdRunner(params RunnerParams) (Runner, error) {
+	if params.SystemdParams.BinaryPath == "" {
+		return nil, fmt.Errorf("SystemdParams.BinaryPath must be set")
+	}
+
+	sr := &systemdRunner{
+		params: params,
+	}
+	return sr, nil
+}
+
+func (sr *systemdRunner) Prepare() error {
+	// nothing to prepare
+	return nil
+}
+
+// Start runs the game in a transient unit of the user's service manager.
+// In a scope, the game is still a child of the launcher, and systemd only
+// groups and limits its processes. In a service, systemd starts the game
+// itself, sandboxing it if asked to, and systemd-run relays its output and
+// exit code.
+func (sr *systemdRunner) Start() (*Handle, error) {
+	params := sr.params
+	consumer := params.Consumer
+
+	scope := params.SystemdParams.Scope
+	if scope && params.Sandbox {
+		consumer.Warnf("Sandboxing needs a systemd service, not running in a scope")
+		scope = false
+	}
+
+	unit, err := newLaunchName()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if scope {
+		unit += ".scope"
+	} else {
+		unit += ".service"
+	}
+
+	properties, err := systemdProperties(params, scope)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+
+	msg := fmt.Sprintf("Running (%s) in systemd unit %s", params.FullTargetPath, unit)
+	if params.Sandbox && launchSandboxPolicy(params).Network == NetworkNone {
+		msg += " (networking disabled)"
+	}
+	consumer.Opf("%s", msg)
+
+	args := []string{"--user", "--quiet", "--unit=" + unit}
+	if scope {
+		args = append(args, "--scope")
+	} else {
+		// --wait makes systemd-run exit with the service's exit code, and
+		// --pipe hands it the launcher's stdin, stdout and stderr.
+		args = append(args, "--wait", "--pipe")
+		if params.Dir != "" {
+			args = append(args, "--working-directory="+params.Dir)
+		}
+		// Services start from the service manager's environment, not the
+		// launcher's, so pass what a sandbox would.
+		for _, kv := range launchSandboxPolicy(params).Env.environ(params.Env, os.Environ()) {
+			args = append(args, "--setenv="+kv)
+		}
+	}
+	for _, property := range properties {
+		args = append(args, "--property="+property)
+	}
+	args = append(args, "--")
+	args = append(args, params.FullTargetPath)
+	args = append(args, params.Args...)
+
+	cmd := systemdRunCommand(params.SystemdParams.BinaryPath, args...)
+	cmd.Dir = params.Dir
+	cmd.Stdin = params.Stdin
+	cmd.Stdout = params.Stdout
+	cmd.Stderr = params.Stderr
+	if scope {
+		// systemd-run execs into the game, which inherits the limits.
+		cmd.Env = params.Env
+		applyResourceLimits(params, cmd)
+	}
+
+	tracker := &systemdUnitTracker{
+		consumer:      consumer,
+		systemctlPath: filepath.Join(filepath.Dir(params.SystemdParams.BinaryPath), "systemctl"),
+		unit:          unit,
+	}
+	return startCommandWithTracker(params, cmd, BackendSystemd, func() processTracker {
+		return tracker
+	})
+}
+
+func (sr *systemdRunner) Run() (*ExitResult, error) {
+	h, err := sr.Start()
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	return h.Wait()
+}
+
+// systemdProperties returns the unit properties of a launch, as
+// "Name=value" strings for systemd-run --property.
+func systemdProperties(params RunnerParams, scope bool) ([]string, error) {
+	consumer := params.Consumer
+	var properties []string
+
+	// Limits apply to the unit's cgroup as a whole.
+	limits := params.CgroupParams
+	if limits.MemoryMax > 0 {
+		properties = append(properties, fmt.Sprintf("MemoryMax=%d", limits.MemoryMax))
+	}
+	if limits.CPUQuota > 0 {
+		percent := max(int64(math.Round(limits.CPUQuota*100)), 1)
+		properties = append(properties, fmt.Sprintf("CPUQuota=%d%%", percent))
+	}
+	if limits.PidsMax > 0 {
+		properties = append(properties, fmt.Sprintf("TasksMax=%d", limits.PidsMax))
+	}
+
+	if scope {
+		// Scopes are made of processes that already run, so rlimits are
+		// applied by the rlimit shim instead, and there's nothing to
+		// sandbox.
+		return properties, nil
+	}
+
+	for _, l := range launchRlimits(params) {
+		value := strconv.FormatUint(l.value, 10)
+		if l.value == unix.RLIM_INFINITY {
+			value = "infinity"
+		}
+		properties = append(properties, systemdRlimitProperties[l.name]+"="+value)
+	}
+
+	if !params.Sandbox {
+		return properties, nil
+	}
+
+	properties = append(properties,
+		"NoNewPrivileges=yes",
+		"ProtectSystem=strict",
+		"ProtectHome=tmpfs",
+		"PrivateTmp=yes",
+	)
+
+	if params.SandboxConfig.Landlock {
+		consumer.Warnf("Landlock isn't supported by the systemd sandbox, ignoring it")
+		params.SandboxConfig.Landlock = false
+	}
+
+	// Expose what the bubblewrap sandbox would mount: the game's home,
+	// install folder and temp directory, and display and audio sockets.
+	spec, err := parseNativeSandboxArgs(bubblewrapArgs(params))
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if spec.unshareNet {
+		properties = append(properties, "PrivateNetwork=yes")
+	}
+	if spec.unshareIPC {
+		properties = append(properties, "PrivateIPC=yes")
+	}
+	for _, op := range spec.ops {
+		var name string
+		switch op.kind {
+		case "bind":
+			name = "BindPaths"
+		case "ro-bind":
+			if op.source == op.target && slices.Contains(systemdSystemPaths, op.source) {
+				continue
+			}
+			name = "BindReadOnlyPaths"
+		case "tmpfs":
+			if op.target == "/tmp" {
+				continue
+			}
+			// Denied folders, hidden in place.
+			if strings.ContainsAny(op.target, " \t\"\\:") {
+				consumer.Warnf("Can't pass (%s) to systemd, not hiding it from the game", op.target)
+				continue
+			}
+			properties = append(properties, "TemporaryFileSystem="+op.target)
+			continue
+		default:
+			// Devices, /proc and /tmp are set up by systemd, and mount
+			// points are created as needed.
+			continue
+		}
+		entry, ok := systemdBindPath(op.source, op.target)
+		if !ok {
+			consumer.Warnf("Can't pass (%s) to systemd, not exposing it to the game", op.source)
+			continue
+		}
+		properties = append(properties, name+"="+entry)
+	}
+
+	names, err := seccompNativeDenyList(params.SandboxConfig)
+	if err != nil {
+		return nil, fmt.Errorf("%w", err)
+	}
+	if len(names) > 0 {
+		properties = append(properties,
+			"SystemCallFilter=~"+strings.Join(names, " "),
+			"SystemCallErrorNumber=EPERM",
+		)
+	}
+
+	return properties, nil
+}
+
+// systemdBindPath formats a BindPaths= or BindReadOnlyPaths= entry. Paths
+// are quoted, so they may contain spaces and colons. It returns false for
+// paths that would need escaping on top of that.
+func systemdBindPath(source, target string) (string, bool) {
+	if strings.ContainsAny(source+target, "\"\\") {
+		return "", false
+	}
+	entry := `"` + source + `"`
+	if target != source {
+		entry += `:"` + target + `"`
+	}
+	return entry, true
+}
+
+// systemdUnitTracker tracks a launch through the transient unit it runs in,
+// with systemctl. systemd knows every process of the unit, wherever they
+// were forked from.
+type systemdUnitTracker struct {
+	consumer      *state.Consumer
+	systemctlPath string
+	unit          string
+
+	// The process systemd-run became or started the unit from.
+	main *os.Process
+}
+
+var _ processTracker = (*systemdUnitTracker)(nil)
+var _ freezer = (*systemdUnitTracker)(nil)
+
+func (ut *systemdUnitTracker) systemctl(args ...string) (string, error) {
+	cmd := systemctlCommand(ut.systemctlPath, append([]string{"--user"}, args...)...)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("systemctl %s: %w", args[0], err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+func (ut *systemdUnitTracker) show(property string) (string, error) {
+	return ut.systemctl("show", "--property="+property, "--value", ut.unit)
+}
+
+func (ut *systemdUnitTracker) BeforeStart(cmd *exec.Cmd) error {
+	return nil
+}
+
+func (ut *systemdUnitTracker) AfterStart(cmd *exec.Cmd) error {
+	ut.main = cmd.Process
+	return nil
+}
+
+func (ut *systemdUnitTracker) Processes() []ProcessInfo {
+	controlGroup, err := ut.show("ControlGroup")
+	if err != nil || controlGroup == "" {
+		// The unit is gone, along with its processes.
+		return nil
+	}
+	mountPoint, err := cgroup2MountPoint()
+	if err != nil {
+		ut.consumer.Warnf("Could not list unit processes: %s", err.Error())
+		return nil
+	}
+
+	ct := &cgroupTracker{consumer: ut.consumer, path: filepath.Join(mountPoint, controlGroup)}
+	return ct.Processes()
+}
+
+func (ut *systemdUnitTracker) Signal(sig syscall.Signal) error {
+	_, err := ut.systemctl("kill", "--signal="+strconv.Itoa(int(sig)), ut.unit)
+	if err == nil {
+		return nil
+	}
+
+	// The unit may not be registered yet.
+	ut.consumer.Infof("Could not signal unit %s (%s), signalling process %d", ut.unit, err.Error(), ut.main.Pid)
+	err = ut.main.Signal(sig)
+	if err != nil {
+		return fmt.Errorf("%w", err)
+	}
+	return nil
+}
+
+func (ut *systemdUnitTracker) Kill() error {
+	return ut.Signal(syscall.SIGKILL)
+}
+
+// Empty returns true once the unit has stopped. Units that are gone are
+// shown as inactive, so failing to get the state says nothing about the
+// unit's processes: they're assumed to still be running.
+func (ut *systemdUnitTracker) Empty() bool {
+	activeState, err := ut.show("ActiveState")
+	if err != nil {
+		ut.consumer.Warnf("Could not get state of unit %s: %s", ut.unit, err.Error())
+		return false
+	}
+	return activeState == "inactive" || activeState == "failed"
+}
+
+// Freeze suspends every process of the unit with the cgroup freezer.
+func (ut *systemdUnitTracker) Freeze() error {
+	_, err := ut.systemctl("freeze", ut.unit)
+	return err
+}
+
+// Thaw resumes processes suspended by Freeze.
+func (ut *systemdUnitTracker) Thaw() error {
+	_, err := ut.systemctl("thaw", ut.unit)
+	return err
+}
+
+func (ut *systemdUnitTracker) Report(res *ExitResult) {
+	result, err := ut.show("Result")
+	if err != nil {
+		return
+	}
+	if result == "oom-kill" {
+		ut.consumer.Warnf("The out-of-memory killer killed processes of the launch")
+		res.OOMKilled = true
+	}
+}
+
+// Close unloads the unit if it failed. Units that succeeded are unloaded
+// by systemd as soon as they stop.
+func (ut *systemdUnitTracker) Close() error {
+	_, err := ut.systemctl("reset-failed", ut.unit)
+	if err != nil {
+		ut.consumer.Debugf("Could not reset unit %s: %s", ut.unit, err.Error())
+	}
+	return nil
+}
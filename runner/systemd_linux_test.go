@@ -0,0 +1,238 @@
+//go:build linux
+
+package runner
+
+import (
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// stubSystemd swaps systemd-run for a shell running script, and systemctl
+// for one that reports the unit as stopped with the given result. It
+// returns the arguments each was given.
+func stubSystemd(t *testing.T, script string, result string) (*[]string, *[][]string) {
+	t.Helper()
+	origRun := systemdRunCommand
+	origCtl := systemctlCommand
+	t.Cleanup(func() {
+		systemdRunCommand = origRun
+		systemctlCommand = origCtl
+	})
+
+	var runArgs []string
+	var ctlCalls [][]string
+	systemdRunCommand = func(name string, args ...string) *exec.Cmd {
+		runArgs = append([]string{name}, args...)
+		return exec.Command("sh", "-c", script)
+	}
+	systemctlCommand = func(name string, args ...string) *exec.Cmd {
+		ctlCalls = append(ctlCalls, append([]string{name}, args...))
+		return exec.Command("sh", "-c", `case "$*" in
+*ActiveState*) echo inactive ;;
+*Result*) echo "$0" ;;
+esac`, result, strings.Join(args, " "))
+	}
+	return &runArgs, &ctlCalls
+}
+
+func newSystemdTestParams(t *testing.T) (RunnerParams, *strings.Builder) {
+	t.Helper()
+	params, _ := newNativeTestParams(t, "true")
+	var stdout strings.Builder
+	params.Stdout = &stdout
+	params.SystemdParams.BinaryPath = "/fake/bin/systemd-run"
+	return params, &stdout
+}
+
+func TestSystemdScope(t *testing.T) {
+	runArgs, ctlCalls := stubSystemd(t, "echo nofile=$(ulimit -n)", "success")
+
+	params, stdout := newSystemdTestParams(t)
+	params.SystemdParams.Enabled = true
+	params.SystemdParams.Scope = true
+	params.CgroupParams.MemoryMax = 512 << 20
+	params.CgroupParams.CPUQuota = 1.5
+	params.CgroupParams.PidsMax = 64
+	params.ResourceLimits.OpenFiles = 256
+
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	require.IsType(t, &systemdRunner{}, r)
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.Equal(t, BackendSystemd, res.Backend)
+	assert.False(t, res.OOMKilled)
+	// Applied by the rlimit shim, since scopes take no rlimit properties.
+	assert.Equal(t, "nofile=256\n", stdout.String())
+
+	args := *runArgs
+	assert.Equal(t, "/fake/bin/systemd-run", args[0])
+	assert.Equal(t, []string{"--user", "--quiet"}, args[1:3])
+	require.True(t, strings.HasPrefix(args[3], "--unit=smaug-launch-"))
+	unit := strings.TrimPrefix(args[3], "--unit=")
+	assert.True(t, strings.HasSuffix(unit, ".scope"))
+	assert.Contains(t, args, "--scope")
+	assert.NotContains(t, args, "--wait")
+	assert.Contains(t, args, "--property=MemoryMax=536870912")
+	assert.Contains(t, args, "--property=CPUQuota=150%")
+	assert.Contains(t, args, "--property=TasksMax=64")
+	assert.NotContains(t, args, "--property=LimitNOFILE=256")
+	assert.Equal(t, []string{"--", "/bin/sh", "-c", "true"}, args[len(args)-4:])
+
+	calls := *ctlCalls
+	require.NotEmpty(t, calls)
+	for _, call := range calls {
+		assert.Equal(t, "/fake/bin/systemctl", call[0])
+		assert.Equal(t, "--user", call[1])
+		assert.Equal(t, unit, call[len(call)-1])
+	}
+	assert.Equal(t, "reset-failed", calls[len(calls)-1][2])
+}
+
+func TestSystemdService(t *testing.T) {
+	runArgs, _ := stubSystemd(t, "echo hello", "oom-kill")
+
+	params, stdout := newSystemdTestParams(t)
+	params.Dir = params.InstallFolder
+	params.ResourceLimits.OpenFiles = 256
+	params.ResourceLimits.DisableCoreDumps = true
+
+	r, err := newSystemdRunner(params)
+	require.NoError(t, err)
+	res, err := r.Run()
+	require.NoError(t, err)
+	assert.True(t, res.OOMKilled)
+	assert.Equal(t, "hello\n", stdout.String())
+
+	args := *runArgs
+	unit := strings.TrimPrefix(args[3], "--unit=")
+	assert.True(t, strings.HasSuffix(unit, ".service"))
+	assert.Contains(t, args, "--wait")
+	assert.Contains(t, args, "--pipe")
+	assert.NotContains(t, args, "--scope")
+	assert.Contains(t, args, "--working-directory="+params.InstallFolder)
+	assert.Contains(t, args, "--setenv=HOME=/home/player")
+	assert.Contains(t, args, "--setenv=PATH=/usr/bin:/bin")
+	assert.NotContains(t, args, "--setenv=SMAUG_TEST_SECRET=1")
+	assert.Contains(t, args, "--property=LimitNOFILE=256")
+	assert.Contains(t, args, "--property=LimitCORE=0")
+	assert.NotContains(t, args, "--property=ProtectSystem=strict")
+}
+
+func TestSystemdSandboxProperties(t *testing.T) {
+	params, _ := newSystemdTestParams(t)
+	params.Sandbox = true
+	params.SandboxConfig.Type = SandboxTypeSystemd
+	params.SandboxConfig.NoNetwork = true
+	params.SandboxConfig.Seccomp = SeccompPresetDefault
+	params.TempDir = filepath.Join(t.TempDir(), "temp dir")
+	params.CgroupParams.MemoryMax = 1 << 30
+
+	properties, err := systemdProperties(params, false)
+	require.NoError(t, err)
+	for _, property := range []string{
+		"MemoryMax=1073741824",
+		"NoNewPrivileges=yes",
+		"ProtectSystem=strict",
+		"ProtectHome=tmpfs",
+		"PrivateTmp=yes",
+		"PrivateNetwork=yes",
+		`BindPaths="` + params.InstallFolder + `"`,
+		`BindPaths="` + params.TempDir + `"`,
+		`BindPaths="` + filepath.Join(params.InstallFolder, ".itch", "home") + `":"/home/player"`,
+		"SystemCallErrorNumber=EPERM",
+	} {
+		assert.Contains(t, properties, property)
+	}
+	assert.NotContains(t, properties, `BindReadOnlyPaths="/usr"`)
+
+	if _, ok := seccompArchitectures[runtime.GOARCH]; ok {
+		i := slices.IndexFunc(properties, func(p string) bool { return strings.HasPrefix(p, "SystemCallFilter=~") })
+		require.NotEqual(t, -1, i)
+		assert.Contains(t, strings.Fields(strings.TrimPrefix(properties[i], "SystemCallFilter=~")), "ptrace")
+	}
+
+	// Scopes only get limits.
+	properties, err = systemdProperties(params, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"MemoryMax=1073741824"}, properties)
+}
+
+func TestSystemdBindPath(t *testing.T) {
+	entry, ok := systemdBindPath("/games/My Game", "/games/My Game")
+	assert.True(t, ok)
+	assert.Equal(t, `"/games/My Game"`, entry)
+
+	entry, ok = systemdBindPath("/games/a:b/.itch/home", "/home/player")
+	assert.True(t, ok)
+	assert.Equal(t, `"/games/a:b/.itch/home":"/home/player"`, entry)
+
+	_, ok = systemdBindPath(`/games/"quoted"`, `/games/"quoted"`)
+	assert.False(t, ok)
+}
+
+func TestSystemdUnitTrackerEmpty(t *testing.T) {
+	origCtl := systemctlCommand
+	t.Cleanup(func() {
+		systemctlCommand = origCtl
+	})
+
+	params, _ := newSystemdTestParams(t)
+	ut := &systemdUnitTracker{
+		consumer:      params.Consumer,
+		systemctlPath: "/fake/bin/systemctl",
+		unit:          "smaug-launch-1.service",
+	}
+	for _, tc := range []struct {
+		script string
+		empty  bool
+	}{
+		{"echo active", false},
+		{"echo failed", true},
+		// What systemctl shows for units that are gone.
+		{"echo inactive", true},
+		// The user manager may not answer, the unit may still be running.
+		{"exit 1", false},
+	} {
+		systemctlCommand = func(name string, args ...string) *exec.Cmd {
+			return exec.Command("sh", "-c", tc.script)
+		}
+		assert.Equal(t, tc.empty, ut.Empty(), "%s", tc.script)
+	}
+}
+
+func TestSystemdRunnerSelection(t *testing.T) {
+	params, _ := newSystemdTestParams(t)
+	params.Sandbox = true
+	params.SandboxConfig.Type = SandboxTypeSystemd
+	r, err := GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &systemdRunner{}, r)
+
+	params.SystemdParams.BinaryPath = ""
+	_, err = GetRunner(params)
+	assert.Error(t, err)
+
+	params.Sandbox = false
+	r, err = GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &simpleRunner{}, r)
+
+	// Only opted into explicitly.
+	params.SystemdParams.BinaryPath = "/fake/bin/systemd-run"
+	r, err = GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &simpleRunner{}, r)
+
+	params.SystemdParams.Enabled = true
+	r, err = GetRunner(params)
+	require.NoError(t, err)
+	assert.IsType(t, &systemdRunner{}, r)
+}
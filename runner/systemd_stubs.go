@@ -0,0 +1,12 @@
+//go:build !linux
+
+package runner
+
+import (
+	"fmt"
+	"runtime"
+)
+
+func newSystemdRunner(params RunnerParams) (Runner, error) {
+	return nil, fmt.Errorf("systemd runner is not implemented on %s", runtime.GOOS)
+}
@@ -2,8 +2,11 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
+	"os/signal"
 	"strconv"
+	"syscall"
 	"time"
 )
 
@@ -47,6 +50,20 @@ func main() {
 			os.Exit(1)
 		}
 		os.Exit(code)
+	case "fail":
+		if len(args) < 1 {
+			fmt.Fprintf(os.Stderr, "fail requires an exit code\n")
+			os.Exit(1)
+		}
+		code, err := strconv.Atoi(args[0])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid exit code: %s\n", args[0])
+			os.Exit(1)
+		}
+		for _, msg := range args[1:] {
+			fmt.Fprintln(os.Stderr, msg)
+		}
+		os.Exit(code)
 	case "sleep":
 		if len(args) != 1 {
 			fmt.Fprintf(os.Stderr, "sleep requires exactly one argument\n")
@@ -58,6 +75,23 @@ func main() {
 			os.Exit(1)
 		}
 		time.Sleep(time.Duration(ms) * time.Millisecond)
+	case "kill-self":
+		p, err := os.FindProcess(os.Getpid())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "findprocess: %s\n", err)
+			os.Exit(1)
+		}
+		p.Kill()
+		time.Sleep(10 * time.Second)
+	case "ignore-term":
+		signal.Ignore(syscall.SIGTERM)
+		time.Sleep(30 * time.Second)
+	case "cat":
+		_, err := io.Copy(os.Stdout, os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "copy: %s\n", err)
+			os.Exit(1)
+		}
 	case "cwd":
 		dir, err := os.Getwd()
 		if err != nil {
@@ -0,0 +1,245 @@
+package runner
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/itchio/headway/state"
+)
+
+// TimeLimitKind identifies which session time limit ended a launch.
+type TimeLimitKind string
+
+const (
+	TimeLimitNone      TimeLimitKind = ""
+	TimeLimitWallClock TimeLimitKind = "wall-clock"
+	TimeLimitCPU       TimeLimitKind = "cpu"
+)
+
+// TimeLimits ends a session after a given time, e.g. for demo or kiosk
+// builds. Once a limit is reached, the process group is shut down as if
+// RunnerParams.Ctx had been cancelled (following ShutdownPolicy), and the
+// limit is recorded in ExitResult.TimeLimit.
+type TimeLimits struct {
+	// Maximum wall-clock duration of the session. Zero means no limit.
+	WallClock time.Duration
+
+	// Maximum CPU time used by all processes of the launch, combined. It is
+	// exact with cgroup tracking, and approximate otherwise. Linux only.
+	// Zero means no limit.
+	CPUTime time.Duration
+
+	// How long before a limit is reached OnWarning is called. For CPUTime,
+	// this is an amount of CPU time left, not a wall-clock delay.
+	WarnBefore time.Duration
+
+	// Called at most once per limit, when WarnBefore is left until it's
+	// reached. It's called from a separate goroutine.
+	OnWarning func(kind TimeLimitKind, remaining time.Duration)
+}
+
+// cpuLimitPollInterval is how often CPU usage is checked against
+// TimeLimits.CPUTime.
+var cpuLimitPollInterval = time.Second
+
+// timeLimitError is the cancellation cause of a session that hit a limit.
+type timeLimitError struct {
+	kind  TimeLimitKind
+	limit time.Duration
+}
+
+func (e *timeLimitError) Error() string {
+	return fmt.Sprintf("%s time limit of %s reached", e.kind, e.limit)
+}
+
+// timeLimitCause returns the time limit that cancelled ctx, if any.
+func timeLimitCause(ctx context.Context) TimeLimitKind {
+	var tle *timeLimitError
+	if errors.As(context.Cause(ctx), &tle) {
+		return tle.kind
+	}
+	return TimeLimitNone
+}
+
+// timeLimitWatcher enforces TimeLimits for a session.
+type timeLimitWatcher struct {
+	ctx    context.Context
+	cancel context.CancelCauseFunc
+
+	pauses  chan bool
+	stopped chan struct{}
+	done    chan struct{}
+}
+
+// watchTimeLimits starts enforcing limits. The watcher's context is
+// cancelled like ctx, or when a limit is reached. cpuTime reports the CPU
+// time used so far by the launch, and may be nil if it can't be measured.
+// Stop must be called once the session is over.
+func watchTimeLimits(ctx context.Context, consumer *state.Consumer, limits TimeLimits, cpuTime func() (time.Duration, error)) *timeLimitWatcher {
+	if limits.WallClock <= 0 && limits.CPUTime <= 0 {
+		return &timeLimitWatcher{ctx: ctx}
+	}
+
+	limitedCtx, cancel := context.WithCancelCause(ctx)
+	w := &timeLimitWatcher{
+		ctx:     limitedCtx,
+		cancel:  cancel,
+		pauses:  make(chan bool, 1),
+		stopped: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	if limits.WallClock > 0 {
+		consumer.Infof("Session wall-clock time limit: %s", limits.WallClock)
+	}
+	if limits.CPUTime > 0 {
+		if cpuTime == nil {
+			consumer.Warnf("CPU time limits are not supported here, ignoring")
+			limits.CPUTime = 0
+		} else {
+			consumer.Infof("Session CPU time limit: %s", limits.CPUTime)
+		}
+	}
+
+	go w.run(consumer, limits, cpuTime)
+	return w
+}
+
+func (w *timeLimitWatcher) run(consumer *state.Consumer, limits TimeLimits, cpuTime func() (time.Duration, error)) {
+	defer close(w.done)
+
+	warn := func(kind TimeLimitKind, remaining time.Duration) {
+		consumer.Infof("Session %s time limit reached in %s", kind, remaining)
+		if limits.OnWarning != nil {
+			limits.OnWarning(kind, remaining)
+		}
+	}
+	reached := func(kind TimeLimitKind, limit time.Duration) {
+		consumer.Warnf("Session %s time limit of %s reached, shutting down", kind, limit)
+		w.cancel(&timeLimitError{kind: kind, limit: limit})
+	}
+
+	// The wall-clock limit only counts time spent unpaused, so its timers
+	// are stopped on pause and re-armed with what's left on resume.
+	var wallWarning, wallLimit, cpuTicks <-chan time.Time
+	var warningTimer, limitTimer *time.Timer
+	wallWarned := false
+	var activeBefore time.Duration
+	var runningSince time.Time
+	armWallClock := func() {
+		runningSince = time.Now()
+		remaining := limits.WallClock - activeBefore
+		if !wallWarned && limits.WarnBefore > 0 && limits.WarnBefore < remaining {
+			warningTimer = time.NewTimer(remaining - limits.WarnBefore)
+			wallWarning = warningTimer.C
+		}
+		limitTimer = time.NewTimer(remaining)
+		wallLimit = limitTimer.C
+	}
+	disarmWallClock := func() {
+		activeBefore += time.Since(runningSince)
+		if warningTimer != nil {
+			warningTimer.Stop()
+			warningTimer, wallWarning = nil, nil
+		}
+		if limitTimer != nil {
+			limitTimer.Stop()
+			limitTimer, wallLimit = nil, nil
+		}
+	}
+
+	if limits.WallClock > 0 {
+		armWallClock()
+		defer disarmWallClock()
+	}
+	if limits.CPUTime > 0 {
+		ticker := time.NewTicker(cpuLimitPollInterval)
+		defer ticker.Stop()
+		cpuTicks = ticker.C
+	}
+
+	paused := false
+	cpuWarned := false
+	for {
+		select {
+		case <-w.stopped:
+			return
+		case <-w.ctx.Done():
+			return
+		case p := <-w.pauses:
+			if p == paused || limits.WallClock <= 0 {
+				paused = p
+				continue
+			}
+			paused = p
+			if paused {
+				disarmWallClock()
+			} else {
+				armWallClock()
+			}
+		case <-wallWarning:
+			wallWarned = true
+			warn(TimeLimitWallClock, limits.WarnBefore)
+		case <-wallLimit:
+			reached(TimeLimitWallClock, limits.WallClock)
+			return
+		case <-cpuTicks:
+			used, err := cpuTime()
+			if err != nil {
+				consumer.Debugf("Could not measure CPU time: %s", err.Error())
+				continue
+			}
+			remaining := limits.CPUTime - used
+			if remaining <= 0 {
+				reached(TimeLimitCPU, limits.CPUTime)
+				return
+			}
+			if !cpuWarned && remaining <= limits.WarnBefore {
+				cpuWarned = true
+				warn(TimeLimitCPU, remaining)
+			}
+		}
+	}
+}
+
+// Context returns the context the session should be shut down on.
+func (w *timeLimitWatcher) Context() context.Context {
+	return w.ctx
+}
+
+// SetPaused tells the watcher whether the session is paused, so that the
+// wall-clock limit doesn't count paused time. It never blocks, so that it
+// may be called from OnWarning.
+func (w *timeLimitWatcher) SetPaused(paused bool) {
+	if w.pauses == nil {
+		return
+	}
+	for {
+		select {
+		case w.pauses <- paused:
+			return
+		default:
+		}
+		// Replace a state the watcher hasn't seen yet.
+		select {
+		case <-w.pauses:
+		default:
+		}
+	}
+}
+
+// Stop stops enforcing limits, without cancelling the session.
+func (w *timeLimitWatcher) Stop() {
+	if w.stopped == nil {
+		return
+	}
+	select {
+	case <-w.stopped:
+	default:
+		close(w.stopped)
+	}
+	<-w.done
+	w.cancel(nil)
+}
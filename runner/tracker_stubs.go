@@ -0,0 +1,23 @@
+//go:build !linux && !windows
+
+package runner
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+
+	"github.com/itchio/headway/state"
+)
+
+func newCgroupTracker(consumer *state.Consumer, params CgroupParams) (processTracker, error) {
+	return nil, fmt.Errorf("cgroup tracking is not supported on %s", runtime.GOOS)
+}
+
+func newSubreaperTracker(consumer *state.Consumer) (processTracker, error) {
+	return nil, fmt.Errorf("subreaper tracking is not supported on %s", runtime.GOOS)
+}
+
+func withoutCgroupFD(cmd *exec.Cmd) (*exec.Cmd, bool) {
+	return nil, false
+}